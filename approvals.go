@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/audit"
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/requests"
+	"github.com/urfave/cli/v2"
+)
+
+// default lifetime in days for queued requests that do not specify one
+const defaultRequestLifetime = 90
+
+var requestsCommand = &cli.Command{
+	Name:  "requests",
+	Usage: "review certificate requests waiting for approval",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "list pending requests",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "include denied and issued requests",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return ListRequests(c.Bool("all"), c.String("basedir"))
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "show the subject and subject alternative names of a request",
+			ArgsUsage: "<id>",
+			Action: func(c *cli.Context) error {
+				return ShowRequest(c.Args().First(), c.String("basedir"))
+			},
+		},
+		{
+			Name:  "submit",
+			Usage: "queue an existing csr for approval",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "csr",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "name",
+					Aliases: []string{"n"},
+					Usage:   "defaults to the common name of the csr",
+				},
+				&cli.StringFlag{
+					Name:  "profile",
+					Usage: "issuance profile from config.json in the base directory to sign it with",
+				},
+				&cli.IntFlag{
+					Name:    "lifetime",
+					Aliases: []string{"t"},
+				},
+			}, metadataFlags...),
+			Action: func(c *cli.Context) error {
+				metadata, err := metadataFromFlags(c)
+				if err != nil {
+					return err
+				}
+				return SubmitRequest(c.String("csr"), c.String("name"), c.String("profile"), metadata, c.Int("lifetime"), c.String("basedir"))
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "approve a request, signing it once it has enough approvals",
+			ArgsUsage: "<id>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "reason",
+				},
+				&cli.StringFlag{
+					Name:    "password",
+					Aliases: []string{"p"},
+					Value:   "",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return ApproveRequest(c.Args().First(), c.String("reason"), c.String("password"), c.String("basedir"))
+			},
+		},
+		{
+			Name:      "deny",
+			Usage:     "deny a request",
+			ArgsUsage: "<id>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "reason",
+					Required: true,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return DenyRequest(c.Args().First(), c.String("reason"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+// ingestRequests queues any csrs dropped into the incoming directory
+func ingestRequests(baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	queued, err := requests.Ingest(requestLifetime(conf), baseDir)
+	for _, req := range queued {
+		auditErr := audited(baseDir, "request.submit", map[string]string{
+			"request":   req.ID,
+			"name":      req.Name,
+			"source":    requests.SourceFile,
+			"requester": req.Requester,
+		}, nil)
+		if auditErr != nil {
+			return auditErr
+		}
+		fmt.Printf("queued request %s for %s from %s\n", req.ID, req.Name, req.Requester)
+	}
+	return err
+}
+
+func requestLifetime(conf *config.Config) int {
+	if conf.Approval.Lifetime > 0 {
+		return conf.Approval.Lifetime
+	}
+	return defaultRequestLifetime
+}
+
+// maxRequestLifetime is the longest lifetime in days a request may ask for
+func maxRequestLifetime(conf *config.Config) int {
+	if conf.Approval.MaxLifetime > 0 {
+		return conf.Approval.MaxLifetime
+	}
+	return requestLifetime(conf)
+}
+
+func ListRequests(all bool, baseDir string) error {
+	if err := ingestRequests(baseDir); err != nil {
+		return err
+	}
+	reqs, err := requests.List(baseDir)
+	if err != nil {
+		return err
+	}
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	required := conf.Approval.Required
+	if required < 1 {
+		required = 1
+	}
+
+	for _, req := range reqs {
+		if !all && req.Status != requests.StatusPending {
+			continue
+		}
+		csr, err := req.ParsedCSR()
+		if err != nil {
+			return err
+		}
+		altNames := requestAltNames(csr)
+		fmt.Printf("%s %s %s submitted %s by %s via %s, %d/%d approvals, %d days, subject %q, sans %s\n",
+			req.ID, req.Status, req.Name, req.SubmittedAt.Local().Format(time.RFC3339), req.Requester, req.Source,
+			len(req.Approvals), required, req.Lifetime, csr.Subject.String(), strings.Join(altNames, " "))
+	}
+	return nil
+}
+
+func ShowRequest(id, baseDir string) error {
+	req, err := requests.Get(id, baseDir)
+	if err != nil {
+		return err
+	}
+	csr, err := req.ParsedCSR()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id:          %s\n", req.ID)
+	fmt.Printf("status:      %s\n", req.Status)
+	fmt.Printf("name:        %s\n", req.Name)
+	if req.Profile != "" {
+		fmt.Printf("profile:     %s\n", req.Profile)
+	}
+	fmt.Printf("subject:     %s\n", csr.Subject.String())
+	fmt.Printf("sans:        %s\n", strings.Join(requestAltNames(csr), " "))
+	fmt.Printf("key:         %s\n", csr.PublicKeyAlgorithm)
+	fmt.Printf("lifetime:    %d days\n", req.Lifetime)
+	fmt.Printf("requester:   %s via %s\n", req.Requester, req.Source)
+	fmt.Printf("submitted:   %s\n", req.SubmittedAt.Local().Format(time.RFC3339))
+	for _, approval := range req.Approvals {
+		fmt.Printf("approved by: %s at %s %s\n", approval.Operator, approval.Time.Local().Format(time.RFC3339), approval.Reason)
+	}
+	if req.Denial != nil {
+		fmt.Printf("denied by:   %s at %s %s\n", req.Denial.Operator, req.Denial.Time.Local().Format(time.RFC3339), req.Denial.Reason)
+	}
+	if req.IssuedSerial != "" {
+		fmt.Printf("serial:      %s\n", req.IssuedSerial)
+	}
+	return nil
+}
+
+func SubmitRequest(csrPath, name, profile string, metadata map[string]string, lifetime int, baseDir string) error {
+	data, err := ioutil.ReadFile(csrPath)
+	if err != nil {
+		return err
+	}
+	csrBytes, err := requests.ParseCSR(data)
+	if err != nil {
+		return err
+	}
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	if _, err = conf.GetProfile(profile); err != nil {
+		return err
+	}
+	if lifetime == 0 {
+		lifetime = requestLifetime(conf)
+	} else if lifetime > maxRequestLifetime(conf) {
+		return fmt.Errorf("lifetime is over the maximum of %d days", maxRequestLifetime(conf))
+	}
+	requester, err := audit.SystemUser()
+	if err != nil {
+		return err
+	}
+	req, err := requests.Submit(csrBytes, name, profile, metadata, lifetime, requests.SourceCLI, requester, baseDir)
+	details := map[string]string{"source": requests.SourceCLI, "file": csrPath}
+	if req != nil {
+		details["request"] = req.ID
+		details["name"] = req.Name
+	}
+	if err = audited(baseDir, "request.submit", details, err); err != nil {
+		return err
+	}
+	fmt.Printf("submitted request %s for %s, waiting for approval\n", req.ID, req.Name)
+	return nil
+}
+
+func ApproveRequest(id, reason, password, baseDir string) (err error) {
+	if id == "" {
+		return errors.New("request id is required")
+	}
+	details := map[string]string{"request": id}
+	issued := ""
+	defer func() {
+		if err == nil && issued != "" {
+			err = deployCert(issued, baseDir)
+		}
+	}()
+	defer func() {
+		err = audited(baseDir, "request.approve", details, err)
+	}()
+
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	req, err := requests.Get(id, baseDir)
+	if err != nil {
+		return err
+	}
+	details["name"] = req.Name
+	details["profile"] = req.Profile
+	approver, err := audit.Approver()
+	if err != nil {
+		return err
+	}
+	ready, err := req.Approve(approver, reason, conf.Approval.Approvers, conf.Approval.Required)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		fmt.Printf("approved request %s, %d of %d approvals\n", req.ID, len(req.Approvals), requests.Quorum(conf.Approval.Required))
+		return req.Save(baseDir)
+	}
+
+	// enough approvals, sign the request. a submitted csr must not replace a
+	// certificate whose key hancock holds.
+	source := inventory.SourceHancock
+	held := requestKeyHeld(req, baseDir)
+	if !held {
+		source = inventory.SourceRequest
+		if _, err := certs.GetCert(req.Name, baseDir); err == nil {
+			return fmt.Errorf("a certificate named %s already exists and request %s is not for its key", req.Name, req.ID)
+		}
+	}
+	rootKey, err := unlockRootKey(password, baseDir)
+	if err != nil {
+		return err
+	}
+	signStart := time.Now()
+	certBytes, err := signCert(req.CSR, req.Profile, certs.Extensions{}, req.Lifetime, rootKey, baseDir)
+	if err != nil {
+		return err
+	}
+	details["sign_seconds"] = strconv.FormatFloat(time.Since(signStart).Seconds(), 'f', 6, 64)
+	if !held {
+		if err = certs.SaveCsr(req.Name, req.CSR, baseDir); err != nil {
+			return err
+		}
+	}
+	if err = saveCert(certBytes, req.Name, source, req.Profile, req.Metadata, baseDir); err != nil {
+		return err
+	}
+	// the key hancock new generated for the request replaces the saved one
+	if held {
+		if err = promoteKey(req.Name, baseDir); err != nil {
+			return err
+		}
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return err
+	}
+	req.Status = requests.StatusIssued
+	req.IssuedSerial = cert.SerialNumber.Text(16)
+	details["serial"] = req.IssuedSerial
+	details["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+	fmt.Printf("approved request %s, issued %s with serial %s\n", req.ID, req.Name, req.IssuedSerial)
+	if err = req.Save(baseDir); err != nil {
+		return err
+	}
+	issued = req.Name
+	return nil
+}
+
+func DenyRequest(id, reason, baseDir string) (err error) {
+	if id == "" {
+		return errors.New("request id is required")
+	}
+	details := map[string]string{"request": id, "reason": reason}
+	defer func() {
+		err = audited(baseDir, "request.deny", details, err)
+	}()
+
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	req, err := requests.Get(id, baseDir)
+	if err != nil {
+		return err
+	}
+	details["name"] = req.Name
+	approver, err := audit.Approver()
+	if err != nil {
+		return err
+	}
+	held := requestKeyHeld(req, baseDir)
+	if err = req.Deny(approver, reason, conf.Approval.Approvers); err != nil {
+		return err
+	}
+	// the key generated for the request will never have a certificate
+	if held {
+		discardKey(req.Name, baseDir)
+	}
+	fmt.Printf("denied request %s\n", req.ID)
+	return req.Save(baseDir)
+}
+
+// requestKeyHeld reports whether a request was queued by hancock new for the
+// pending key it holds for the name, which saves the csr next to the key
+func requestKeyHeld(req *requests.Request, baseDir string) bool {
+	path, err := paths.GetPendingCsrPath(req.Name, baseDir)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	return block != nil && bytes.Equal(block.Bytes, req.CSR)
+}
+
+func requestAltNames(csr *x509.CertificateRequest) []string {
+	altNames := &certs.SubjectAltNames{
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}
+	return altNames.Strings()
+}
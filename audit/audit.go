@@ -0,0 +1,345 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/lockfile"
+	"github.com/galenguyer/hancock/paths"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry is a single ca operation. Each entry includes the hash of the one
+// before it and is signed with the audit key, so edits, removals and gaps
+// can be detected by Verify.
+//
+// Anyone holding the audit key can rewrite the log and sign it again. The key
+// is kept in the base directory unless audit.key in config.json points
+// elsewhere, so either keep it out of reach of those who can write the base
+// directory, or record the key fingerprint and the head that verify prints
+// somewhere they cannot change.
+type Entry struct {
+	Sequence  uint64            `json:"seq"`
+	Timestamp time.Time         `json:"timestamp"`
+	Operator  string            `json:"operator"`
+	Host      string            `json:"host"`
+	Operation string            `json:"operation"`
+	Details   map[string]string `json:"details,omitempty"`
+	Result    string            `json:"result"`
+	Error     string            `json:"error,omitempty"`
+	PrevHash  []byte            `json:"prev_hash"`
+	Hash      []byte            `json:"hash,omitempty"`
+	Signature []byte            `json:"signature,omitempty"`
+}
+
+// Head is the sequence and hash of the last entry, signed and kept next to
+// the log so that entries removed from its end are detected by Verify
+type Head struct {
+	Sequence  uint64 `json:"seq"`
+	Hash      []byte `json:"hash"`
+	Signature []byte `json:"signature"`
+}
+
+// Record appends an operation and its outcome to the audit log
+func Record(baseDir, operation string, details map[string]string, opErr error) error {
+	err := os.MkdirAll(paths.GetAuditPath(baseDir), 0700)
+	if err != nil {
+		return err
+	}
+	// the sequence and hash chain continue from the last entry, so no other
+	// process may append between reading it and writing this one
+	unlock, err := lockfile.Lock(paths.GetAuditLockPath(baseDir), "audit log")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	key, err := getAuditKey(baseDir)
+	if err != nil {
+		return err
+	}
+	entries, err := Load(baseDir)
+	if err != nil {
+		return err
+	}
+
+	entry := Entry{
+		Timestamp: time.Now().UTC(),
+		Operator:  Operator(),
+		Operation: operation,
+		Details:   details,
+		Result:    ResultSuccess,
+		PrevHash:  make([]byte, sha256.Size),
+	}
+	entry.Host, _ = os.Hostname()
+	if opErr != nil {
+		entry.Result = ResultFailure
+		entry.Error = opErr.Error()
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		entry.Sequence = last.Sequence + 1
+		entry.PrevHash = last.Hash
+	}
+	entry.Hash, err = entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Signature = ed25519.Sign(key, entry.Hash)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(paths.GetAuditLogPath(baseDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return saveHead(Head{Sequence: entry.Sequence, Hash: entry.Hash, Signature: ed25519.Sign(key, headSignatureInput(entry.Sequence, entry.Hash))}, baseDir)
+}
+
+func headSignatureInput(sequence uint64, hash []byte) []byte {
+	return append([]byte(fmt.Sprintf("hancock audit head %d ", sequence)), hash...)
+}
+
+func saveHead(head Head, baseDir string) error {
+	bytes, err := json.MarshalIndent(head, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := paths.GetAuditHeadPath(baseDir) + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(bytes, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, paths.GetAuditHeadPath(baseDir))
+}
+
+func loadHead(baseDir string) (*Head, error) {
+	bytes, err := ioutil.ReadFile(paths.GetAuditHeadPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	head := &Head{}
+	if err = json.Unmarshal(bytes, head); err != nil {
+		return nil, fmt.Errorf("invalid audit head: %v", err)
+	}
+	return head, nil
+}
+
+// CheckHead compares the log against a head recorded elsewhere, returning a
+// description of the problem or an empty string if the log still contains
+// that entry unchanged
+func CheckHead(entries []Entry, sequence uint64, hash []byte) string {
+	if sequence >= uint64(len(entries)) {
+		return fmt.Sprintf("head %d: the log only has %d entries, entries have been removed from its end", sequence, len(entries))
+	}
+	if !bytes.Equal(entries[sequence].Hash, hash) {
+		return fmt.Sprintf("head %d: hash does not match, the log has been rewritten", sequence)
+	}
+	return ""
+}
+
+// Load reads every entry in the audit log
+func Load(baseDir string) ([]Entry, error) {
+	file, err := os.Open(paths.GetAuditLogPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid audit log line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Verify checks the sequence numbers, hash chain and signatures of every
+// entry, returning a description of each problem found
+func Verify(baseDir string) ([]Entry, []string, error) {
+	entries, err := Load(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, err := loadHead(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entries) == 0 {
+		if head != nil {
+			return nil, []string{fmt.Sprintf("the log is empty but its signed head is at entry %d, entries have been removed", head.Sequence)}, nil
+		}
+		return nil, nil, nil
+	}
+	key, err := getAuditKey(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	var problems []string
+	if head == nil {
+		problems = append(problems, "the signed head is missing, entries may have been removed from the end of the log")
+	} else if !ed25519.Verify(publicKey, headSignatureInput(head.Sequence, head.Hash), head.Signature) {
+		problems = append(problems, "the signed head has an invalid signature")
+	} else if problem := CheckHead(entries, head.Sequence, head.Hash); problem != "" {
+		problems = append(problems, problem)
+	} else if head.Sequence != uint64(len(entries)-1) {
+		problems = append(problems, fmt.Sprintf("the signed head is at entry %d but the log continues to %d without it", head.Sequence, len(entries)-1))
+	}
+
+	prevHash := make([]byte, sha256.Size)
+	for i, entry := range entries {
+		if entry.Sequence != uint64(i) {
+			problems = append(problems, fmt.Sprintf("entry %d: expected sequence %d, entries are missing or reordered", entry.Sequence, i))
+		}
+		if !bytes.Equal(entry.PrevHash, prevHash) {
+			problems = append(problems, fmt.Sprintf("entry %d: previous hash does not match, the log has been modified before this entry", entry.Sequence))
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(hash, entry.Hash) {
+			problems = append(problems, fmt.Sprintf("entry %d: hash does not match its contents, the entry has been edited", entry.Sequence))
+		}
+		if !ed25519.Verify(publicKey, entry.Hash, entry.Signature) {
+			problems = append(problems, fmt.Sprintf("entry %d: signature is invalid", entry.Sequence))
+		}
+		prevHash = entry.Hash
+	}
+	return entries, problems, nil
+}
+
+// KeyFingerprint returns the SHA-256 fingerprint of the audit public key,
+// which can be recorded elsewhere to detect the log being re-signed
+func KeyFingerprint(baseDir string) (string, error) {
+	key, err := getAuditKey(baseDir)
+	if err != nil {
+		return "", err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(spki)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (e Entry) computeHash() ([]byte, error) {
+	e.Hash, e.Signature = nil, nil
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bytes)
+	return sum[:], nil
+}
+
+// getAuditKey loads the audit key from where the config says, or the base
+// directory, creating it on first use
+func getAuditKey(baseDir string) (ed25519.PrivateKey, error) {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := paths.GetAuditKeyPath(baseDir)
+	if conf.Audit.Key != "" {
+		keyPath = conf.Audit.Key
+	}
+	bytes, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+			return nil, err
+		}
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		return key, ioutil.WriteFile(keyPath, pemBytes, 0600)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("invalid audit key %s", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("audit key is not an ed25519 key")
+	}
+	return edKey, nil
+}
+
+// Operator returns the name of the person running hancock, taken from
+// $HANCOCK_OPERATOR or the current user
+func Operator() string {
+	if name := os.Getenv("HANCOCK_OPERATOR"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// SystemUser returns the name of the operating system user running hancock,
+// which unlike $HANCOCK_OPERATOR cannot be chosen by the caller
+func SystemUser() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// Approver returns who is deciding on an issuance request. Approvals count
+// toward a quorum, so they are only ever taken from the operating system
+// user.
+func Approver() (string, error) {
+	if os.Getenv("HANCOCK_OPERATOR") != "" {
+		return "", errors.New("requests cannot be approved or denied with HANCOCK_OPERATOR set, run hancock as your own user instead")
+	}
+	return SystemUser()
+}
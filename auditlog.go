@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/audit"
+	"github.com/urfave/cli/v2"
+)
+
+var auditCommand = &cli.Command{
+	Name:  "audit",
+	Usage: "inspect the audit log of ca operations",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "verify",
+			Usage: "check the audit log for gaps, edits and invalid signatures",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "head",
+					Usage: "head printed by an earlier verify and kept outside the base directory, to check the log still contains it",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return AuditVerify(c.String("head"), c.String("basedir"))
+			},
+		},
+		{
+			Name:  "show",
+			Usage: "print audit log entries",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "operation",
+					Usage: "only show operations starting with this prefix, such as issue or init",
+				},
+				&cli.StringFlag{
+					Name: "operator",
+				},
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "only show operations on this certificate name",
+				},
+				&cli.StringFlag{
+					Name:  "result",
+					Usage: "success or failure",
+				},
+				&cli.TimestampFlag{
+					Name:   "since",
+					Layout: "2006-01-02",
+				},
+				&cli.TimestampFlag{
+					Name:   "until",
+					Layout: "2006-01-02",
+				},
+				&cli.BoolFlag{
+					Name: "json",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				filter := AuditFilter{
+					Operation: c.String("operation"),
+					Operator:  c.String("operator"),
+					Name:      c.String("name"),
+					Result:    c.String("result"),
+				}
+				if since := c.Timestamp("since"); since != nil {
+					filter.Since = *since
+				}
+				if until := c.Timestamp("until"); until != nil {
+					filter.Until = until.AddDate(0, 0, 1)
+				}
+				return AuditShow(filter, c.Bool("json"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+// AuditFilter selects audit log entries, ignoring fields left empty
+type AuditFilter struct {
+	Operation string
+	Operator  string
+	Name      string
+	Result    string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f AuditFilter) matches(entry audit.Entry) bool {
+	return strings.HasPrefix(entry.Operation, f.Operation) &&
+		(f.Operator == "" || entry.Operator == f.Operator) &&
+		(f.Name == "" || entry.Details["name"] == f.Name) &&
+		(f.Result == "" || entry.Result == f.Result) &&
+		(f.Since.IsZero() || !entry.Timestamp.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Timestamp.Before(f.Until))
+}
+
+// AuditVerify checks the audit log, and that it still contains the head
+// given as sequence:hash if there is one
+func AuditVerify(head, baseDir string) error {
+	entries, problems, err := audit.Verify(baseDir)
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		parts := strings.SplitN(head, ":", 2)
+		sequence, seqErr := strconv.ParseUint(parts[0], 10, 64)
+		if len(parts) != 2 || seqErr != nil {
+			return fmt.Errorf("invalid head %s, expected sequence:hash", head)
+		}
+		hash, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid head %s, expected sequence:hash", head)
+		}
+		if problem := audit.CheckHead(entries, sequence, hash); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("audit log failed verification with %d problems", len(problems))
+	}
+	if len(entries) == 0 {
+		fmt.Println("audit log is empty")
+		return nil
+	}
+	fingerprint, err := audit.KeyFingerprint(baseDir)
+	if err != nil {
+		return err
+	}
+	last := entries[len(entries)-1]
+	fmt.Printf("audit log verified, %d entries\n", len(entries))
+	fmt.Printf("head: %d:%x\n", last.Sequence, last.Hash)
+	fmt.Printf("audit key: sha256:%s\n", fingerprint)
+	return nil
+}
+
+func AuditShow(filter AuditFilter, asJSON bool, baseDir string) error {
+	entries, err := audit.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	var matched []audit.Entry
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if asJSON {
+		return printJSON(matched)
+	}
+	for _, entry := range matched {
+		var details []string
+		for key, value := range entry.Details {
+			if value != "" {
+				details = append(details, key+"="+value)
+			}
+		}
+		sort.Strings(details)
+		if entry.Error != "" {
+			details = append(details, fmt.Sprintf("error=%q", entry.Error))
+		}
+		fmt.Printf("%d %s %s@%s %s %s %s\n", entry.Sequence, entry.Timestamp.Local().Format(time.RFC3339),
+			entry.Operator, entry.Host, entry.Operation, entry.Result, strings.Join(details, " "))
+	}
+	return nil
+}
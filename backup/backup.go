@@ -0,0 +1,372 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/paths"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// SchemaVersion is bumped whenever the base directory layout changes in
+	// a way older versions cannot restore
+	SchemaVersion = 1
+
+	format       = "hancock-backup"
+	manifestName = "manifest.json"
+	filesPrefix  = "files/"
+	oaepLabel    = "hancock backup"
+)
+
+// Manifest lists every file in a backup along with its checksum
+type Manifest struct {
+	SchemaVersion int            `json:"schema_version"`
+	Created       time.Time      `json:"created"`
+	SourceBaseDir string         `json:"source_basedir"`
+	Files         []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string      `json:"path"`
+	Mode   os.FileMode `json:"mode"`
+	Size   int64       `json:"size,omitempty"`
+	SHA256 string      `json:"sha256,omitempty"`
+}
+
+// header is stored in the clear ahead of the ciphertext and authenticated
+// as additional data
+type header struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	Scrypt     *scryptKDF  `json:"scrypt,omitempty"`
+	Recipients []recipient `json:"recipients,omitempty"`
+	Nonce      []byte      `json:"nonce"`
+}
+
+type scryptKDF struct {
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+type recipient struct {
+	Fingerprint string `json:"fingerprint"`
+	WrappedKey  []byte `json:"wrapped_key"`
+}
+
+// Create archives the base directory, encrypting it with a key derived from
+// passphrase or wrapped to each recipient
+func Create(baseDir, passphrase string, recipients []*rsa.PublicKey) ([]byte, *Manifest, error) {
+	if passphrase == "" && len(recipients) == 0 {
+		return nil, nil, errors.New("a passphrase or at least one recipient is required")
+	}
+	archive, manifest, err := archiveBaseDir(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hdr := header{Format: format, Version: SchemaVersion}
+	contentKey := make([]byte, 32)
+	if passphrase != "" {
+		hdr.Scrypt = &scryptKDF{Salt: make([]byte, 16), N: 1 << 17, R: 8, P: 1}
+		if _, err = rand.Read(hdr.Scrypt.Salt); err != nil {
+			return nil, nil, err
+		}
+		if contentKey, err = hdr.Scrypt.deriveKey(passphrase); err != nil {
+			return nil, nil, err
+		}
+	} else if _, err = rand.Read(contentKey); err != nil {
+		return nil, nil, err
+	}
+	if passphrase == "" {
+		for _, publicKey := range recipients {
+			wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, contentKey, []byte(oaepLabel))
+			if err != nil {
+				return nil, nil, err
+			}
+			fingerprint, err := keyFingerprint(publicKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			hdr.Recipients = append(hdr.Recipients, recipient{Fingerprint: fingerprint, WrappedKey: wrapped})
+		}
+	}
+
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(hdr.Nonce); err != nil {
+		return nil, nil, err
+	}
+	hdrBytes, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := append(hdrBytes, '\n')
+	return gcm.Seal(out, hdr.Nonce, archive, hdrBytes), manifest, nil
+}
+
+// Restore decrypts and verifies a backup before unpacking it into baseDir,
+// which must be empty unless force is set
+func Restore(data []byte, baseDir, passphrase string, identities []*rsa.PrivateKey, force bool) (*Manifest, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, errors.New("not a hancock backup")
+	}
+	hdrBytes, ciphertext := data[:i], data[i+1:]
+	hdr := header{}
+	if err := json.Unmarshal(hdrBytes, &hdr); err != nil || hdr.Format != format {
+		return nil, errors.New("not a hancock backup")
+	}
+	if hdr.Version > SchemaVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than this version of hancock supports", hdr.Version)
+	}
+
+	var contentKey []byte
+	var err error
+	switch {
+	case hdr.Scrypt != nil:
+		if passphrase == "" {
+			return nil, errors.New("this backup is encrypted with a passphrase")
+		}
+		if contentKey, err = hdr.Scrypt.deriveKey(passphrase); err != nil {
+			return nil, err
+		}
+	default:
+		for _, r := range hdr.Recipients {
+			for _, identity := range identities {
+				if key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, identity, r.WrappedKey, []byte(oaepLabel)); err == nil {
+					contentKey = key
+				}
+			}
+		}
+		if contentKey == nil {
+			return nil, errors.New("none of the given identities is a recipient of this backup")
+		}
+	}
+
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := gcm.Open(nil, hdr.Nonce, ciphertext, hdrBytes)
+	if err != nil {
+		return nil, errors.New("backup could not be decrypted, the passphrase is wrong or the file has been modified")
+	}
+
+	manifest, files, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("backup schema version %d is newer than this version of hancock supports", manifest.SchemaVersion)
+	}
+	if err = verifyManifest(manifest, files); err != nil {
+		return nil, err
+	}
+
+	base := paths.GetBasePath(baseDir)
+	if entries, err := ioutil.ReadDir(base); err == nil && len(entries) > 0 && !force {
+		return nil, fmt.Errorf("%s is not empty", base)
+	}
+	for _, file := range manifest.Files {
+		target := filepath.Join(base, filepath.FromSlash(file.Path))
+		if file.Mode.IsDir() {
+			if err = os.MkdirAll(target, file.Mode.Perm()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(target, files[file.Path], file.Mode.Perm()); err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(target, file.Mode.Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func archiveBaseDir(baseDir string) ([]byte, *Manifest, error) {
+	base := paths.GetBasePath(baseDir)
+	manifest := &Manifest{SchemaVersion: SchemaVersion, Created: time.Now().UTC(), SourceBaseDir: base}
+	contents := map[string][]byte{}
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case info.IsDir():
+			manifest.Files = append(manifest.Files, ManifestFile{Path: rel, Mode: info.Mode() & (os.ModeDir | os.ModePerm)})
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, ManifestFile{Path: rel, Mode: info.Mode().Perm(), Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+			contents[rel] = data
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	writeEntry := func(name string, mode os.FileMode, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: int64(mode.Perm()), Size: int64(len(data)), ModTime: manifest.Created, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+	if err = writeEntry(manifestName, 0600, manifestBytes); err != nil {
+		return nil, nil, err
+	}
+	for _, file := range manifest.Files {
+		if file.Mode.IsDir() {
+			continue
+		}
+		if err = writeEntry(filesPrefix+file.Path, file.Mode, contents[file.Path]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), manifest, nil
+}
+
+func readArchive(archive []byte) (*Manifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+	var manifest *Manifest
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == manifestName {
+			manifest = &Manifest{}
+			if err = json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+			}
+		} else if strings.HasPrefix(hdr.Name, filesPrefix) {
+			files[strings.TrimPrefix(hdr.Name, filesPrefix)] = data
+		} else {
+			return nil, nil, fmt.Errorf("unexpected file %s in backup", hdr.Name)
+		}
+	}
+	if manifest == nil {
+		return nil, nil, errors.New("backup has no manifest")
+	}
+	return manifest, files, nil
+}
+
+// verifyManifest checks that the archive holds exactly the files listed in
+// the manifest, with matching checksums and no paths escaping the base directory
+func verifyManifest(manifest *Manifest, files map[string][]byte) error {
+	listed := map[string]bool{}
+	for _, file := range manifest.Files {
+		clean := path.Clean(file.Path)
+		if clean != file.Path || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("invalid path %s in manifest", file.Path)
+		}
+		if file.Mode.IsDir() {
+			continue
+		}
+		listed[file.Path] = true
+		data, ok := files[file.Path]
+		if !ok {
+			return fmt.Errorf("%s is listed in the manifest but missing from the backup", file.Path)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != file.SHA256 || int64(len(data)) != file.Size {
+			return fmt.Errorf("checksum mismatch for %s", file.Path)
+		}
+	}
+	var extra []string
+	for name := range files {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return fmt.Errorf("backup contains files not in the manifest: %s", strings.Join(extra, ", "))
+	}
+	return nil
+}
+
+func (s *scryptKDF) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), s.Salt, s.N, s.R, s.P, 32)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func keyFingerprint(publicKey *rsa.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(spki)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+
+	"github.com/galenguyer/hancock/backup"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var backupCommand = &cli.Command{
+	Name:  "backup",
+	Usage: "write an encrypted archive of the whole ca",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+		&cli.StringFlag{
+			Name:     "out",
+			Aliases:  []string{"o"},
+			Usage:    "file to write the backup to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "passphrase",
+			Usage: "passphrase to encrypt the backup with, prompted for if no recipients are given",
+		},
+		&cli.StringSliceFlag{
+			Name:  "recipient",
+			Usage: "rsa public key or certificate to encrypt the backup to",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return BackupCA(c.String("out"), c.String("passphrase"), c.StringSlice("recipient"), c.String("basedir"))
+	},
+}
+
+var restoreCommand = &cli.Command{
+	Name:  "restore",
+	Usage: "restore the ca from an encrypted backup",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+		&cli.StringFlag{
+			Name:     "in",
+			Aliases:  []string{"i"},
+			Usage:    "backup file to restore",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "passphrase",
+			Usage: "passphrase the backup was encrypted with",
+		},
+		&cli.StringSliceFlag{
+			Name:  "identity",
+			Usage: "rsa private key of a backup recipient",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "restore into a base directory that is not empty",
+			Value: false,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return RestoreCA(c.String("in"), c.String("passphrase"), c.StringSlice("identity"), c.Bool("force"), c.String("basedir"))
+	},
+}
+
+func BackupCA(out, passphrase string, recipientFiles []string, baseDir string) (err error) {
+	defer func() {
+		err = audited(baseDir, "backup", map[string]string{"file": out}, err)
+	}()
+
+	var recipients []*rsa.PublicKey
+	for _, file := range recipientFiles {
+		publicKey, err := keys.ReadRsaPublicKey(file)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, publicKey)
+	}
+	if len(recipients) > 0 && passphrase != "" {
+		return errors.New("use either a passphrase or recipients, not both")
+	}
+	if len(recipients) == 0 {
+		if passphrase, err = readNewPassword(passphrase, false); err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return errors.New("a passphrase is required")
+		}
+	}
+
+	data, manifest, err := backup.Create(baseDir, passphrase, recipients)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(out, data, 0600); err != nil {
+		return err
+	}
+	fmt.Println("backed up " + strconv.Itoa(len(manifest.Files)) + " files and directories from " + paths.GetBasePath(baseDir) + " to " + out)
+	return nil
+}
+
+func RestoreCA(in, passphrase string, identityFiles []string, force bool, baseDir string) (err error) {
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	var identities []*rsa.PrivateKey
+	for _, file := range identityFiles {
+		identity, err := keys.ReadRsaPrivateKey(file)
+		if err != nil {
+			return err
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 && passphrase == "" {
+		fmt.Print("enter passphrase: ")
+		bytePassphrase, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return err
+		}
+		fmt.Print("\n")
+		passphrase = string(bytePassphrase)
+	}
+
+	manifest, err := backup.Restore(data, baseDir, passphrase, identities, force)
+	if err != nil {
+		return err
+	}
+	// the audit log is part of the backup, so the restore is recorded at the
+	// end of the restored chain
+	defer func() {
+		err = audited(baseDir, "restore", map[string]string{
+			"file":    in,
+			"created": manifest.Created.Format("2006-01-02T15:04:05Z"),
+			"source":  manifest.SourceBaseDir,
+		}, err)
+	}()
+	fmt.Println("restored " + strconv.Itoa(len(manifest.Files)) + " files and directories from " + in + " to " + paths.GetBasePath(baseDir))
+	return nil
+}
@@ -1,25 +1,39 @@
 package certs
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"time"
 
 	"github.com/galenguyer/hancock/paths"
 )
 
-func GenerateCert(csrBytes []byte, lifetime int, rootKey rsa.PrivateKey, baseDir string) ([]byte, error) {
-	rootCACert, err := GetRootCACert(baseDir)
+func GenerateCert(csrBytes []byte, lifetime int, rootKey crypto.Signer, baseDir string) ([]byte, error) {
+	template, err := NewCertTemplate(csrBytes, lifetime)
 	if err != nil {
 		return nil, err
 	}
+	return SignCert(template, rootKey, baseDir)
+}
+
+// NewCertTemplate builds the template for a leaf certificate from a csr,
+// allowing callers to add extensions before signing it with SignCert
+func NewCertTemplate(csrBytes []byte, lifetime int) (*x509.Certificate, error) {
 	csr, err := x509.ParseCertificateRequest(csrBytes)
 	if err != nil {
 		return nil, err
 	}
+	// the csr must be signed by the key it is for
+	if err = csr.CheckSignature(); err != nil {
+		return nil, err
+	}
 
 	serial, err := getSerial()
 	if err != nil {
@@ -27,23 +41,96 @@ func GenerateCert(csrBytes []byte, lifetime int, rootKey rsa.PrivateKey, baseDir
 	}
 	notBefore := time.Now()
 	notAfter := notBefore.Add(time.Duration(lifetime) * 24 * time.Hour).Add(-1 * time.Second)
+	subjectKeyId, err := SubjectKeyID(csr.PublicKey, KeyIDSHA1)
+	if err != nil {
+		return nil, err
+	}
+	// rsa keys encrypt tls key exchanges, other key types only sign
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
 
 	template := &x509.Certificate{
 		Subject:               csr.Subject,
+		RawSubject:            csr.RawSubject,
 		SerialNumber:          serial,
 		PublicKeyAlgorithm:    csr.PublicKeyAlgorithm,
 		PublicKey:             csr.PublicKey,
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
 		DNSNames:              csr.DNSNames,
 		IPAddresses:           csr.IPAddresses,
 		EmailAddresses:        csr.EmailAddresses,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		URIs:                  csr.URIs,
+		SubjectKeyId:          subjectKeyId,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 	}
-	return x509.CreateCertificate(rand.Reader, template, rootCACert, csr.PublicKey, &rootKey)
+	// copy the requested subject alternative names verbatim to keep
+	// otherNames, marking them critical when they are all there is to name
+	// the subject
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(oidExtensionSubjectAltName) {
+			ext.Critical = ext.Critical || len(csr.Subject.Names) == 0
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+	return template, nil
+}
+
+// SignCert signs a leaf certificate with the root key, cutting its validity
+// short if it would otherwise outlive the root
+func SignCert(template *x509.Certificate, rootKey crypto.Signer, baseDir string) ([]byte, error) {
+	rootCACert, err := GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if template.NotAfter.After(rootCACert.NotAfter) {
+		template.NotAfter = rootCACert.NotAfter
+	}
+	return x509.CreateCertificate(rand.Reader, template, rootCACert, template.PublicKey, rootKey)
+}
+
+// PreviewCert signs a copy of template as SignCert would but with a
+// throwaway key, so the certificate can be checked before the root key
+// signs it
+func PreviewCert(template *x509.Certificate, baseDir string) (*x509.Certificate, error) {
+	rootCACert, err := GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	// use the same kind of key as the root so the signature algorithm matches
+	var key crypto.Signer
+	switch pub := rootCACert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case *ecdsa.PublicKey:
+		key, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported root key type %T", rootCACert.PublicKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	parent := &x509.Certificate{
+		Subject:      rootCACert.Subject,
+		RawSubject:   rootCACert.RawSubject,
+		SubjectKeyId: rootCACert.SubjectKeyId,
+		PublicKey:    key.Public(),
+	}
+	// unlike SignCert, the validity is left alone so that a certificate
+	// outliving the root is caught
+	preview := *template
+	certBytes, err := x509.CreateCertificate(rand.Reader, &preview, parent, preview.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certBytes)
 }
 
 func SaveCert(certBytes []byte, name, baseDir string) error {
@@ -60,10 +147,5 @@ func GetCert(name, baseDir string) (*x509.Certificate, error) {
 	if err != nil {
 		return nil, err
 	}
-	bytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode(bytes)
-	return x509.ParseCertificate(block.Bytes)
+	return readCertFile(path)
 }
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/galenguyer/hancock/paths"
+)
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// RevocationReasons are the crl reason codes of RFC 5280 section 5.3.1 by
+// the names hancock revoke takes
+var RevocationReasons = map[string]int{
+	"unspecified":            0,
+	"key_compromise":         1,
+	"ca_compromise":          2,
+	"affiliation_changed":    3,
+	"superseded":             4,
+	"cessation_of_operation": 5,
+	"privilege_withdrawn":    9,
+}
+
+// Revocation is a revoked certificate listed in the crl
+type Revocation struct {
+	Serial *big.Int
+	Time   time.Time
+	// Reason is one of RevocationReasons or, for certificates imported from
+	// another ca, a reason code
+	Reason string
+}
+
+// GenerateCRL lists revoked certificates in a crl signed by the root key
+// that is valid for lifetime
+func GenerateCRL(revoked []Revocation, lifetime time.Duration, rootKey crypto.Signer, baseDir string) ([]byte, error) {
+	rootCACert, err := GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var list []pkix.RevokedCertificate
+	for _, revocation := range revoked {
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   revocation.Serial,
+			RevocationTime: revocation.Time.UTC(),
+		}
+		code, ok := RevocationReasons[revocation.Reason]
+		if !ok && revocation.Reason != "" {
+			if code, err = strconv.Atoi(revocation.Reason); err != nil {
+				return nil, fmt.Errorf("unknown revocation reason %s", revocation.Reason)
+			}
+		}
+		// the reason is left out rather than given as unspecified
+		if code != 0 {
+			value, err := asn1.Marshal(asn1.Enumerated(code))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: value}}
+		}
+		list = append(list, entry)
+	}
+	now := time.Now()
+	return rootCACert.CreateCRL(rand.Reader, rootKey, list, now, now.Add(lifetime))
+}
+
+// SaveCRL writes the der crl that verify checks and publish serves
+func SaveCRL(crl []byte, baseDir string) error {
+	return ioutil.WriteFile(paths.GetCRLPath(baseDir), crl, 0644)
+}
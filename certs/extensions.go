@@ -0,0 +1,230 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// subject key identifier methods
+const (
+	// KeyIDSHA1 is the sha-1 hash of the public key, RFC 5280 method 1
+	KeyIDSHA1 = "sha1"
+	// KeyIDSHA256, KeyIDSHA384 and KeyIDSHA512 hash the public key and keep
+	// the leftmost 160 bits, RFC 7093 methods 1 to 3
+	KeyIDSHA256 = "sha256"
+	KeyIDSHA384 = "sha384"
+	KeyIDSHA512 = "sha512"
+	// KeyIDSPKI is the sha-256 hash of the whole subject public key info,
+	// RFC 7093 method 4
+	KeyIDSPKI = "spki"
+)
+
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+)
+
+// extended key usages by the names config.json gives them
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// key usages by the names config.json gives them
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+}
+
+// Extensions are the identifier, access and policy extensions added to
+// certificates the ca signs
+type Extensions struct {
+	// SubjectKeyID is the method subject key identifiers are derived with:
+	// sha1, sha256, sha384, sha512 or spki, defaulting to sha1
+	SubjectKeyID string `json:"subject_key_id,omitempty"`
+	// IssuingCertificateURLs are where the root certificate can be fetched,
+	// given in the authority information access extension as caIssuers
+	IssuingCertificateURLs []string `json:"issuing_certificate_urls,omitempty"`
+	OCSPServers            []string `json:"ocsp_servers,omitempty"`
+	CRLDistributionPoints  []string `json:"crl_distribution_points,omitempty"`
+	Policies               []Policy `json:"policies,omitempty"`
+	// KeyUsage lists the key usages of leaf certificates, such as
+	// digital_signature and key_encipherment, replacing the ones picked for
+	// the type of key
+	KeyUsage []string `json:"key_usage,omitempty"`
+	// ExtKeyUsage lists the extended key usages of leaf certificates,
+	// replacing the default of server_auth and client_auth
+	ExtKeyUsage []string `json:"ext_key_usage,omitempty"`
+}
+
+// Policy is a certificate policy oid with an optional certification
+// practice statement uri
+type Policy struct {
+	OID string `json:"oid"`
+	CPS string `json:"cps,omitempty"`
+}
+
+// Merge overrides each field of s that is set in other
+func (s Extensions) Merge(other Extensions) Extensions {
+	if other.SubjectKeyID != "" {
+		s.SubjectKeyID = other.SubjectKeyID
+	}
+	if len(other.IssuingCertificateURLs) > 0 {
+		s.IssuingCertificateURLs = other.IssuingCertificateURLs
+	}
+	if len(other.OCSPServers) > 0 {
+		s.OCSPServers = other.OCSPServers
+	}
+	if len(other.CRLDistributionPoints) > 0 {
+		s.CRLDistributionPoints = other.CRLDistributionPoints
+	}
+	if len(other.Policies) > 0 {
+		s.Policies = other.Policies
+	}
+	if len(other.KeyUsage) > 0 {
+		s.KeyUsage = other.KeyUsage
+	}
+	if len(other.ExtKeyUsage) > 0 {
+		s.ExtKeyUsage = other.ExtKeyUsage
+	}
+	return s
+}
+
+// Apply sets the subject key identifier, authority information access, crl
+// distribution points, certificate policies and key usages on a template
+func (s Extensions) Apply(template *x509.Certificate) error {
+	keyID, err := SubjectKeyID(template.PublicKey, s.SubjectKeyID)
+	if err != nil {
+		return err
+	}
+	template.SubjectKeyId = keyID
+	template.IssuingCertificateURL = s.IssuingCertificateURLs
+	template.OCSPServer = s.OCSPServers
+	template.CRLDistributionPoints = s.CRLDistributionPoints
+	if len(s.Policies) > 0 {
+		ext, err := policiesExtension(s.Policies)
+		if err != nil {
+			return err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+	if len(s.KeyUsage) > 0 {
+		template.KeyUsage = 0
+	}
+	for _, name := range s.KeyUsage {
+		usage, ok := keyUsages[name]
+		if !ok {
+			return fmt.Errorf("unknown key usage %s", name)
+		}
+		template.KeyUsage |= usage
+	}
+	if len(s.ExtKeyUsage) > 0 {
+		template.ExtKeyUsage = nil
+	}
+	for _, name := range s.ExtKeyUsage {
+		usage, ok := extKeyUsages[name]
+		if !ok {
+			return fmt.Errorf("unknown extended key usage %s", name)
+		}
+		template.ExtKeyUsage = append(template.ExtKeyUsage, usage)
+	}
+	return nil
+}
+
+// SubjectKeyID derives a key identifier for pub with one of the methods of
+// RFC 5280 section 4.2.1.2 or RFC 7093, sha1 if method is empty
+func SubjectKeyID(pub crypto.PublicKey, method string) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err = asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "", KeyIDSHA1:
+		sum := sha1.Sum(info.PublicKey.Bytes)
+		return sum[:], nil
+	case KeyIDSHA256:
+		sum := sha256.Sum256(info.PublicKey.Bytes)
+		return sum[:20], nil
+	case KeyIDSHA384:
+		sum := sha512.Sum384(info.PublicKey.Bytes)
+		return sum[:20], nil
+	case KeyIDSHA512:
+		sum := sha512.Sum512(info.PublicKey.Bytes)
+		return sum[:20], nil
+	case KeyIDSPKI:
+		sum := sha256.Sum256(der)
+		return sum[:], nil
+	}
+	return nil, fmt.Errorf("unknown subject key identifier method %s", method)
+}
+
+type policyQualifierInfo struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier string `asn1:"ia5"`
+}
+
+type policyInformation struct {
+	ID         asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"omitempty"`
+}
+
+// policiesExtension encodes the certificate policies extension ourselves, as
+// the standard library cannot express cps qualifiers
+func policiesExtension(policies []Policy) (pkix.Extension, error) {
+	var infos []policyInformation
+	for _, policy := range policies {
+		oid, err := ParseOID(policy.OID)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		info := policyInformation{ID: oid}
+		if policy.CPS != "" {
+			info.Qualifiers = []policyQualifierInfo{{ID: oidPolicyQualifierCPS, Qualifier: policy.CPS}}
+		}
+		infos = append(infos, info)
+	}
+	value, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, nil
+}
+
+// ParseOID parses a dotted object identifier such as 2.23.140.1.2.1
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid oid %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid oid %q", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
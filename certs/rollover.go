@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/galenguyer/hancock/paths"
+)
+
+// CrossSignRootCACert issues a certificate for another root's subject and key,
+// signed by issuer, so that chains ending in either root can be validated by
+// clients trusting only the other
+func CrossSignRootCACert(target, issuer *x509.Certificate, issuerKey crypto.Signer) ([]byte, error) {
+	serial, err := getSerial()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := target.NotAfter
+	if issuer.NotAfter.Before(notAfter) {
+		notAfter = issuer.NotAfter
+	}
+	template := &x509.Certificate{
+		Subject:               target.Subject,
+		RawSubject:            target.RawSubject,
+		SerialNumber:          serial,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		SubjectKeyId:          target.SubjectKeyId,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return x509.CreateCertificate(rand.Reader, template, issuer, target.PublicKey, issuerKey)
+}
+
+// RootID identifies a root by the hash of its public key, and names the
+// directory it is kept in once retired
+func RootID(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RetireRootCA moves the current root key and certificate into the roots
+// directory, along with the certificate cross-signing it by its successor
+func RetireRootCA(rootCACert *x509.Certificate, crossCertBytes []byte, baseDir string) error {
+	dir := paths.GetRetiredRootPath(RootID(rootCACert), baseDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	// copy the key file as is so that it keeps its encryption, unless the
+	// key is only kept as shares
+	keyBytes, err := ioutil.ReadFile(paths.GetRootRsaKeyPath(baseDir))
+	if err == nil {
+		err = ioutil.WriteFile(dir+"/ca.pem", keyBytes, 0600)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCACert.Raw})
+	if err = ioutil.WriteFile(dir+"/ca.crt", certPem, 0644); err != nil {
+		return err
+	}
+	crossPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crossCertBytes})
+	if err = ioutil.WriteFile(dir+"/cross.crt", crossPem, 0644); err != nil {
+		return err
+	}
+	// keep the certificate cross-signing this root by its own predecessor
+	err = os.Rename(paths.GetCACrossCertPath(baseDir), dir+"/predecessor-cross.crt")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// GetRetiredRootCACerts returns the certificates of every root replaced by a rollover
+func GetRetiredRootCACerts(baseDir string) ([]*x509.Certificate, error) {
+	return readRetiredRootFiles("ca.crt", baseDir)
+}
+
+// GetCrossCerts returns every cross-signed root certificate, both the
+// current root signed by its predecessor and retired roots signed by their successors
+func GetCrossCerts(baseDir string) ([]*x509.Certificate, error) {
+	crossCerts, err := readRetiredRootFiles("cross.crt", baseDir)
+	if err != nil {
+		return nil, err
+	}
+	predecessorCrossCerts, err := readRetiredRootFiles("predecessor-cross.crt", baseDir)
+	if err != nil {
+		return nil, err
+	}
+	crossCerts = append(crossCerts, predecessorCrossCerts...)
+	current, err := readCertFile(paths.GetCACrossCertPath(baseDir))
+	if os.IsNotExist(err) {
+		return crossCerts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(crossCerts, current), nil
+}
+
+// SaveCABundle writes the current root and every unexpired retired root to a
+// single trust bundle
+func SaveCABundle(baseDir string) error {
+	rootCACert, err := GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	retired, err := GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return err
+	}
+	var bundle bytes.Buffer
+	for _, cert := range append([]*x509.Certificate{rootCACert}, retired...) {
+		if time.Now().After(cert.NotAfter) {
+			continue
+		}
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return ioutil.WriteFile(paths.GetCABundlePath(baseDir), bundle.Bytes(), 0644)
+}
+
+func readRetiredRootFiles(name, baseDir string) ([]*x509.Certificate, error) {
+	dirs, err := ioutil.ReadDir(paths.GetRootsPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		cert, err := readCertFile(paths.GetRetiredRootPath(dir.Name(), baseDir) + "/" + name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func readCertFile(path string) (*x509.Certificate, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, errors.New("no certificate found in " + path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -1,11 +1,12 @@
 package certs
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"time"
@@ -13,7 +14,9 @@ import (
 	"github.com/galenguyer/hancock/paths"
 )
 
-func GenerateRootCACert(rootKey rsa.PrivateKey, lifetime int, commonName, country, state, locality, organization, organizationalUnit string) ([]byte, error) {
+// GenerateRootCACert self-signs a root certificate, taking its key identifier
+// method and certificate policies from ext
+func GenerateRootCACert(rootKey crypto.Signer, lifetime int, commonName, country, state, locality, organization, organizationalUnit string, ext Extensions) ([]byte, error) {
 	serial, err := getSerial()
 	if err != nil {
 		return nil, err
@@ -40,22 +43,73 @@ func GenerateRootCACert(rootKey rsa.PrivateKey, lifetime int, commonName, countr
 		subject.OrganizationalUnit = []string{organizationalUnit}
 	}
 
+	keyID, err := SubjectKeyID(rootKey.Public(), ext.SubjectKeyID)
+	if err != nil {
+		return nil, err
+	}
+
 	parentTemplate := &x509.Certificate{
 		Subject: subject,
 	}
+	// a root is its own authority, so both key identifiers are the same
 	template := &x509.Certificate{
 		Subject:               subject,
 		SerialNumber:          serial,
-		PublicKeyAlgorithm:    x509.RSA,
-		PublicKey:             rootKey.PublicKey,
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
+		SubjectKeyId:          keyID,
+		AuthorityKeyId:        keyID,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
-	return x509.CreateCertificate(rand.Reader, template, parentTemplate, &rootKey.PublicKey, &rootKey)
+	if len(ext.Policies) > 0 {
+		policies, err := policiesExtension(ext.Policies)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, policies)
+	}
+	return x509.CreateCertificate(rand.Reader, template, parentTemplate, rootKey.Public(), rootKey)
+}
+
+// RenewRootCACert re-issues a self-signed root certificate with the same key,
+// subject and subject key identifier but a new validity period, so that
+// certificates it has already issued keep chaining to it
+func RenewRootCACert(rootKey crypto.Signer, rootCACert *x509.Certificate, lifetime int) ([]byte, error) {
+	if !KeyMatchesCert(rootKey, rootCACert) {
+		return nil, errors.New("root key does not match the root ca certificate")
+	}
+	serial, err := getSerial()
+	if err != nil {
+		return nil, err
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.Add(time.Duration(lifetime) * 24 * time.Hour).Add(-1 * time.Second)
+
+	parentTemplate := &x509.Certificate{
+		Subject:    rootCACert.Subject,
+		RawSubject: rootCACert.RawSubject,
+	}
+	template := &x509.Certificate{
+		Subject:               rootCACert.Subject,
+		RawSubject:            rootCACert.RawSubject,
+		SerialNumber:          serial,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		SubjectKeyId:          rootCACert.SubjectKeyId,
+		AuthorityKeyId:        rootCACert.SubjectKeyId,
+		KeyUsage:              rootCACert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	// keep the policies the root was issued with
+	for _, ext := range rootCACert.Extensions {
+		if ext.Id.Equal(oidExtensionCertificatePolicies) {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+	return x509.CreateCertificate(rand.Reader, template, parentTemplate, rootKey.Public(), rootKey)
 }
 
 func SaveRootCACert(certBytes []byte, baseDir string) error {
@@ -64,12 +118,13 @@ func SaveRootCACert(certBytes []byte, baseDir string) error {
 }
 
 func GetRootCACert(baseDir string) (*x509.Certificate, error) {
-	bytes, err := ioutil.ReadFile(paths.GetCACertPath(baseDir))
-	if err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode(bytes)
-	return x509.ParseCertificate(block.Bytes)
+	return readCertFile(paths.GetCACertPath(baseDir))
+}
+
+// KeyMatchesCert reports whether key is the private key for cert
+func KeyMatchesCert(key crypto.Signer, cert *x509.Certificate) bool {
+	publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && publicKey.Equal(cert.PublicKey)
 }
 
 func getSerial() (*big.Int, error) {
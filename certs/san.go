@@ -0,0 +1,342 @@
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUPN                     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// general name tags from RFC 5280 section 4.2.1.6
+const (
+	nameTypeOther = 0
+	nameTypeEmail = 1
+	nameTypeDNS   = 2
+	nameTypeURI   = 6
+	nameTypeIP    = 7
+)
+
+// OtherName is an otherName subject alternative name with a UTF8String value
+type OtherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  string
+}
+
+// SubjectAltNames holds the typed entries of a subject alternative name extension
+type SubjectAltNames struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	OtherNames     []OtherName
+}
+
+// ParseSANs parses OpenSSL-style typed entries such as DNS:example.com,
+// IP:10.0.0.1, email:user@example.com, URI:https://example.com and
+// otherName:UPN;UTF8:user@example.com, one entry per argument. Untyped
+// entries are treated as IP addresses if they parse as one, as email
+// addresses if they contain an @, as URIs if they contain ://, and as DNS
+// names otherwise.
+func ParseSANs(entries []string) (*SubjectAltNames, error) {
+	sans := &SubjectAltNames{}
+	for _, entry := range entries {
+		if err := sans.Add(strings.TrimSpace(entry)); err != nil {
+			return nil, err
+		}
+	}
+	return sans, nil
+}
+
+// Add parses a single typed or untyped entry and appends it
+func (s *SubjectAltNames) Add(entry string) error {
+	if entry == "" {
+		return nil
+	}
+	kind, value := "", entry
+	if i := strings.Index(entry, ":"); i > 0 {
+		switch strings.ToLower(entry[:i]) {
+		case "dns", "ip", "email", "uri", "othername":
+			kind, value = strings.ToLower(entry[:i]), entry[i+1:]
+		}
+	}
+	if kind == "" {
+		kind = detectSANType(value)
+	}
+	if value == "" {
+		return fmt.Errorf("empty subject alternative name in %q", entry)
+	}
+
+	switch kind {
+	case "dns":
+		name, err := toASCIIHostname(value)
+		if err != nil {
+			return fmt.Errorf("invalid dns name %q: %v", value, err)
+		}
+		s.DNSNames = appendUnique(s.DNSNames, name)
+	case "ip":
+		ip := parseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid ip address %q", value)
+		}
+		for _, existing := range s.IPAddresses {
+			if existing.Equal(ip) {
+				return nil
+			}
+		}
+		s.IPAddresses = append(s.IPAddresses, ip)
+	case "email":
+		at := strings.LastIndex(value, "@")
+		if at <= 0 || at == len(value)-1 {
+			return fmt.Errorf("invalid email address %q", value)
+		}
+		domain, err := toASCIIHostname(value[at+1:])
+		if err != nil {
+			return fmt.Errorf("invalid email address %q: %v", value, err)
+		}
+		s.EmailAddresses = appendUnique(s.EmailAddresses, value[:at+1]+domain)
+	case "uri":
+		uri, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid uri %q: %v", value, err)
+		}
+		if uri.Scheme == "" {
+			return fmt.Errorf("invalid uri %q: missing scheme", value)
+		}
+		s.URIs = append(s.URIs, uri)
+	case "othername":
+		other, err := parseOtherName(value)
+		if err != nil {
+			return err
+		}
+		s.OtherNames = append(s.OtherNames, *other)
+	}
+	return nil
+}
+
+// Empty returns whether no names have been added
+func (s *SubjectAltNames) Empty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 && len(s.EmailAddresses) == 0 &&
+		len(s.URIs) == 0 && len(s.OtherNames) == 0
+}
+
+// Strings returns the names as typed entries that ParseSANs accepts
+func (s *SubjectAltNames) Strings() []string {
+	var entries []string
+	for _, name := range s.DNSNames {
+		entries = append(entries, "DNS:"+name)
+	}
+	for _, ip := range s.IPAddresses {
+		entries = append(entries, "IP:"+ip.String())
+	}
+	for _, email := range s.EmailAddresses {
+		entries = append(entries, "email:"+email)
+	}
+	for _, uri := range s.URIs {
+		entries = append(entries, "URI:"+uri.String())
+	}
+	for _, other := range s.OtherNames {
+		typeID := other.TypeID.String()
+		if other.TypeID.Equal(oidUPN) {
+			typeID = "UPN"
+		}
+		entries = append(entries, "otherName:"+typeID+";UTF8:"+other.Value)
+	}
+	return entries
+}
+
+// Extension marshals the names into a subject alternative name extension
+func (s *SubjectAltNames) Extension() (pkix.Extension, error) {
+	var rawValues []asn1.RawValue
+	for _, other := range s.OtherNames {
+		typeID, err := asn1.Marshal(other.TypeID)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		utf8Value, err := asn1.MarshalWithParams(other.Value, "utf8")
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		value, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: utf8Value})
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeOther, IsCompound: true, Bytes: append(typeID, value...)})
+	}
+	for _, email := range s.EmailAddresses {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeEmail, Bytes: []byte(email)})
+	}
+	for _, name := range s.DNSNames {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeDNS, Bytes: []byte(name)})
+	}
+	for _, uri := range s.URIs {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeURI, Bytes: []byte(uri.String())})
+	}
+	for _, ip := range s.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeIP, Bytes: ip})
+	}
+	value, err := asn1.Marshal(rawValues)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}, nil
+}
+
+// SANsFromCert reads every subject alternative name from a certificate,
+// including otherNames which the standard library does not expose
+func SANsFromCert(cert *x509.Certificate) (*SubjectAltNames, error) {
+	sans := &SubjectAltNames{
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		otherNames, err := parseOtherNames(ext.Value)
+		if err != nil {
+			return nil, err
+		}
+		sans.OtherNames = otherNames
+	}
+	return sans, nil
+}
+
+func parseOtherNames(extValue []byte) ([]OtherName, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(extValue, &seq); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after subject alternative names")
+	}
+
+	var otherNames []OtherName
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var v asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &v)
+		if err != nil {
+			return nil, err
+		}
+		if v.Class != asn1.ClassContextSpecific || v.Tag != nameTypeOther {
+			continue
+		}
+		var other OtherName
+		valueBytes, err := asn1.Unmarshal(v.Bytes, &other.TypeID)
+		if err != nil {
+			return nil, err
+		}
+		var explicit asn1.RawValue
+		if _, err = asn1.Unmarshal(valueBytes, &explicit); err != nil {
+			return nil, err
+		}
+		if _, err = asn1.UnmarshalWithParams(explicit.Bytes, &other.Value, "utf8"); err != nil {
+			return nil, fmt.Errorf("unsupported otherName value for %s: %v", other.TypeID, err)
+		}
+		otherNames = append(otherNames, other)
+	}
+	return otherNames, nil
+}
+
+func parseOtherName(value string) (*OtherName, error) {
+	// accept both otherName:<oid>;UTF8:<value> and otherName:<oid>:<value>
+	var typeName, typedValue string
+	if i := strings.Index(value, ";"); i >= 0 {
+		typeName, typedValue = value[:i], value[i+1:]
+		if !strings.HasPrefix(strings.ToUpper(typedValue), "UTF8:") {
+			return nil, fmt.Errorf("unsupported otherName value %q, only UTF8 is supported", typedValue)
+		}
+		typedValue = typedValue[len("UTF8:"):]
+	} else if i := strings.Index(value, ":"); i >= 0 {
+		typeName, typedValue = value[:i], value[i+1:]
+	} else {
+		return nil, fmt.Errorf("invalid otherName %q", value)
+	}
+
+	other := &OtherName{Value: typedValue}
+	if strings.EqualFold(typeName, "UPN") {
+		other.TypeID = oidUPN
+	} else {
+		for _, part := range strings.Split(typeName, ".") {
+			var n int
+			if _, err := fmt.Sscanf(part, "%d", &n); err != nil || fmt.Sprint(n) != part {
+				return nil, fmt.Errorf("invalid otherName type %q", typeName)
+			}
+			other.TypeID = append(other.TypeID, n)
+		}
+		if len(other.TypeID) < 2 {
+			return nil, fmt.Errorf("invalid otherName type %q", typeName)
+		}
+	}
+	if other.Value == "" {
+		return nil, fmt.Errorf("empty otherName value in %q", value)
+	}
+	return other, nil
+}
+
+func detectSANType(value string) string {
+	switch {
+	case parseIP(value) != nil:
+		return "ip"
+	case strings.Contains(value, "://"):
+		return "uri"
+	case strings.Contains(value, "@"):
+		return "email"
+	default:
+		return "dns"
+	}
+}
+
+// parseIP parses a bare address or a single-host CIDR such as 10.0.0.1/32
+func parseIP(value string) net.IP {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip
+	}
+	ip, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil
+	}
+	if ones, bits := ipNet.Mask.Size(); ones != bits {
+		return nil
+	}
+	return ip
+}
+
+// toASCIIHostname converts an internationalized hostname to punycode,
+// keeping a leading wildcard label intact
+func toASCIIHostname(name string) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(name, "*.") {
+		prefix, name = "*.", name[2:]
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", err
+	}
+	return prefix + ascii, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if strings.EqualFold(existing, value) {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	spiffeScheme      = "spiffe://"
+	maxSPIFFEIDLength = 2048
+)
+
+// ParseSPIFFEID parses a spiffe id, holding it to the spiffe id standard:
+// a lowercase trust domain and a path of non-empty segments made only of
+// letters, digits, dots, dashes and underscores
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	if !strings.HasPrefix(id, spiffeScheme) {
+		return nil, fmt.Errorf("spiffe id %q must start with %s", id, spiffeScheme)
+	}
+	if len(id) > maxSPIFFEIDLength {
+		return nil, fmt.Errorf("spiffe id is longer than %d bytes", maxSPIFFEIDLength)
+	}
+	rest := strings.TrimPrefix(id, spiffeScheme)
+	trustDomain, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		trustDomain, path = rest[:i], rest[i:]
+	}
+	if trustDomain == "" {
+		return nil, fmt.Errorf("spiffe id %q has no trust domain", id)
+	}
+	for _, c := range trustDomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return nil, fmt.Errorf("spiffe id %q: trust domain may only hold lowercase letters, digits, dots, dashes and underscores", id)
+		}
+	}
+	if path != "" {
+		for _, segment := range strings.Split(path[1:], "/") {
+			if segment == "" {
+				return nil, fmt.Errorf("spiffe id %q has an empty path segment or a trailing slash", id)
+			}
+			if segment == "." || segment == ".." {
+				return nil, fmt.Errorf("spiffe id %q has a relative path segment", id)
+			}
+			for _, c := range segment {
+				if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+					return nil, fmt.Errorf("spiffe id %q: path may only hold letters, digits, dots, dashes and underscores", id)
+				}
+			}
+		}
+	}
+	return url.Parse(id)
+}
+
+// ParseWorkloadSPIFFEID parses the spiffe id of a workload, which must be in
+// trustDomain and, unlike the id of the trust domain itself, have a path
+func ParseWorkloadSPIFFEID(id, trustDomain string) (*url.URL, error) {
+	if trustDomain == "" {
+		return nil, errors.New("no spiffe trust domain is configured, set spiffe.trust_domain in config.json")
+	}
+	u, err := ParseSPIFFEID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != trustDomain {
+		return nil, fmt.Errorf("spiffe id %s is not in the trust domain %s", id, trustDomain)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("spiffe id %s has no path, only the trust domain", id)
+	}
+	return u, nil
+}
+
+// IsSPIFFEID reports whether a uri is a spiffe id
+func IsSPIFFEID(u *url.URL) bool {
+	return u.Scheme == "spiffe"
+}
@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"os"
+	"strings"
+	"text/template"
+)
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// Subject holds the distinguished name fields of a certificate subject.
+// Field values may contain text/template actions, see Expand.
+type Subject struct {
+	CommonName         string   `json:"common_name,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"state,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	StreetAddress      []string `json:"street,omitempty"`
+	PostalCode         []string `json:"postal_code,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	SerialNumber       string   `json:"serial_number,omitempty"`
+	Email              []string `json:"email,omitempty"`
+}
+
+// SubjectTemplateData is available to templates in subject fields
+type SubjectTemplateData struct {
+	Name string
+	Env  map[string]string
+}
+
+// SubjectFromName converts a parsed subject, such as that of an issued
+// certificate, back into its fields
+func SubjectFromName(name pkix.Name) Subject {
+	subject := Subject{
+		CommonName:         name.CommonName,
+		Country:            name.Country,
+		Province:           name.Province,
+		Locality:           name.Locality,
+		StreetAddress:      name.StreetAddress,
+		PostalCode:         name.PostalCode,
+		Organization:       name.Organization,
+		OrganizationalUnit: name.OrganizationalUnit,
+		SerialNumber:       name.SerialNumber,
+	}
+	for _, atv := range name.Names {
+		if value, ok := atv.Value.(string); ok && atv.Type.Equal(oidEmailAddress) {
+			subject.Email = append(subject.Email, value)
+		}
+	}
+	return subject
+}
+
+// Merge returns a copy of s with every field that is set in other replaced
+func (s Subject) Merge(other Subject) Subject {
+	if other.CommonName != "" {
+		s.CommonName = other.CommonName
+	}
+	if len(other.Country) > 0 {
+		s.Country = other.Country
+	}
+	if len(other.Province) > 0 {
+		s.Province = other.Province
+	}
+	if len(other.Locality) > 0 {
+		s.Locality = other.Locality
+	}
+	if len(other.StreetAddress) > 0 {
+		s.StreetAddress = other.StreetAddress
+	}
+	if len(other.PostalCode) > 0 {
+		s.PostalCode = other.PostalCode
+	}
+	if len(other.Organization) > 0 {
+		s.Organization = other.Organization
+	}
+	if len(other.OrganizationalUnit) > 0 {
+		s.OrganizationalUnit = other.OrganizationalUnit
+	}
+	if other.SerialNumber != "" {
+		s.SerialNumber = other.SerialNumber
+	}
+	if len(other.Email) > 0 {
+		s.Email = other.Email
+	}
+	return s
+}
+
+// Expand executes any templates in the subject fields, such as
+// {{.Name}} or {{.Env.TEAM}}
+func (s Subject) Expand(name string) (Subject, error) {
+	data := SubjectTemplateData{Name: name, Env: map[string]string{}}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			data.Env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	var err error
+	expand := func(value string) string {
+		if err != nil || !strings.Contains(value, "{{") {
+			return value
+		}
+		var tmpl *template.Template
+		tmpl, err = template.New("subject").Option("missingkey=error").Parse(value)
+		if err != nil {
+			return value
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, data); err != nil {
+			return value
+		}
+		return buf.String()
+	}
+	expandAll := func(values []string) []string {
+		var expanded []string
+		for _, value := range values {
+			if value = expand(value); value != "" {
+				expanded = append(expanded, value)
+			}
+		}
+		return expanded
+	}
+
+	s.CommonName = expand(s.CommonName)
+	s.Country = expandAll(s.Country)
+	s.Province = expandAll(s.Province)
+	s.Locality = expandAll(s.Locality)
+	s.StreetAddress = expandAll(s.StreetAddress)
+	s.PostalCode = expandAll(s.PostalCode)
+	s.Organization = expandAll(s.Organization)
+	s.OrganizationalUnit = expandAll(s.OrganizationalUnit)
+	s.SerialNumber = expand(s.SerialNumber)
+	s.Email = expandAll(s.Email)
+	return s, err
+}
+
+// Name converts the subject into a pkix.Name suitable for a certificate request
+func (s Subject) Name() pkix.Name {
+	name := pkix.Name{
+		CommonName:         s.CommonName,
+		Country:            s.Country,
+		Province:           s.Province,
+		Locality:           s.Locality,
+		StreetAddress:      s.StreetAddress,
+		PostalCode:         s.PostalCode,
+		Organization:       s.Organization,
+		OrganizationalUnit: s.OrganizationalUnit,
+		SerialNumber:       s.SerialNumber,
+	}
+	// emailAddress must be encoded as an IA5String
+	for _, email := range s.Email {
+		name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  oidEmailAddress,
+			Value: asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(email)},
+		})
+	}
+	return name
+}
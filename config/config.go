@@ -0,0 +1,239 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/paths"
+)
+
+// Config is read from config.json in the base directory. Every field is
+// optional and a missing file is equivalent to an empty config.
+type Config struct {
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	CT       CT                 `json:"ct,omitempty"`
+	Approval Approval           `json:"approval,omitempty"`
+	// Deploy maps certificate names to where they are copied after issuance
+	Deploy  map[string]Deploy `json:"deploy,omitempty"`
+	Renewal Renewal           `json:"renewal,omitempty"`
+	Notify  Notify            `json:"notify,omitempty"`
+	Lint    Lint              `json:"lint,omitempty"`
+	// Extensions are added to every certificate the ca signs, with profiles
+	// able to override each of them
+	Extensions certs.Extensions `json:"extensions,omitempty"`
+	SPIFFE     SPIFFE           `json:"spiffe,omitempty"`
+	CRL        CRL              `json:"crl,omitempty"`
+	Audit      Audit            `json:"audit,omitempty"`
+}
+
+// Audit configures the audit log
+type Audit struct {
+	// Key is the path of the key that signs the audit log, defaulting to
+	// audit/audit-key.pem in the base directory. Anyone who can read it can
+	// rewrite the log and sign it again, so it is best kept where those who
+	// can write the base directory cannot.
+	Key string `json:"key,omitempty"`
+}
+
+// CRL configures the certificate revocation list
+type CRL struct {
+	// Lifetime is how many days a crl is valid for, defaulting to 7. The
+	// renewal daemon issues a new one halfway through.
+	Lifetime int `json:"lifetime,omitempty"`
+}
+
+// SPIFFE configures issuing spiffe x.509 svids
+type SPIFFE struct {
+	// TrustDomain is the trust domain every spiffe id the ca issues must be in
+	TrustDomain string `json:"trust_domain,omitempty"`
+	// RefreshHint tells relying parties how often to fetch the trust bundle,
+	// in seconds
+	RefreshHint int `json:"refresh_hint,omitempty"`
+}
+
+// Lint configures the certificate linter
+type Lint struct {
+	// Enabled lints every certificate before it is signed, refusing to sign
+	// it if there are errors
+	Enabled bool `json:"enabled,omitempty"`
+	// MaxLifetime is the longest a leaf certificate may be valid for in
+	// days, defaulting to 398
+	MaxLifetime int `json:"max_lifetime,omitempty"`
+	// Ignore lists rules that are not run
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// Renewal sets when certificates are renewed and how the renewal daemon runs
+type Renewal struct {
+	// Before is how many days before expiry a certificate is renewed,
+	// defaulting to 30
+	Before int `json:"before,omitempty"`
+	// Window is how many days from that point the daemon spreads renewals
+	// over, defaulting to a third of Before
+	Window int `json:"window,omitempty"`
+	// Status is an address or unix socket path for the daemon to serve its
+	// status on
+	Status string `json:"status,omitempty"`
+}
+
+// CT configures the local certificate transparency log
+type CT struct {
+	// Enabled logs every certificate signed by the ca
+	Enabled bool `json:"enabled,omitempty"`
+	// EmbedSCTs also logs a precertificate and embeds its timestamp in the final certificate
+	EmbedSCTs bool `json:"embed_scts,omitempty"`
+}
+
+// Profile holds issuance defaults that can be selected with --profile
+type Profile struct {
+	// InheritSubject copies country, state, locality, organization and
+	// organizational unit from the root ca certificate
+	InheritSubject bool          `json:"inherit_subject,omitempty"`
+	Subject        certs.Subject `json:"subject,omitempty"`
+	Key            Key           `json:"key,omitempty"`
+	// Extensions override the ca's extensions field by field
+	Extensions certs.Extensions `json:"extensions,omitempty"`
+}
+
+// Key sets how leaf keys are generated and written, each field being
+// overridden by the matching flag
+type Key struct {
+	// Type is rsa, ecdsa or ed25519
+	Type  string `json:"type,omitempty"`
+	Bits  int    `json:"bits,omitempty"`
+	Curve string `json:"curve,omitempty"`
+	// Format is pkcs1, pkcs8, sec1 or openssh
+	Format string `json:"format,omitempty"`
+	// Encrypt encrypts keys with the key password
+	Encrypt bool `json:"encrypt,omitempty"`
+	// Mode is an octal file mode such as "0640"
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Deploy copies a certificate and its key to other places whenever it is
+// issued or renewed, then runs hooks to pick up the new files
+type Deploy struct {
+	Files []DeployFile `json:"files,omitempty"`
+	Hooks []Hook       `json:"hooks,omitempty"`
+}
+
+// DeployFile is a single file written for a deploy target
+type DeployFile struct {
+	Path string `json:"path"`
+	// Contents is key, cert, chain, fullchain or combined, which is the
+	// full chain followed by the key
+	Contents string `json:"contents"`
+	// Mode is an octal file mode, defaulting to "0600" for files holding the
+	// key and "0644" otherwise
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Hook runs after a deploy target's files are written, either a command or
+// a signal to the process in a pidfile
+type Hook struct {
+	// Command is run by the shell with the certificate described in
+	// HANCOCK_* environment variables
+	Command string `json:"command,omitempty"`
+	Pidfile string `json:"pidfile,omitempty"`
+	// Signal sent to the process in the pidfile, defaulting to HUP
+	Signal string `json:"signal,omitempty"`
+	// Timeout in seconds for the command, defaulting to 60
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Notify sends warnings as certificates approach expiry
+type Notify struct {
+	// Thresholds are the days before expiry to warn at, defaulting to 30,
+	// 14, 7 and 1. Each is only sent once per certificate.
+	Thresholds []int `json:"thresholds,omitempty"`
+	// Recipients are emailed about every certificate
+	Recipients []string `json:"recipients,omitempty"`
+	// Contacts maps certificate names to addresses emailed only about them
+	Contacts map[string][]string `json:"contacts,omitempty"`
+	SMTP     SMTP                `json:"smtp,omitempty"`
+	Webhooks []Webhook           `json:"webhooks,omitempty"`
+	// Interval is the number of minutes between checks in daemon mode,
+	// defaulting to 60
+	Interval int `json:"interval,omitempty"`
+}
+
+// SMTP is the mail server notifications are sent through, using STARTTLS
+// when the server offers it
+type SMTP struct {
+	Host string `json:"host,omitempty"`
+	// Port defaults to 25
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from,omitempty"`
+}
+
+// Webhook posts each notification as json
+type Webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a text/template for the request body, with the
+	// notification as its data and a json function to quote values
+	Template string `json:"template,omitempty"`
+}
+
+func Load(baseDir string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(paths.GetConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(bytes, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", paths.GetConfigPath(baseDir), err)
+	}
+	return config, nil
+}
+
+// Approval queues certificate requests until enough approvers accept them
+type Approval struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Required is the number of distinct approvals needed, defaulting to one
+	Required int `json:"required,omitempty"`
+	// Approvers lists the operating system users allowed to decide on
+	// requests, anyone may if empty
+	Approvers []string `json:"approvers,omitempty"`
+	// Lifetime in days for requests that do not specify one, defaulting to 90
+	Lifetime int `json:"lifetime,omitempty"`
+	// MaxLifetime in days that a request may ask for, defaulting to Lifetime
+	MaxLifetime int `json:"max_lifetime,omitempty"`
+	// Submitters maps the names of those allowed to submit requests over
+	// http to the sha256 hex digest of their bearer token. Names should be
+	// operating system users, so nobody can approve their own requests.
+	Submitters map[string]string `json:"submitters,omitempty"`
+}
+
+// GetProfile returns the named profile, or an empty profile if name is empty
+func (c *Config) GetProfile(name string) (*Profile, error) {
+	if name == "" {
+		return &Profile{}, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in config", name)
+	}
+	return &profile, nil
+}
+
+// GetExtensions returns the ca's extensions overridden by those of the named
+// profile
+func (c *Config) GetExtensions(profileName string) (certs.Extensions, error) {
+	profile, err := c.GetProfile(profileName)
+	if err != nil {
+		return certs.Extensions{}, err
+	}
+	return c.Extensions.Merge(profile.Extensions), nil
+}
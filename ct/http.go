@@ -0,0 +1,268 @@
+package ct
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// maximum number of entries returned by a single get-entries request
+const maxGetEntries = 1000
+
+type handler struct {
+	baseDir string
+	roots   []*x509.Certificate
+	// serializes appends between concurrent requests
+	mu sync.Mutex
+}
+
+type addChainRequest struct {
+	Chain [][]byte `json:"chain"`
+}
+
+type addChainResponse struct {
+	SCTVersion int    `json:"sct_version"`
+	ID         []byte `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions string `json:"extensions"`
+	Signature  []byte `json:"signature"`
+}
+
+// NewHandler serves the RFC 6962 ct/v1 api for the log in baseDir, mounted
+// at /ct/v1/. Only chains issued by one of roots are accepted.
+func NewHandler(baseDir string, roots []*x509.Certificate) http.Handler {
+	h := &handler{baseDir: baseDir, roots: roots}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ct/v1/add-chain", h.addChain)
+	mux.HandleFunc("/ct/v1/add-pre-chain", h.addChain)
+	mux.HandleFunc("/ct/v1/get-sth", h.getSTH)
+	mux.HandleFunc("/ct/v1/get-sth-consistency", h.getSTHConsistency)
+	mux.HandleFunc("/ct/v1/get-proof-by-hash", h.getProofByHash)
+	mux.HandleFunc("/ct/v1/get-entries", h.getEntries)
+	mux.HandleFunc("/ct/v1/get-roots", h.getRoots)
+	mux.HandleFunc("/ct/v1/get-entry-and-proof", h.getEntryAndProof)
+	return mux
+}
+
+func (h *handler) addChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var req addChainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Chain) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("empty chain"))
+		return
+	}
+	leaf, err := x509.ParseCertificate(req.Chain[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var issuer *x509.Certificate
+	for _, root := range h.roots {
+		if leaf.CheckSignatureFrom(root) == nil {
+			issuer = root
+			break
+		}
+	}
+	if issuer == nil {
+		writeError(w, http.StatusBadRequest, errors.New("chain does not lead to an accepted root"))
+		return
+	}
+
+	isPrecert := false
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidExtensionCTPoison) {
+			isPrecert = true
+		}
+	}
+	if isPrecert != (r.URL.Path == "/ct/v1/add-pre-chain") {
+		writeError(w, http.StatusBadRequest, errors.New("precertificates must be submitted to add-pre-chain"))
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var sct *SignedCertificateTimestamp
+	if isPrecert {
+		sct, err = log.AddPreChain([]*x509.Certificate{leaf, issuer})
+	} else {
+		sct, err = log.AddChain([]*x509.Certificate{leaf, issuer})
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, addChainResponse{
+		ID:         sct.LogID,
+		Timestamp:  sct.Timestamp,
+		Extensions: base64.StdEncoding.EncodeToString(sct.Extensions),
+		Signature:  sct.Signature,
+	})
+}
+
+func (h *handler) getSTH(w http.ResponseWriter, r *http.Request) {
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sth, err := log.SignedTreeHead()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, sth)
+}
+
+func (h *handler) getSTHConsistency(w http.ResponseWriter, r *http.Request) {
+	first, second, err := queryUints(r, "first", "second")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	proof, err := log.ConsistencyProof(first, second)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, map[string][][]byte{"consistency": proof})
+}
+
+func (h *handler) getProofByHash(w http.ResponseWriter, r *http.Request) {
+	hash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid hash: %v", err))
+		return
+	}
+	treeSize, _, err := queryUints(r, "tree_size", "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	index, err := log.LeafIndex(hash, treeSize)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	proof, err := log.InclusionProof(index, treeSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"leaf_index": index, "audit_path": proof})
+}
+
+func (h *handler) getEntries(w http.ResponseWriter, r *http.Request) {
+	start, end, err := queryUints(r, "start", "end")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if start > end {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("start %d is after end %d", start, end))
+		return
+	}
+	if end-start >= maxGetEntries {
+		end = start + maxGetEntries - 1
+	}
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	entries, err := log.Entries(start, end)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, map[string][]Entry{"entries": entries})
+}
+
+func (h *handler) getRoots(w http.ResponseWriter, r *http.Request) {
+	var roots [][]byte
+	for _, root := range h.roots {
+		roots = append(roots, root.Raw)
+	}
+	writeJSON(w, map[string][][]byte{"certificates": roots})
+}
+
+func (h *handler) getEntryAndProof(w http.ResponseWriter, r *http.Request) {
+	index, treeSize, err := queryUints(r, "leaf_index", "tree_size")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log, err := Open(h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	proof, err := log.InclusionProof(index, treeSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := log.Entries(index, index)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"leaf_input": entries[0].LeafInput,
+		"extra_data": entries[0].ExtraData,
+		"audit_path": proof,
+	})
+}
+
+// queryUints parses one or two required unsigned integer query parameters
+func queryUints(r *http.Request, first, second string) (uint64, uint64, error) {
+	var values [2]uint64
+	for i, name := range []string{first, second} {
+		if name == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(r.URL.Query().Get(name), 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		values[i] = value
+	}
+	return values[0], values[1], nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error_message": err.Error()})
+}
@@ -0,0 +1,506 @@
+package ct
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/galenguyer/hancock/lockfile"
+	"github.com/galenguyer/hancock/paths"
+)
+
+var (
+	oidExtensionCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidExtensionSCTList  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+const (
+	// context specific tag of the extensions field in a TBSCertificate
+	tbsExtensionsTag = 3
+
+	entryTypeX509    = 0
+	entryTypePrecert = 1
+
+	signatureTypeCertificateTimestamp = 0
+	signatureTypeTreeHash             = 1
+)
+
+// Entry is a single log entry as stored on disk and returned by get-entries
+type Entry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+// SignedCertificateTimestamp is the promise of inclusion returned for a new entry
+type SignedCertificateTimestamp struct {
+	LogID      []byte
+	Timestamp  uint64
+	Extensions []byte
+	Signature  []byte
+}
+
+// SignedTreeHead is a signed commitment to the log contents at a given size
+type SignedTreeHead struct {
+	TreeSize          uint64 `json:"tree_size"`
+	Timestamp         uint64 `json:"timestamp"`
+	SHA256RootHash    []byte `json:"sha256_root_hash"`
+	TreeHeadSignature []byte `json:"tree_head_signature"`
+}
+
+// Log is an append-only RFC 6962 log stored in the base directory
+type Log struct {
+	baseDir string
+	key     *ecdsa.PrivateKey
+	logID   []byte
+	entries []Entry
+	leaves  [][]byte
+}
+
+// Open loads the log from the base directory, creating its signing key if needed
+func Open(baseDir string) (*Log, error) {
+	err := os.MkdirAll(paths.GetCTPath(baseDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	key, err := getLogKey(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	logID := sha256.Sum256(spki)
+
+	log := &Log{baseDir: baseDir, key: key, logID: logID[:]}
+	if err = log.reload(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func getLogKey(baseDir string) (*ecdsa.PrivateKey, error) {
+	bytes, err := ioutil.ReadFile(paths.GetCTLogKeyPath(baseDir))
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pemBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		return key, ioutil.WriteFile(paths.GetCTLogKeyPath(baseDir), pemBytes, 0600)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, errors.New("invalid ct log key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// reload reads every entry from disk so that entries appended by other
+// processes are visible
+func (l *Log) reload() error {
+	file, err := os.Open(paths.GetCTEntriesPath(l.baseDir))
+	if os.IsNotExist(err) {
+		l.entries, l.leaves = nil, nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	var leaves [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("invalid ct log entry %d: %v", len(entries), err)
+		}
+		entries = append(entries, entry)
+		leaves = append(leaves, leafHash(entry.LeafInput))
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	l.entries, l.leaves = entries, leaves
+	return nil
+}
+
+// PublicKey returns the key that signs timestamps and tree heads
+func (l *Log) PublicKey() crypto.PublicKey {
+	return &l.key.PublicKey
+}
+
+// LogID returns the SHA-256 hash of the log's public key
+func (l *Log) LogID() []byte {
+	return l.logID
+}
+
+// Size returns the number of entries in the log
+func (l *Log) Size() uint64 {
+	return uint64(len(l.entries))
+}
+
+// Entries returns the entries in the range [start, end]
+func (l *Log) Entries(start, end uint64) ([]Entry, error) {
+	if start > end || start >= l.Size() {
+		return nil, fmt.Errorf("invalid range %d to %d for log of size %d", start, end, l.Size())
+	}
+	if end >= l.Size() {
+		end = l.Size() - 1
+	}
+	return l.entries[start : end+1], nil
+}
+
+// AddChain logs a final certificate followed by its issuer chain
+func (l *Log) AddChain(chain []*x509.Certificate) (*SignedCertificateTimestamp, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty chain")
+	}
+	signedEntry := appendUint24Bytes(nil, chain[0].Raw)
+	return l.add(entryTypeX509, signedEntry, appendChain(nil, chain[1:]))
+}
+
+// AddPreChain logs a precertificate followed by its issuer chain. The
+// precertificate must be signed directly by the issuer.
+func (l *Log) AddPreChain(chain []*x509.Certificate) (*SignedCertificateTimestamp, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("precertificate chain must include the issuer")
+	}
+	tbs, err := removeExtension(chain[0].RawTBSCertificate, oidExtensionCTPoison)
+	if err != nil {
+		return nil, err
+	}
+	issuerKeyHash := sha256.Sum256(chain[1].RawSubjectPublicKeyInfo)
+	signedEntry := appendUint24Bytes(issuerKeyHash[:], tbs)
+	extraData := appendUint24Bytes(nil, chain[0].Raw)
+	return l.add(entryTypePrecert, signedEntry, appendChain(extraData, chain[1:]))
+}
+
+func (l *Log) add(entryType uint16, signedEntry, extraData []byte) (*SignedCertificateTimestamp, error) {
+	timestamp := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	// MerkleTreeLeaf with an empty extensions field
+	leaf := []byte{0, 0}
+	leaf = appendUint64(leaf, timestamp)
+	leaf = appendUint16(leaf, entryType)
+	leaf = append(leaf, signedEntry...)
+	leaf = appendUint16(leaf, 0)
+
+	// the signature input matches the leaf apart from the signature type
+	signatureInput := append([]byte{0, signatureTypeCertificateTimestamp}, leaf[2:]...)
+	signature, err := l.sign(signatureInput)
+	if err != nil {
+		return nil, err
+	}
+
+	entryBytes, err := json.Marshal(Entry{LeafInput: leaf, ExtraData: extraData})
+	if err != nil {
+		return nil, err
+	}
+	// the tree head saved below has to cover this entry and every entry
+	// other processes appended before it
+	unlock, err := lockfile.Lock(paths.GetCTLockPath(l.baseDir), "ct log")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	file, err := os.OpenFile(paths.GetCTEntriesPath(l.baseDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err = file.Write(append(entryBytes, '\n')); err != nil {
+		return nil, err
+	}
+	if err = l.reload(); err != nil {
+		return nil, err
+	}
+
+	// keep the latest tree head on disk for later consistency checks
+	sth, err := l.SignedTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	if err = saveTreeHead(sth, l.baseDir); err != nil {
+		return nil, err
+	}
+
+	return &SignedCertificateTimestamp{LogID: l.logID, Timestamp: timestamp, Signature: signature}, nil
+}
+
+// SignedTreeHead signs the root hash of the log at its current size
+func (l *Log) SignedTreeHead() (*SignedTreeHead, error) {
+	sth := &SignedTreeHead{
+		TreeSize:       l.Size(),
+		Timestamp:      uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		SHA256RootHash: rootHash(l.leaves),
+	}
+	signature, err := l.sign(treeHeadSignatureInput(sth))
+	if err != nil {
+		return nil, err
+	}
+	sth.TreeHeadSignature = signature
+	return sth, nil
+}
+
+// RootHash returns the root hash of the first treeSize entries
+func (l *Log) RootHash(treeSize uint64) ([]byte, error) {
+	if treeSize > l.Size() {
+		return nil, fmt.Errorf("tree size %d is larger than the log size %d", treeSize, l.Size())
+	}
+	return rootHash(l.leaves[:treeSize]), nil
+}
+
+// LeafIndex finds the index of a leaf hash within the first treeSize entries
+func (l *Log) LeafIndex(hash []byte, treeSize uint64) (uint64, error) {
+	if treeSize > l.Size() {
+		return 0, fmt.Errorf("tree size %d is larger than the log size %d", treeSize, l.Size())
+	}
+	for i := uint64(0); i < treeSize; i++ {
+		if bytes.Equal(l.leaves[i], hash) {
+			return i, nil
+		}
+	}
+	return 0, errors.New("leaf hash not found")
+}
+
+// InclusionProof returns the audit path for an entry in a tree of the given size
+func (l *Log) InclusionProof(leafIndex, treeSize uint64) ([][]byte, error) {
+	if treeSize > l.Size() || leafIndex >= treeSize {
+		return nil, fmt.Errorf("invalid leaf index %d for tree size %d", leafIndex, treeSize)
+	}
+	return auditPath(int(leafIndex), l.leaves[:treeSize]), nil
+}
+
+// ConsistencyProof proves that the tree of size first is a prefix of the tree of size second
+func (l *Log) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	if second > l.Size() || first > second {
+		return nil, fmt.Errorf("invalid tree sizes %d and %d for log of size %d", first, second, l.Size())
+	}
+	return consistencyProof(int(first), l.leaves[:second])
+}
+
+// FindCertificate returns the index of the x509 entry that logged cert
+func (l *Log) FindCertificate(cert *x509.Certificate) (uint64, error) {
+	// version, leaf type, timestamp and entry type precede the certificate
+	const prefixLen = 1 + 1 + 8 + 2
+	want := appendUint16(nil, entryTypeX509)
+	want = appendUint24Bytes(want, cert.Raw)
+	for i, entry := range l.entries {
+		if len(entry.LeafInput) > prefixLen && bytes.Equal(entry.LeafInput[prefixLen-2:len(entry.LeafInput)-2], want) {
+			return uint64(i), nil
+		}
+	}
+	return 0, errors.New("certificate not found in ct log")
+}
+
+// VerifyTreeHead checks the signature on a tree head and that it matches the log contents
+func (l *Log) VerifyTreeHead(sth *SignedTreeHead) error {
+	digest := sha256.Sum256(treeHeadSignatureInput(sth))
+	signature, err := parseDigitallySigned(sth.TreeHeadSignature)
+	if err != nil {
+		return err
+	}
+	if !ecdsa.VerifyASN1(&l.key.PublicKey, digest[:], signature) {
+		return errors.New("tree head signature is invalid")
+	}
+	root, err := l.RootHash(sth.TreeSize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, sth.SHA256RootHash) {
+		return fmt.Errorf("root hash at tree size %d does not match the signed tree head, the log has been modified", sth.TreeSize)
+	}
+	return nil
+}
+
+// GetSavedTreeHead returns the tree head written after the last append
+func GetSavedTreeHead(baseDir string) (*SignedTreeHead, error) {
+	bytes, err := ioutil.ReadFile(paths.GetCTTreeHeadPath(baseDir))
+	if err != nil {
+		return nil, err
+	}
+	sth := &SignedTreeHead{}
+	return sth, json.Unmarshal(bytes, sth)
+}
+
+func saveTreeHead(sth *SignedTreeHead, baseDir string) error {
+	bytes, err := json.MarshalIndent(sth, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(paths.GetCTTreeHeadPath(baseDir), append(bytes, '\n'), 0644)
+}
+
+func treeHeadSignatureInput(sth *SignedTreeHead) []byte {
+	input := []byte{0, signatureTypeTreeHash}
+	input = appendUint64(input, sth.Timestamp)
+	input = appendUint64(input, sth.TreeSize)
+	return append(input, sth.SHA256RootHash...)
+}
+
+// sign produces a DigitallySigned struct using SHA-256 and ECDSA
+func (l *Log) sign(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+	signature, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return appendUint16Bytes([]byte{4, 3}, signature), nil
+}
+
+func parseDigitallySigned(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 4 || data[1] != 3 {
+		return nil, errors.New("unsupported signature algorithm")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) != 4+length {
+		return nil, errors.New("invalid signature length")
+	}
+	return data[4:], nil
+}
+
+// Serialize encodes the timestamp as a TLS SignedCertificateTimestamp struct
+func (sct *SignedCertificateTimestamp) Serialize() []byte {
+	data := append([]byte{0}, sct.LogID...)
+	data = appendUint64(data, sct.Timestamp)
+	data = appendUint16Bytes(data, sct.Extensions)
+	return append(data, sct.Signature...)
+}
+
+// SCTListExtension builds the extension that embeds timestamps in a final certificate
+func SCTListExtension(scts []*SignedCertificateTimestamp) (pkix.Extension, error) {
+	var list []byte
+	for _, sct := range scts {
+		list = appendUint16Bytes(list, sct.Serialize())
+	}
+	value, err := asn1.Marshal(appendUint16Bytes(nil, list))
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionSCTList, Value: value}, nil
+}
+
+// EmbedSCT signs a precertificate for the template with sign, logs it and
+// adds the resulting timestamp to the template's extensions
+func (l *Log) EmbedSCT(template, issuer *x509.Certificate, sign func(*x509.Certificate) ([]byte, error)) error {
+	precertTemplate := *template
+	precertTemplate.ExtraExtensions = append(append([]pkix.Extension{}, template.ExtraExtensions...), pkix.Extension{
+		Id:       oidExtensionCTPoison,
+		Critical: true,
+		Value:    asn1.NullBytes,
+	})
+	precertBytes, err := sign(&precertTemplate)
+	if err != nil {
+		return err
+	}
+	precert, err := x509.ParseCertificate(precertBytes)
+	if err != nil {
+		return err
+	}
+	sct, err := l.AddPreChain([]*x509.Certificate{precert, issuer})
+	if err != nil {
+		return err
+	}
+	ext, err := SCTListExtension([]*SignedCertificateTimestamp{sct})
+	if err != nil {
+		return err
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	return nil
+}
+
+// removeExtension strips an extension from a DER TBSCertificate
+func removeExtension(tbs []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	var tbsSeq asn1.RawValue
+	if _, err := asn1.Unmarshal(tbs, &tbsSeq); err != nil {
+		return nil, err
+	}
+	var fields []byte
+	for rest := tbsSeq.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return nil, err
+		}
+		if field.Class != asn1.ClassContextSpecific || field.Tag != tbsExtensionsTag {
+			fields = append(fields, field.FullBytes...)
+			continue
+		}
+
+		var extensions []pkix.Extension
+		if _, err = asn1.Unmarshal(field.Bytes, &extensions); err != nil {
+			return nil, err
+		}
+		var kept []pkix.Extension
+		for _, ext := range extensions {
+			if !ext.Id.Equal(oid) {
+				kept = append(kept, ext)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		extBytes, err := asn1.Marshal(kept)
+		if err != nil {
+			return nil, err
+		}
+		fieldBytes, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tbsExtensionsTag, IsCompound: true, Bytes: extBytes})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fieldBytes...)
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+func appendChain(data []byte, chain []*x509.Certificate) []byte {
+	var certs []byte
+	for _, cert := range chain {
+		certs = appendUint24Bytes(certs, cert.Raw)
+	}
+	return appendUint24Bytes(data, certs)
+}
+
+func appendUint16(data []byte, v uint16) []byte {
+	return append(data, byte(v>>8), byte(v))
+}
+
+func appendUint64(data []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(data, buf[:]...)
+}
+
+func appendUint16Bytes(data, v []byte) []byte {
+	return append(appendUint16(data, uint16(len(v))), v...)
+}
+
+func appendUint24Bytes(data, v []byte) []byte {
+	n := len(v)
+	return append(append(data, byte(n>>16), byte(n>>8), byte(n)), v...)
+}
@@ -0,0 +1,80 @@
+package ct
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// merkle tree hashing from RFC 6962 section 2.1
+
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two smaller than n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// rootHash computes MTH over already hashed leaves
+func rootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+// auditPath computes PATH(m, D[n]) over already hashed leaves
+func auditPath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+	k := split(len(leaves))
+	if m < k {
+		return append(auditPath(m, leaves[:k]), rootHash(leaves[k:]))
+	}
+	return append(auditPath(m-k, leaves[k:]), rootHash(leaves[:k]))
+}
+
+// consistencyProof computes PROOF(m, D[n]) over already hashed leaves
+func consistencyProof(m int, leaves [][]byte) ([][]byte, error) {
+	if m <= 0 || m > len(leaves) {
+		return nil, fmt.Errorf("invalid tree sizes %d and %d", m, len(leaves))
+	}
+	return subProof(m, leaves, true), nil
+}
+
+func subProof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{rootHash(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subProof(m, leaves[:k], complete), rootHash(leaves[k:]))
+	}
+	return append(subProof(m-k, leaves[k:], false), rootHash(leaves[:k]))
+}
@@ -0,0 +1,167 @@
+package ct
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// the leaves and roots used by the RFC 6962 reference implementation's tests
+var testLeaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var testRoots = []string{
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func testLeafHashes(t *testing.T) [][]byte {
+	var hashes [][]byte
+	for _, leaf := range testLeaves {
+		data, err := hex.DecodeString(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, leafHash(data))
+	}
+	return hashes
+}
+
+func TestRootHash(t *testing.T) {
+	leaves := testLeafHashes(t)
+	for n, want := range testRoots {
+		if got := hex.EncodeToString(rootHash(leaves[:n])); got != want {
+			t.Errorf("root of %d leaves = %s, want %s", n, got, want)
+		}
+	}
+}
+
+// verifyInclusion checks an audit path as a client would, following RFC
+// 9162 section 2.1.3.2
+func verifyInclusion(index, size int, leaf []byte, path [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn, r := index, size-1, leaf
+	for _, p := range path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// verifyConsistency checks a consistency proof as a client would, following
+// RFC 9162 section 2.1.4.2
+func verifyConsistency(first, second int, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	if first == second {
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
+
+func TestAuditPath(t *testing.T) {
+	leaves := testLeafHashes(t)
+	for n := 1; n <= len(leaves); n++ {
+		root := rootHash(leaves[:n])
+		for m := 0; m < n; m++ {
+			path := auditPath(m, leaves[:n])
+			if !verifyInclusion(m, n, leaves[m], path, root) {
+				t.Errorf("audit path for leaf %d of %d does not verify", m, n)
+			}
+			// the path must not prove a different leaf
+			if m+1 < n && verifyInclusion(m, n, leaves[m+1], path, root) {
+				t.Errorf("audit path for leaf %d of %d verifies leaf %d", m, n, m+1)
+			}
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	leaves := testLeafHashes(t)
+	for n := 1; n <= len(leaves); n++ {
+		for m := 1; m <= n; m++ {
+			proof, err := consistencyProof(m, leaves[:n])
+			if err != nil {
+				t.Fatalf("consistency proof from %d to %d: %v", m, n, err)
+			}
+			if !verifyConsistency(m, n, rootHash(leaves[:m]), rootHash(leaves[:n]), proof) {
+				t.Errorf("consistency proof from %d to %d does not verify", m, n)
+			}
+		}
+	}
+	if _, err := consistencyProof(0, leaves); err == nil {
+		t.Error("consistency proof from an empty tree did not fail")
+	}
+	if _, err := consistencyProof(len(leaves)+1, leaves); err == nil {
+		t.Error("consistency proof to a smaller tree did not fail")
+	}
+}
+
+func TestConsistencyProofRejectsChangedHistory(t *testing.T) {
+	leaves := testLeafHashes(t)
+	changed := append([][]byte{}, leaves...)
+	changed[2] = leafHash([]byte("changed"))
+	proof, err := consistencyProof(4, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyConsistency(4, len(leaves), rootHash(leaves[:4]), rootHash(changed), proof) {
+		t.Error("consistency proof verified a tree whose history was changed")
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/metrics"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/requests"
+)
+
+// default number of days before expiry that certificates are renewed
+const defaultRenewBefore = 30
+
+const (
+	// how often the daemon looks for certificates issued or renewed elsewhere
+	rescanInterval = 5 * time.Minute
+	minRetryDelay  = 5 * time.Minute
+	maxRetryDelay  = 6 * time.Hour
+	// number of failures kept for the status endpoint
+	recentFailures = 50
+)
+
+// renewalJob tracks when the current certificate for a name is next renewed
+type renewalJob struct {
+	Name     string    `json:"name"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+	Next     time.Time `json:"next"`
+	// DeployPending is set when the certificate was renewed but deploying it
+	// failed, so the next attempt only retries the deploy
+	DeployPending bool   `json:"deploy_pending,omitempty"`
+	Attempts      int    `json:"attempts,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	// Request is the renewal waiting for approval, if any
+	Request string `json:"request,omitempty"`
+
+	cert *x509.Certificate
+}
+
+type renewalFailure struct {
+	Time  time.Time `json:"time"`
+	Name  string    `json:"name"`
+	Error string    `json:"error"`
+}
+
+type renewalDaemon struct {
+	leafKey  LeafKey
+	password string
+	baseDir  string
+	started  time.Time
+
+	mu       sync.Mutex
+	conf     *config.Config
+	jobs     map[string]*renewalJob
+	failures []renewalFailure
+}
+
+// RunRenewalDaemon renews certificates as they come due until it is
+// interrupted. Each certificate is renewed at a point in its renewal window
+// picked from its name and serial, so that renewals are spread out but stay
+// put across restarts. Failures are retried with exponential backoff and
+// SIGHUP reloads config.json, except for the status address.
+func RunRenewalDaemon(leafKey LeafKey, status, password, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	// unlock the root key now, as there is nobody to prompt later
+	password, err = readRootPassword(password, baseDir)
+	if err != nil {
+		return err
+	}
+	if _, err = getRootKey(password, baseDir); err != nil {
+		return err
+	}
+
+	d := &renewalDaemon{
+		leafKey:  leafKey,
+		password: password,
+		baseDir:  baseDir,
+		started:  time.Now(),
+		conf:     conf,
+		jobs:     map[string]*renewalJob{},
+	}
+
+	if status == "" {
+		status = conf.Renewal.Status
+	}
+	if status != "" {
+		listener, err := listenStatus(status)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/", d)
+		mux.Handle("/metrics", metrics.NewHandler(baseDir, d.metrics))
+		go http.Serve(listener, mux)
+		fmt.Printf("serving renewal status on %s\n", status)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Println("renewal daemon started")
+	for {
+		if err := d.rescan(); err != nil {
+			fmt.Println(err)
+			d.fail("", err)
+		}
+		d.runDue()
+		if err := d.refreshCRL(); err != nil {
+			fmt.Printf("issuing the crl failed: %v\n", err)
+			d.fail("", err)
+		}
+
+		select {
+		case <-time.After(d.wait()):
+		case <-hup:
+			d.reload()
+		case <-stop:
+			fmt.Println("renewal daemon stopped")
+			return nil
+		}
+	}
+}
+
+// listenStatus listens on a unix socket if addr is a path, and on tcp otherwise
+func listenStatus(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") || strings.HasPrefix(addr, "/") {
+		path := strings.TrimPrefix(addr, "unix:")
+		// remove a socket left behind by a previous run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (d *renewalDaemon) reload() {
+	conf, err := config.Load(d.baseDir)
+	if err != nil {
+		fmt.Printf("keeping the previous config: %v\n", err)
+		return
+	}
+	d.mu.Lock()
+	d.conf = conf
+	// pick up a changed renewal window for everything not being retried
+	for _, job := range d.jobs {
+		if job.Attempts == 0 {
+			job.Next = time.Time{}
+		}
+	}
+	d.mu.Unlock()
+	fmt.Println("reloaded config")
+}
+
+// rescan picks up certificates issued, renewed or removed outside the daemon
+func (d *renewalDaemon) rescan() error {
+	rootCACert, err := certs.GetRootCACert(d.baseDir)
+	if err != nil {
+		return err
+	}
+	children, err := ioutil.ReadDir(paths.GetCertificatesPath(d.baseDir))
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := map[string]bool{}
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+		cert, err := certs.GetCert(child.Name(), d.baseDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			d.failLocked(child.Name(), err)
+			continue
+		}
+		// certificates whose key is held elsewhere are not renewed here
+		if held, err := keyHeld(child.Name(), cert, d.baseDir); err != nil {
+			d.failLocked(child.Name(), err)
+			continue
+		} else if !held {
+			continue
+		}
+		seen[child.Name()] = true
+
+		job, ok := d.jobs[child.Name()]
+		if !ok || job.Serial != cert.SerialNumber.Text(16) {
+			job = &renewalJob{
+				Name:     child.Name(),
+				Serial:   cert.SerialNumber.Text(16),
+				NotAfter: cert.NotAfter,
+				cert:     cert,
+			}
+			d.jobs[child.Name()] = job
+		}
+		// nothing is renewed while a renewal waits for approval, which
+		// replaces the certificate once it is given
+		job.Request = ""
+		if d.conf.Approval.Enabled {
+			pending, err := requests.Pending(job.Name, d.baseDir)
+			if err != nil {
+				d.failLocked(job.Name, err)
+				continue
+			} else if pending != nil {
+				job.Request = pending.ID
+				job.Next = time.Time{}
+				job.Attempts = 0
+				job.LastError = ""
+				continue
+			}
+		}
+		// a rollover can make a certificate due at any time, so check again
+		// unless it is waiting on a retry
+		if job.Attempts == 0 {
+			job.Next, err = d.schedule(job.Name, cert, rootCACert)
+			if err != nil {
+				d.failLocked(job.Name, err)
+			}
+		}
+	}
+	for name := range d.jobs {
+		if !seen[name] {
+			delete(d.jobs, name)
+		}
+	}
+	return nil
+}
+
+// schedule picks when a certificate is renewed: immediately if a rollover
+// needs it, otherwise at a point in its renewal window given by its name and
+// serial
+func (d *renewalDaemon) schedule(name string, cert, rootCACert *x509.Certificate) (time.Time, error) {
+	before := renewBeforeCert(cert, renewBefore(d.conf))
+	window := before / 3
+	if d.conf.Renewal.Window > 0 {
+		window = time.Duration(d.conf.Renewal.Window) * 24 * time.Hour
+	}
+	if window > before {
+		window = before
+	}
+
+	_, rollover, err := renewalDue(name, cert, rootCACert, before, d.baseDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	if rollover {
+		return now, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write(cert.SerialNumber.Bytes())
+	offset := time.Duration(float64(window) * float64(h.Sum32()) / (1 << 32))
+	next := cert.NotAfter.Add(-before).Add(offset)
+	if next.Before(now) {
+		return now, nil
+	}
+	return next, nil
+}
+
+// runDue renews every certificate whose time has come
+func (d *renewalDaemon) runDue() {
+	d.mu.Lock()
+	var due []*renewalJob
+	now := time.Now()
+	for _, job := range d.jobs {
+		if !job.Next.IsZero() && !job.Next.After(now) {
+			due = append(due, job)
+		}
+	}
+	d.mu.Unlock()
+
+	// the renewals run without the lock so that status requests are not held up
+	for _, job := range due {
+		d.run(job)
+	}
+	// schedule the renewed certificates
+	if len(due) > 0 {
+		if err := d.rescan(); err != nil {
+			d.fail("", err)
+		}
+	}
+}
+
+func (d *renewalDaemon) run(job *renewalJob) {
+	d.mu.Lock()
+	serial := job.Serial
+	d.mu.Unlock()
+	var err error
+	if job.DeployPending {
+		fmt.Printf("retrying deploy for %s\n", job.Name)
+		err = deployCert(job.Name, d.baseDir)
+	} else {
+		err = d.renew(job)
+		// a renewal that left the certificate alone would otherwise be
+		// picked up again straight away, unless it is waiting for approval
+		if err == nil && job.Serial == serial {
+			var pending *requests.Request
+			if pending, err = requests.Pending(job.Name, d.baseDir); err == nil && pending == nil {
+				err = errors.New("the certificate was not replaced")
+			} else if err == nil {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				job.Request = pending.ID
+				job.Attempts = 0
+				job.LastError = ""
+				job.Next = time.Time{}
+				return
+			}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var deployErr *deployError
+	if errors.As(err, &deployErr) {
+		job.DeployPending = true
+	} else if err == nil {
+		job.DeployPending = false
+	}
+	if err != nil {
+		d.failLocked(job.Name, err)
+		job.Attempts++
+		job.LastError = err.Error()
+		job.Next = time.Now().Add(retryDelay(job.Attempts))
+		fmt.Printf("%s failed, retrying at %s: %v\n", job.Name, job.Next.Local().Format(time.RFC3339), err)
+		return
+	}
+	job.Attempts = 0
+	job.LastError = ""
+	// the next rescan schedules the renewed certificate
+	job.Next = time.Time{}
+}
+
+func (d *renewalDaemon) renew(job *renewalJob) error {
+	rootCACert, err := certs.GetRootCACert(d.baseDir)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	before := renewBefore(d.conf)
+	d.mu.Unlock()
+	_, rollover, err := renewalDue(job.Name, job.cert, rootCACert, before, d.baseDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renewing %s, which expires %s\n", job.Name, job.NotAfter.Local().Format("2006-01-02"))
+	err = renewCert(job.Name, job.cert, d.leafKey.like(job.cert.PublicKey), rollover, d.password, d.baseDir)
+
+	// track the new certificate even if deploying it failed
+	if cert, certErr := certs.GetCert(job.Name, d.baseDir); certErr == nil && cert.SerialNumber.Text(16) != job.Serial {
+		d.mu.Lock()
+		job.Serial = cert.SerialNumber.Text(16)
+		job.NotAfter = cert.NotAfter
+		job.cert = cert
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// refreshCRL issues a new crl before the current one runs out
+func (d *renewalDaemon) refreshCRL() error {
+	due, err := crlDue(d.baseDir)
+	if err != nil || !due {
+		return err
+	}
+	rootKey, err := getRootKey(d.password, d.baseDir)
+	if err != nil {
+		return err
+	}
+	return updateCRL(rootKey, d.baseDir)
+}
+
+// retryDelay doubles with each failed attempt, up to maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// wait returns how long to sleep until the next renewal or rescan
+func (d *renewalDaemon) wait() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wait := rescanInterval
+	for _, job := range d.jobs {
+		if job.Next.IsZero() {
+			continue
+		}
+		if until := time.Until(job.Next); until < wait {
+			wait = until
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+func (d *renewalDaemon) fail(name string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failLocked(name, err)
+}
+
+func (d *renewalDaemon) failLocked(name string, err error) {
+	d.failures = append(d.failures, renewalFailure{Time: time.Now().UTC(), Name: name, Error: err.Error()})
+	if len(d.failures) > recentFailures {
+		d.failures = d.failures[len(d.failures)-recentFailures:]
+	}
+}
+
+// ServeHTTP reports the next renewal for every certificate and the most
+// recent failures as json
+func (d *renewalDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.mu.Lock()
+	jobs := make([]renewalJob, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, *job)
+	}
+	failures := append([]renewalFailure{}, d.failures...)
+	d.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Next.Before(jobs[j].Next)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Started      time.Time        `json:"started"`
+		Certificates []renewalJob     `json:"certificates"`
+		Failures     []renewalFailure `json:"failures"`
+	}{d.started.UTC(), jobs, failures})
+}
+
+// metrics reports when each certificate is next renewed and how many
+// attempts in a row have failed
+func (d *renewalDaemon) metrics() []metrics.Family {
+	next := metrics.Family{
+		Name: "hancock_renewal_next_timestamp_seconds",
+		Help: "Unix time at which the daemon next renews each certificate.",
+		Type: "gauge",
+	}
+	failing := metrics.Family{
+		Name: "hancock_renewal_failed_attempts",
+		Help: "Failed renewal or deploy attempts in a row for each certificate.",
+		Type: "gauge",
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0, len(d.jobs))
+	for name := range d.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		job := d.jobs[name]
+		labels := map[string]string{"name": job.Name}
+		if !job.Next.IsZero() {
+			next.Samples = append(next.Samples, metrics.Sample{Labels: labels, Value: float64(job.Next.Unix())})
+		}
+		failing.Samples = append(failing.Samples, metrics.Sample{Labels: labels, Value: float64(job.Attempts)})
+	}
+	return []metrics.Family{next, failing}
+}
@@ -0,0 +1,269 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+)
+
+const (
+	ContentsKey       = "key"
+	ContentsCert      = "cert"
+	ContentsChain     = "chain"
+	ContentsFullchain = "fullchain"
+	ContentsCombined  = "combined"
+
+	defaultHookTimeout = 60 * time.Second
+)
+
+var envName = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// Result records what deploying a certificate did
+type Result struct {
+	Files  []string
+	Hooks  int
+	Errors []error
+}
+
+// Run writes every file of a deploy target for the current certificate of
+// name, then runs its hooks. A file that fails to write does not stop the
+// others, but the hooks only run once every file is in place.
+func Run(name string, target config.Deploy, baseDir string) (*Result, error) {
+	material, err := load(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, file := range target.Files {
+		if err := write(file, material); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s: %v", file.Path, err))
+			continue
+		}
+		result.Files = append(result.Files, file.Path)
+	}
+	if len(result.Errors) > 0 {
+		if len(target.Hooks) > 0 {
+			result.Errors = append(result.Errors, fmt.Errorf("skipped %d hooks as not every file was written", len(target.Hooks)))
+		}
+		return result, nil
+	}
+
+	env := append(os.Environ(), material.env(name, result.Files)...)
+	for i, hook := range target.Hooks {
+		if err := runHook(hook, env); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("hook %d: %v", i+1, err))
+			continue
+		}
+		result.Hooks++
+	}
+	return result, nil
+}
+
+// material is everything a deploy file can be built from
+type material struct {
+	cert      *x509.Certificate
+	certPEM   []byte
+	chainPEM  []byte
+	keyPEM    []byte
+	certPath  string
+	keyPath   string
+	chainPath string
+	metadata  map[string]string
+}
+
+func load(name, baseDir string) (*material, error) {
+	certPath, err := paths.GetCertPath(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	keyPath, err := paths.GetRsaKeyPath(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := certs.GetCert(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	// certificates issued from a submitted request have no key here
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := inventory.Get(inventory.Serial(cert), baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	if entry != nil {
+		metadata = entry.Metadata
+	}
+	return &material{
+		cert:      cert,
+		certPEM:   certPEM,
+		chainPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCACert.Raw}),
+		keyPEM:    keyPEM,
+		certPath:  certPath,
+		keyPath:   keyPath,
+		chainPath: paths.GetCACertPath(baseDir),
+		metadata:  metadata,
+	}, nil
+}
+
+// contents returns the bytes for a deploy file and whether they hold the key
+func (m *material) contents(kind string) ([]byte, bool, error) {
+	if (kind == ContentsKey || kind == ContentsCombined) && m.keyPEM == nil {
+		return nil, false, fmt.Errorf("no private key saved for %s", m.cert.Subject.CommonName)
+	}
+	switch kind {
+	case ContentsKey:
+		return m.keyPEM, true, nil
+	case ContentsCert:
+		return m.certPEM, false, nil
+	case ContentsChain:
+		return m.chainPEM, false, nil
+	case ContentsFullchain:
+		return concat(m.certPEM, m.chainPEM), false, nil
+	case ContentsCombined:
+		return concat(m.certPEM, m.chainPEM, m.keyPEM), true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown contents %q, expected key, cert, chain, fullchain or combined", kind)
+	}
+}
+
+// env describes the certificate to hooks
+func (m *material) env(name string, files []string) []string {
+	fingerprint := sha256.Sum256(m.cert.Raw)
+	sans := ""
+	if altNames, err := certs.SANsFromCert(m.cert); err == nil {
+		sans = strings.Join(altNames.Strings(), " ")
+	}
+	env := []string{
+		"HANCOCK_NAME=" + name,
+		"HANCOCK_SERIAL=" + m.cert.SerialNumber.Text(16),
+		"HANCOCK_SUBJECT=" + m.cert.Subject.String(),
+		"HANCOCK_SANS=" + sans,
+		"HANCOCK_NOT_BEFORE=" + m.cert.NotBefore.UTC().Format(time.RFC3339),
+		"HANCOCK_NOT_AFTER=" + m.cert.NotAfter.UTC().Format(time.RFC3339),
+		"HANCOCK_FINGERPRINT=" + hex.EncodeToString(fingerprint[:]),
+		"HANCOCK_CERT_PATH=" + m.certPath,
+		"HANCOCK_KEY_PATH=" + m.keyPath,
+		"HANCOCK_CHAIN_PATH=" + m.chainPath,
+		"HANCOCK_DEPLOYED_FILES=" + strings.Join(files, " "),
+	}
+	// metadata keys become HANCOCK_META_ variables, such as HANCOCK_META_OWNER
+	for key, value := range m.metadata {
+		env = append(env, "HANCOCK_META_"+envName.ReplaceAllString(strings.ToUpper(key), "_")+"="+value)
+	}
+	return env
+}
+
+// write replaces a deploy file atomically, creating its directory if needed
+func write(file config.DeployFile, m *material) error {
+	if file.Path == "" {
+		return fmt.Errorf("no path given for %s", file.Contents)
+	}
+	data, secret, err := m.contents(file.Contents)
+	if err != nil {
+		return err
+	}
+	out := keys.KeyOutput{Owner: file.Owner, Group: file.Group, Mode: 0644}
+	if secret {
+		out.Mode = 0600
+	}
+	if file.Mode != "" {
+		mode, err := strconv.ParseUint(file.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid mode %s", file.Mode)
+		}
+		out.Mode = os.FileMode(mode)
+	}
+	if err = os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+		return err
+	}
+	return keys.WriteKeyFile(file.Path, data, out)
+}
+
+func runHook(hook config.Hook, env []string) error {
+	switch {
+	case hook.Command != "" && hook.Pidfile != "":
+		return fmt.Errorf("set either a command or a pidfile, not both")
+	case hook.Pidfile != "":
+		return signalPidfile(hook.Pidfile, hook.Signal)
+	case hook.Command == "":
+		return fmt.Errorf("no command or pidfile given")
+	}
+
+	timeout := defaultHookTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, hook.Command)
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s: timed out after %s", hook.Command, timeout)
+	}
+	if err != nil {
+		output = bytes.TrimSpace(output)
+		if len(output) > 0 {
+			return fmt.Errorf("%s: %v: %s", hook.Command, err, output)
+		}
+		return fmt.Errorf("%s: %v", hook.Command, err)
+	}
+	return nil
+}
+
+// signalPidfile sends a signal to the process whose id is in pidfile
+func signalPidfile(pidfile, signal string) error {
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in %s", pidfile)
+	}
+	if signal == "" {
+		signal = "HUP"
+	}
+	return sendSignal(pid, strings.TrimPrefix(strings.ToUpper(signal), "SIG"))
+}
+
+func concat(blocks ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		buf.Write(block)
+		if len(block) > 0 && block[len(block)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
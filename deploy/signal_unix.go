@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+func sendSignal(pid int, name string) error {
+	signal, ok := signals[name]
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", name)
+	}
+	return syscall.Kill(pid, signal)
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,15 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+func sendSignal(pid int, name string) error {
+	return errors.New("signalling a pidfile is not supported on windows")
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/deploy"
+	"github.com/urfave/cli/v2"
+)
+
+var deployCommand = &cli.Command{
+	Name:  "deploy",
+	Usage: "copy certificates to their deploy targets from config.json and run their hooks",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "name",
+			Aliases: []string{"n"},
+			Usage:   "deploy only this certificate instead of every configured one",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return DeployCerts(c.String("name"), c.String("basedir"))
+	},
+}
+
+// deployError is returned when a certificate was issued but copying it to
+// its deploy target or running a hook failed
+type deployError struct {
+	name   string
+	errors []error
+}
+
+func (e *deployError) Error() string {
+	messages := make([]string, len(e.errors))
+	for i, err := range e.errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("deploy failed for %s: %s", e.name, strings.Join(messages, "; "))
+}
+
+// DeployCerts runs the deploy target for name, or for every certificate
+// with one if name is empty
+func DeployCerts(name, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if name != "" {
+		if _, ok := conf.Deploy[name]; !ok {
+			return fmt.Errorf("no deploy target for %s in config", name)
+		}
+		names = []string{name}
+	} else {
+		for name := range conf.Deploy {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	failed := 0
+	for _, name := range names {
+		if err := deployCert(name, baseDir); err != nil {
+			fmt.Println(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("deploy failed for %d of %d certificates", failed, len(names))
+	}
+	return nil
+}
+
+// deployCert runs the deploy target configured for name, if there is one
+func deployCert(name, baseDir string) (err error) {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	target, ok := conf.Deploy[name]
+	if !ok {
+		return nil
+	}
+
+	details := map[string]string{"name": name}
+	defer func() {
+		err = audited(baseDir, "deploy", details, err)
+	}()
+	result, err := deploy.Run(name, target, baseDir)
+	if err != nil {
+		return &deployError{name: name, errors: []error{err}}
+	}
+	details["files"] = strings.Join(result.Files, " ")
+	details["hooks"] = strconv.Itoa(result.Hooks)
+	for _, file := range result.Files {
+		fmt.Printf("deployed %s to %s\n", name, file)
+	}
+	if len(result.Errors) > 0 {
+		return &deployError{name: name, errors: result.Errors}
+	}
+	return nil
+}
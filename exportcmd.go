@@ -0,0 +1,441 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/k8s"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/keystore"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/trust"
+	"github.com/urfave/cli/v2"
+)
+
+// formats export can write
+const (
+	exportJKS              = "jks"
+	exportJKSTruststore    = "jks-truststore"
+	exportPKCS12           = "pkcs12"
+	exportPKCS12Truststore = "pkcs12-truststore"
+	exportK8sSecret        = "k8s-secret"
+	exportCertManagerCA    = "cert-manager-ca"
+)
+
+// metadata is labelled on kubernetes objects under this prefix
+const k8sLabelPrefix = "hancock/"
+
+var exportCommand = &cli.Command{
+	Name:      "export",
+	Usage:     "write a certificate and key to a java keystore or kubernetes secret, or the root ca to a truststore or cert-manager issuer",
+	ArgsUsage: "[name]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "jks, jks-truststore, pkcs12, pkcs12-truststore, k8s-secret or cert-manager-ca",
+			Value: exportPKCS12,
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "file to write, defaulting to one named for the format next to the certificate, or in certificates for the root ca; - writes kubernetes manifests to stdout",
+		},
+		&cli.StringFlag{
+			Name:  "alias",
+			Usage: "alias for the key entry, defaulting to the name",
+		},
+		&cli.StringFlag{
+			Name:  "store-password",
+			Usage: "password of the keystore, prompted for if a key goes in it, or " + keystore.DefaultPassword + " for truststores",
+		},
+		&cli.StringFlag{
+			Name:  "entry-password",
+			Usage: "password protecting the key entry, defaulting to the store password",
+		},
+		&cli.StringFlag{
+			Name:  "key-password",
+			Usage: "password for an encrypted key",
+		},
+		&cli.StringFlag{
+			Name:  "namespace",
+			Usage: "namespace for kubernetes objects",
+		},
+		&cli.StringFlag{
+			Name:  "secret-name",
+			Usage: "name of the kubernetes secret, defaulting to the name, or hancock-ca for cert-manager-ca",
+		},
+		&cli.StringFlag{
+			Name:  "issuer-name",
+			Usage: "name of the cert-manager issuer",
+			Value: "hancock",
+		},
+		&cli.BoolFlag{
+			Name:  "cluster-issuer",
+			Usage: "write a cert-manager ClusterIssuer instead of a namespaced Issuer",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+			Usage:   "root key password, for cert-manager-ca",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		opts := ExportOptions{
+			Out:           c.String("out"),
+			Alias:         c.String("alias"),
+			StorePassword: c.String("store-password"),
+			EntryPassword: c.String("entry-password"),
+			KeyPassword:   c.String("key-password"),
+			Namespace:     c.String("namespace"),
+			SecretName:    c.String("secret-name"),
+			IssuerName:    c.String("issuer-name"),
+			ClusterIssuer: c.Bool("cluster-issuer"),
+			Password:      c.String("password"),
+		}
+		format := c.String("format")
+		if opts.StorePassword == "" {
+			switch format {
+			case exportJKSTruststore, exportPKCS12Truststore:
+				opts.StorePassword = keystore.DefaultPassword
+			case exportJKS, exportPKCS12:
+				password, err := readNewPassword("", false)
+				if err != nil {
+					return err
+				}
+				opts.StorePassword = password
+			}
+		}
+		if opts.EntryPassword == "" {
+			opts.EntryPassword = opts.StorePassword
+		}
+		return Export(c.Args().First(), format, opts, c.String("basedir"))
+	},
+}
+
+// ExportOptions say where and how export writes a keystore or manifest
+type ExportOptions struct {
+	Out string
+	// Alias, StorePassword and EntryPassword are for java keystores
+	Alias         string
+	StorePassword string
+	EntryPassword string
+	// KeyPassword decrypts the key saved for the name
+	KeyPassword string
+	// Namespace, SecretName, IssuerName and ClusterIssuer are for kubernetes
+	Namespace     string
+	SecretName    string
+	IssuerName    string
+	ClusterIssuer bool
+	// Password unlocks the root key for a cert-manager issuer
+	Password string
+}
+
+// javaStore is what export needs of jks and pkcs#12 keystores
+type javaStore interface {
+	SetTrustedCert(alias string, cert *x509.Certificate)
+	SetPrivateKey(alias string, key crypto.Signer, chain []*x509.Certificate, password string) error
+}
+
+// Export writes the certificate, key and chain for name to a keystore or a
+// kubernetes secret, or the ca to a truststore or a cert-manager issuer.
+// Entries already in a keystore under other aliases are kept.
+func Export(name, format string, opts ExportOptions, baseDir string) (err error) {
+	details := map[string]string{"name": name, "format": format}
+	defer func() {
+		details["out"] = opts.Out
+		err = audited(baseDir, "export", details, err)
+	}()
+
+	switch format {
+	case exportJKS, exportPKCS12, exportJKSTruststore, exportPKCS12Truststore:
+		return exportJavaStore(name, format, &opts, details, baseDir)
+	case exportK8sSecret:
+		return exportK8sSecretManifest(name, &opts, details, baseDir)
+	case exportCertManagerCA:
+		return exportCertManagerIssuer(&opts, details, baseDir)
+	default:
+		return fmt.Errorf("unknown export format %s, use jks, jks-truststore, pkcs12, pkcs12-truststore, k8s-secret or cert-manager-ca", format)
+	}
+}
+
+func exportJavaStore(name, format string, opts *ExportOptions, details map[string]string, baseDir string) (err error) {
+	truststore := format == exportJKSTruststore || format == exportPKCS12Truststore
+	ext := ".p12"
+	if format == exportJKS || format == exportJKSTruststore {
+		ext = ".jks"
+	}
+	if !truststore && name == "" {
+		return errors.New("name is required to export a keystore")
+	}
+	// the well known default password is only good enough for certificates
+	if !truststore && (opts.StorePassword == "" || opts.EntryPassword == "") {
+		return errors.New("a keystore holding a private key needs a password, set --store-password")
+	}
+	if opts.Out == "" {
+		if truststore {
+			opts.Out = paths.GetTruststorePath(ext, baseDir)
+		} else if opts.Out, err = paths.GetExportPath(name, ext, baseDir); err != nil {
+			return err
+		}
+	}
+
+	store, err := readJavaStore(opts.Out, ext, opts.StorePassword)
+	if err != nil {
+		return err
+	}
+
+	if truststore {
+		roots, err := trustedRoots(false, baseDir)
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			store.SetTrustedCert(trust.Alias(root), root)
+			fmt.Printf("added %s as %s\n", root.Subject, trust.Alias(root))
+		}
+	} else {
+		alias := opts.Alias
+		if alias == "" {
+			alias = name
+		}
+		details["alias"] = alias
+		cert, key, chain, err := exportCertAndKey(name, opts.KeyPassword, baseDir)
+		if err != nil {
+			return err
+		}
+		if err = store.SetPrivateKey(alias, key, chain, opts.EntryPassword); err != nil {
+			return err
+		}
+		fmt.Printf("added %s as %s with a chain of %d certificates\n", cert.Subject, alias, len(chain))
+	}
+
+	var data []byte
+	switch s := store.(type) {
+	case *keystore.KeyStore:
+		data, err = s.EncodeJKS(opts.StorePassword)
+	case *keystore.PKCS12:
+		data, err = s.Encode(opts.StorePassword)
+	}
+	if err != nil {
+		return err
+	}
+	// truststores hold nothing secret, keystores are as private as the key
+	if truststore {
+		err = ioutil.WriteFile(opts.Out, data, 0644)
+	} else {
+		err = keys.WriteKeyFile(opts.Out, data, keys.KeyOutput{})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", opts.Out)
+	return nil
+}
+
+// exportK8sSecretManifest writes a kubernetes.io/tls secret with the
+// certificate and any intermediates in tls.crt and the root in ca.crt,
+// labelled with the certificate's metadata
+func exportK8sSecretManifest(name string, opts *ExportOptions, details map[string]string, baseDir string) (err error) {
+	if name == "" {
+		return errors.New("name is required to export a secret")
+	}
+	if opts.Out == "" {
+		if opts.Out, err = paths.GetExportPath(name, "-secret.yaml", baseDir); err != nil {
+			return err
+		}
+	}
+	secretName := opts.SecretName
+	if secretName == "" {
+		secretName = k8s.ObjectName(name)
+	}
+	details["secret"] = secretName
+
+	cert, key, chain, err := exportCertAndKey(name, opts.KeyPassword, baseDir)
+	if err != nil {
+		return err
+	}
+	keyBlock, err := keys.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	entry, err := currentEntry(name, baseDir)
+	if err != nil {
+		return err
+	}
+	labels, annotations := k8s.Labels(k8sLabelPrefix, entry.Metadata)
+	labels["app.kubernetes.io/managed-by"] = "hancock"
+	annotations[k8sLabelPrefix+"name"] = name
+	annotations[k8sLabelPrefix+"serial"] = inventory.Serial(cert)
+	annotations[k8sLabelPrefix+"not-after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+
+	secret := k8s.TLSSecret(secretName, opts.Namespace, labels, annotations,
+		encodeCerts(chain[:len(chain)-1]), pem.EncodeToMemory(keyBlock), encodeCerts(chain[len(chain)-1:]))
+	data, err := k8s.EncodeYAML(secret)
+	if err != nil {
+		return err
+	}
+	return writeManifest(opts.Out, data)
+}
+
+// exportCertManagerIssuer writes the root ca and its key as a secret, with a
+// cert-manager CA issuer that signs with it
+func exportCertManagerIssuer(opts *ExportOptions, details map[string]string, baseDir string) (err error) {
+	if opts.Out == "" {
+		opts.Out = paths.GetCAIssuerPath(baseDir)
+	}
+	secretName := opts.SecretName
+	if secretName == "" {
+		secretName = "hancock-ca"
+	}
+	details["secret"] = secretName
+	details["issuer"] = opts.IssuerName
+
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	rootKey, err := unlockRootKey(opts.Password, baseDir)
+	if err != nil {
+		return err
+	}
+	keyBlock, err := keys.MarshalPrivateKey(rootKey)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{"app.kubernetes.io/managed-by": "hancock"}
+	annotations := map[string]string{
+		k8sLabelPrefix + "root-id":   certs.RootID(rootCACert),
+		k8sLabelPrefix + "not-after": rootCACert.NotAfter.UTC().Format(time.RFC3339),
+	}
+	certPEM := encodeCerts([]*x509.Certificate{rootCACert})
+	secret := k8s.TLSSecret(secretName, opts.Namespace, labels, annotations, certPEM, pem.EncodeToMemory(keyBlock), certPEM)
+	issuer := k8s.CAIssuer(opts.IssuerName, opts.Namespace, secretName, opts.ClusterIssuer, labels)
+	data, err := k8s.EncodeYAML(secret, issuer)
+	if err != nil {
+		return err
+	}
+	if err = writeManifest(opts.Out, data); err != nil {
+		return err
+	}
+	if opts.ClusterIssuer {
+		fmt.Fprintln(os.Stderr, "a ClusterIssuer reads its secret from the cert-manager namespace, apply it there")
+	}
+	fmt.Fprintln(os.Stderr, "the manifest holds the unencrypted root key, delete it once applied")
+	return nil
+}
+
+// exportCertAndKey loads the certificate and key for name and builds the
+// certificate's chain
+func exportCertAndKey(name, keyPassword, baseDir string) (*x509.Certificate, crypto.Signer, []*x509.Certificate, error) {
+	cert, err := certs.GetCert(name, baseDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := keys.GetKey(name, keyPassword, baseDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !certs.KeyMatchesCert(key, cert) {
+		return nil, nil, nil, fmt.Errorf("the key for %s does not match its certificate", name)
+	}
+	chain, err := exportChain(cert, baseDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, key, chain, nil
+}
+
+func encodeCerts(chain []*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+// writeManifest writes a manifest holding a private key to path, or stdout
+// for -
+func writeManifest(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := keys.WriteKeyFile(path, data, keys.KeyOutput{}); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
+
+// readJavaStore opens the keystore at path to be updated, or starts a new
+// one if there is none
+func readJavaStore(path, ext, password string) (javaStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if ext == ".jks" {
+			return &keystore.KeyStore{}, nil
+		}
+		return &keystore.PKCS12{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var store javaStore
+	if ext == ".jks" {
+		if !keystore.IsJKS(data) {
+			return nil, fmt.Errorf("%s is not a jks keystore", path)
+		}
+		store, err = keystore.DecodeJKS(data, password)
+	} else {
+		store, err = keystore.DecodePKCS12(data, password)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return store, nil
+}
+
+// exportChain builds the chain for cert, leaf first and ending at one of the
+// ca's roots
+func exportChain(cert *x509.Certificate, baseDir string) ([]*x509.Certificate, error) {
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	crossCerts, err := certs.GetCrossCerts(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	for _, root := range append([]*x509.Certificate{rootCACert}, retired...) {
+		roots.AddCert(root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range crossCerts {
+		intermediates.AddCert(intermediate)
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build a chain for %s: %v", cert.Subject, err)
+	}
+	return chains[0], nil
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/galenguyer/hancock/audit"
 	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/ct"
+	"github.com/galenguyer/hancock/inventory"
 	"github.com/galenguyer/hancock/keys"
 	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/requests"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/term"
 )
@@ -24,7 +32,7 @@ func main() {
 			{
 				Name:  "init",
 				Usage: "initialize the certificate authority",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.IntFlag{
 						Name:    "lifetime",
 						Aliases: []string{"t"},
@@ -74,13 +82,20 @@ func main() {
 						Name:  "no-password",
 						Value: false,
 					},
+					&cli.BoolFlag{
+						Name:  "renew-root",
+						Usage: "re-issue the existing root ca certificate with a new validity period",
+					},
 					&cli.StringFlag{
 						Name:  "basedir",
 						Value: "~/.ca",
 					},
-				},
+				}, splitFlags...),
 				Action: func(c *cli.Context) error {
-					return InitCA(
+					if c.Bool("renew-root") {
+						return RenewRootCA(c.Int("lifetime"), c.String("password"), c.String("basedir"))
+					}
+					err := InitCA(
 						c.Int("bits"),
 						c.Int("lifetime"),
 						c.String("commonname"),
@@ -93,13 +108,17 @@ func main() {
 						c.Bool("no-password"),
 						c.String("basedir"),
 					)
+					if err != nil || c.String("split") == "" {
+						return err
+					}
+					return SplitRootKey(c.String("split"), c.String("share-dir"), c.StringSlice("recipient"), c.Bool("remove-key"), c.String("password"), c.String("basedir"))
 				},
 			},
 			{
 				Name:    "new",
 				Aliases: []string{"create", "issue"},
 				Usage:   "sign a new key for a host",
-				Flags: []cli.Flag{
+				Flags: append(append([]cli.Flag{
 					&cli.IntFlag{
 						Name:    "lifetime",
 						Aliases: []string{"t"},
@@ -115,9 +134,69 @@ func main() {
 						Aliases: []string{"n"},
 						Value:   "localhost",
 					},
+					&cli.StringSliceFlag{
+						Name:    "country",
+						Aliases: []string{"c"},
+					},
+					&cli.StringSliceFlag{
+						Name:    "state",
+						Aliases: []string{"st"},
+					},
+					&cli.StringSliceFlag{
+						Name:    "locality",
+						Aliases: []string{"l"},
+					},
+					&cli.StringSliceFlag{
+						Name: "street",
+					},
+					&cli.StringSliceFlag{
+						Name: "postalcode",
+					},
+					&cli.StringSliceFlag{
+						Name:    "organization",
+						Aliases: []string{"o"},
+					},
+					&cli.StringSliceFlag{
+						Name:    "organizationalunit",
+						Aliases: []string{"ou"},
+					},
+					&cli.StringFlag{
+						Name: "serialnumber",
+					},
+					&cli.StringSliceFlag{
+						Name: "email",
+					},
+					&cli.BoolFlag{
+						Name:  "inherit-subject",
+						Usage: "copy country, state, locality, organization and organizational unit from the root ca",
+					},
 					&cli.StringFlag{
+						Name:  "profile",
+						Usage: "issuance profile from config.json in the base directory",
+					},
+					&cli.StringSliceFlag{
 						Name:  "san",
-						Value: "",
+						Usage: "subject alternative name, optionally typed as DNS:, IP:, email:, URI: or otherName:",
+					},
+					&cli.StringFlag{
+						Name:  "spiffe-id",
+						Usage: "issue an x.509 svid for a spiffe id such as spiffe://example.org/service, saved as --name if it is given",
+					},
+					&cli.StringFlag{
+						Name:  "key-type",
+						Usage: "type of key to generate: rsa, ecdsa or ed25519",
+					},
+					&cli.StringFlag{
+						Name:  "curve",
+						Usage: "curve for ecdsa keys: P-256, P-384 or P-521",
+					},
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "issue for an existing private key instead of generating one",
+					},
+					&cli.BoolFlag{
+						Name:  "reuse-key",
+						Usage: "issue for the key already saved for this name",
 					},
 					&cli.StringFlag{
 						Name:    "password",
@@ -128,13 +207,58 @@ func main() {
 						Name:  "basedir",
 						Value: "~/.ca",
 					},
-				},
+				}, keyOutputFlags...), metadataFlags...),
 				Action: func(c *cli.Context) error {
+					subject, err := ResolveSubject(
+						c.String("name"),
+						c.String("profile"),
+						c.Bool("inherit-subject"),
+						certs.Subject{
+							Country:            c.StringSlice("country"),
+							Province:           c.StringSlice("state"),
+							Locality:           c.StringSlice("locality"),
+							StreetAddress:      c.StringSlice("street"),
+							PostalCode:         c.StringSlice("postalcode"),
+							Organization:       c.StringSlice("organization"),
+							OrganizationalUnit: c.StringSlice("organizationalunit"),
+							SerialNumber:       c.String("serialnumber"),
+							Email:              c.StringSlice("email"),
+						},
+						c.String("basedir"),
+					)
+					if err != nil {
+						return err
+					}
+					name, sans := subject.CommonName, c.StringSlice("san")
+					if c.String("spiffe-id") != "" {
+						var spiffeName string
+						spiffeName, subject, sans, err = SPIFFESubject(c.String("spiffe-id"), subject, sans, c.String("basedir"))
+						if err != nil {
+							return err
+						}
+						if !c.IsSet("name") {
+							name = spiffeName
+						}
+						if err = checkSPIFFEName(name, sans[0], c.String("basedir")); err != nil {
+							return err
+						}
+					}
+					leafKey, err := leafKeyFromFlags(c)
+					if err != nil {
+						return err
+					}
+					metadata, err := metadataFromFlags(c)
+					if err != nil {
+						return err
+					}
 					return NewCert(
-						c.Int("bits"),
+						name,
+						c.String("profile"),
+						metadata,
+						leafKey,
 						c.Int("lifetime"),
-						c.String("name"),
-						c.String("san"),
+						subject,
+						sans,
 						c.String("password"),
 						c.String("basedir"),
 					)
@@ -142,12 +266,28 @@ func main() {
 			}, {
 				Name:  "renew",
 				Usage: "renew expiring keys",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "name",
 						Aliases: []string{"n"},
 						Value:   "localhost",
 					},
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "renew the certificate given by --name for an existing private key",
+					},
+					&cli.BoolFlag{
+						Name:  "reuse-key",
+						Usage: "keep the existing keys instead of generating new ones",
+					},
+					&cli.BoolFlag{
+						Name:  "daemon",
+						Usage: "keep running, renewing each certificate at a jittered point in its renewal window",
+					},
+					&cli.StringFlag{
+						Name:  "status",
+						Usage: "address or unix socket path to serve the daemon status on",
+					},
 					&cli.StringFlag{
 						Name:    "password",
 						Aliases: []string{"p"},
@@ -157,15 +297,52 @@ func main() {
 						Name:  "basedir",
 						Value: "~/.ca",
 					},
-				},
+				}, keyOutputFlags...),
 				Action: func(c *cli.Context) error {
+					if c.String("key") != "" && !c.IsSet("name") {
+						return errors.New("--key needs --name to choose the certificate it belongs to")
+					}
+					leafKey, err := leafKeyFromFlags(c)
+					if err != nil {
+						return err
+					}
+					if c.Bool("daemon") {
+						if c.String("key") != "" {
+							return errors.New("--key cannot be used with --daemon")
+						}
+						return RunRenewalDaemon(leafKey, c.String("status"), c.String("password"), c.String("basedir"))
+					}
 					return RenewCerts(
 						c.String("name"),
+						leafKey,
 						c.String("password"),
 						c.String("basedir"),
 					)
 				},
 			},
+			ctCommand,
+			auditCommand,
+			requestsCommand,
+			rolloverCommand,
+			keyCommand,
+			importCommand,
+			backupCommand,
+			restoreCommand,
+			serveCommand,
+			publishCommand,
+			trustCommand,
+			exportCommand,
+			k8sCommand,
+			spiffeCommand,
+			deployCommand,
+			notifyCommand,
+			tagCommand,
+			listCommand,
+			showCommand,
+			verifyCommand,
+			revokeCommand,
+			crlCommand,
+			lintCommand,
 		},
 	}
 
@@ -186,7 +363,17 @@ func InitCA(bits, lifetime int, commonname, country, state, locality, organizati
 	// if root rsa key does not exist
 	if _, err = os.Stat(paths.GetRootRsaKeyPath(baseDir)); os.IsNotExist(err) {
 		// generate new root rsa key
-		if err = newRootRsaKey(bits, password, noPassword, baseDir); err != nil {
+		err = newRootRsaKey(bits, password, noPassword, baseDir)
+		details := map[string]string{"bits": strconv.Itoa(bits)}
+		// an empty password at the prompt also leaves the key unencrypted
+		if err == nil {
+			encrypted, encErr := keys.GetRootKeyIsEncrypted(baseDir)
+			if encErr == nil {
+				details["encrypted"] = strconv.FormatBool(encrypted)
+			}
+		}
+		err = audited(baseDir, "init.key", details, err)
+		if err != nil {
 			return err
 		}
 	} else {
@@ -196,13 +383,84 @@ func InitCA(bits, lifetime int, commonname, country, state, locality, organizati
 	// if the root ca certificate does not exist
 	if _, err = os.Stat(paths.GetCACertPath(baseDir)); os.IsNotExist(err) {
 		// generate new root ca certificate
-		return newRootCACert(lifetime, commonname, country, state, locality, organization, organizationalUnit, password, noPassword, baseDir)
+		err = newRootCACert(lifetime, commonname, country, state, locality, organization, organizationalUnit, password, noPassword, baseDir)
+		return audited(baseDir, "init.cert", map[string]string{
+			"common_name": commonname,
+			"lifetime":    strconv.Itoa(lifetime),
+		}, err)
 	} else {
 		fmt.Println("not overwriting root ca certificate")
 	}
 	return nil
 }
 
+// RenewRootCA re-issues the root ca certificate with its existing key,
+// subject and key identifier, warning about leaves that would outlive it
+func RenewRootCA(lifetime int, password, baseDir string) (err error) {
+	details := map[string]string{"lifetime": strconv.Itoa(lifetime)}
+	defer func() {
+		err = audited(baseDir, "root.renew", details, err)
+	}()
+
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	details["old_serial"] = rootCACert.SerialNumber.Text(16)
+	rootKey, err := unlockRootKey(password, baseDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("re-issuing root ca certificate")
+	certBytes, err := certs.RenewRootCACert(rootKey, rootCACert, lifetime)
+	if err != nil {
+		return err
+	}
+	newCert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return err
+	}
+	if err = certs.SaveRootCACert(certBytes, baseDir); err != nil {
+		return err
+	}
+	details["serial"] = newCert.SerialNumber.Text(16)
+	details["not_after"] = newCert.NotAfter.UTC().Format(time.RFC3339)
+	fmt.Printf("root ca certificate now expires %s\n", newCert.NotAfter.Local().Format("2006-01-02"))
+
+	// the new root is saved, so nothing after this fails the renewal
+
+	// keep the trust bundle from a previous rollover current
+	if _, statErr := os.Stat(paths.GetCABundlePath(baseDir)); statErr == nil {
+		if bundleErr := certs.SaveCABundle(baseDir); bundleErr != nil {
+			fmt.Printf("warning: could not update the trust bundle: %v\n", bundleErr)
+		}
+	}
+
+	children, readErr := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
+	if readErr != nil {
+		fmt.Printf("warning: could not check certificates against the new root: %v\n", readErr)
+		return nil
+	}
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+		cert, certErr := certs.GetCert(child.Name(), baseDir)
+		// skip names whose issuance failed or is waiting for approval
+		if os.IsNotExist(certErr) {
+			continue
+		} else if certErr != nil {
+			fmt.Printf("warning: %s: %v\n", child.Name(), certErr)
+			continue
+		}
+		if cert.NotAfter.After(newCert.NotAfter) {
+			fmt.Printf("warning: %s expires %s, after the root ca certificate\n", child.Name(), cert.NotAfter.Local().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
 func newRootRsaKey(bits int, password string, noPassword bool, baseDir string) error {
 	fmt.Println("generating new root rsa key")
 	// generate the root rsa key
@@ -211,30 +469,38 @@ func newRootRsaKey(bits int, password string, noPassword bool, baseDir string) e
 		return err
 	}
 
-	var bytePassword, byteConfirmPassword []byte
-	if !noPassword && password == "" {
-		fmt.Print("enter password: ")
-		bytePassword, err = term.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			return err
-		}
-		fmt.Print("\n")
-		fmt.Print("confirm password: ")
-		byteConfirmPassword, err = term.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			return err
-		}
-		fmt.Print("\n")
-
-		if string(bytePassword) != string(byteConfirmPassword) {
-			return errors.New("passwords do not match")
-		}
-	} else if password != "" {
-		bytePassword = []byte(password)
+	password, err = readNewPassword(password, noPassword)
+	if err != nil {
+		return err
 	}
 
 	// save root rsa key to disk
-	return keys.SaveRootRsaKey(*key, string(bytePassword), baseDir)
+	return keys.SaveRootKey(key, password, baseDir)
+}
+
+// readNewPassword prompts for a password to encrypt a new key with, unless
+// one was given or encryption is disabled
+func readNewPassword(password string, noPassword bool) (string, error) {
+	if noPassword || password != "" {
+		return password, nil
+	}
+	fmt.Print("enter password: ")
+	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("\n")
+	fmt.Print("confirm password: ")
+	byteConfirmPassword, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("\n")
+
+	if string(bytePassword) != string(byteConfirmPassword) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(bytePassword), nil
 }
 
 func newRootCACert(lifetime int, commonname, country, province, locality, organization, organizationalUnit, password string, noPassword bool, baseDir string) error {
@@ -254,12 +520,16 @@ func newRootCACert(lifetime int, commonname, country, province, locality, organi
 	}
 
 	// load the root rsa key from disk
-	key, err := keys.GetRootRsaKey(string(bytePassword), baseDir)
+	key, err := getRootKey(string(bytePassword), baseDir)
+	if err != nil {
+		return err
+	}
+	conf, err := config.Load(baseDir)
 	if err != nil {
 		return err
 	}
 	// generate a root certificate using the key and configuration
-	caCertBytes, err := certs.GenerateRootCACert(*key, lifetime, commonname, country, province, locality, organization, organizationalUnit)
+	caCertBytes, err := certs.GenerateRootCACert(key, lifetime, commonname, country, province, locality, organization, organizationalUnit, conf.Extensions)
 	if err != nil {
 		return err
 	}
@@ -267,59 +537,251 @@ func newRootCACert(lifetime int, commonname, country, province, locality, organi
 	return certs.SaveRootCACert(caCertBytes, baseDir)
 }
 
-func NewCert(bits, lifetime int, name, san, password, baseDir string) error {
-	// generate and write a new rsa key
-	key, err := keys.GenerateRsaKey(bits)
+// ResolveSubject builds a leaf certificate subject, taking fields from the
+// root ca if requested, then the profile, then the given fields, and finally
+// expanding any templates
+func ResolveSubject(name, profileName string, inheritSubject bool, fields certs.Subject, baseDir string) (certs.Subject, error) {
+	conf, err := config.Load(baseDir)
 	if err != nil {
-		return err
+		return certs.Subject{}, err
 	}
-	err = keys.SaveRsaKey(*key, name, baseDir)
+	profile, err := conf.GetProfile(profileName)
 	if err != nil {
-		return err
+		return certs.Subject{}, err
 	}
 
-	// generate and write a new csr
-	csr, err := certs.GenerateCsr(name, san, baseDir, *key)
+	subject := certs.Subject{}
+	if inheritSubject || profile.InheritSubject {
+		rootCACert, err := certs.GetRootCACert(baseDir)
+		if err != nil {
+			return certs.Subject{}, err
+		}
+		issuer := certs.SubjectFromName(rootCACert.Subject)
+		subject = certs.Subject{
+			Country:            issuer.Country,
+			Province:           issuer.Province,
+			Locality:           issuer.Locality,
+			Organization:       issuer.Organization,
+			OrganizationalUnit: issuer.OrganizationalUnit,
+		}
+	}
+	subject = subject.Merge(profile.Subject).Merge(fields)
+	subject.CommonName = name
+	return subject.Expand(name)
+}
+
+func NewCert(name, profile string, metadata map[string]string, leafKey LeafKey, lifetime int, subject certs.Subject, sans []string, password, baseDir string) (err error) {
+	details := map[string]string{
+		"name":     name,
+		"profile":  profile,
+		"subject":  subject.Name().String(),
+		"sans":     strings.Join(sans, " "),
+		"lifetime": strconv.Itoa(lifetime),
+	}
+	operation := "issue"
+	issued := false
+	// deploy once the issuance is audited, so that a failed hook does not
+	// record the certificate as not issued
+	defer func() {
+		if err == nil && issued {
+			err = deployCert(name, baseDir)
+		}
+	}()
+	defer func() {
+		err = audited(baseDir, operation, details, err)
+	}()
+
+	conf, err := config.Load(baseDir)
 	if err != nil {
 		return err
 	}
-	err = certs.SaveCsr(name, csr, baseDir)
+	if conf.Approval.Enabled {
+		// a second request would leave the first signed for a key that has
+		// since been replaced
+		pending, err := requests.Pending(name, baseDir)
+		if err != nil {
+			return err
+		} else if pending != nil {
+			return fmt.Errorf("request %s for %s is already waiting for approval", pending.ID, name)
+		}
+	}
+
+	// the new key is kept aside until its certificate is saved, so that the
+	// saved key and certificate always match
+	queued := false
+	defer func() {
+		if !queued && !issued {
+			discardKey(name, baseDir)
+		}
+	}()
+	key, source, err := leafKey.load(name, baseDir)
 	if err != nil {
 		return err
 	}
+	details["key"] = keys.Describe(key.Public())
+	details["key_source"] = source
 
-	// sign and save the certificate
-	isEncrypted, err := keys.GetRootRsaKeyIsEncrypted(baseDir)
+	// generate and write a new csr
+	csr, err := certs.GenerateCsr(subject, sans, key)
 	if err != nil {
 		return err
 	}
-	if isEncrypted && password == "" {
-		var bytePassword []byte
-		fmt.Print("enter password: ")
-		bytePassword, err = term.ReadPassword(int(syscall.Stdin))
+	err = certs.SavePendingCsr(name, csr, baseDir)
+	if err != nil {
+		return err
+	}
+
+	// queue the csr instead of signing it if issuance needs approval
+	if conf.Approval.Enabled {
+		operation = "request.submit"
+		requester, err := audit.SystemUser()
 		if err != nil {
 			return err
 		}
-		fmt.Print("\n")
-		password = string(bytePassword)
+		req, err := requests.Submit(csr, name, profile, metadata, lifetime, requests.SourceCLI, requester, baseDir)
+		if err != nil {
+			return err
+		}
+		details["request"] = req.ID
+		fmt.Printf("submitted request %s for %s, waiting for approval\n", req.ID, name)
+		queued = true
+		return nil
 	}
-	rootKey, err := keys.GetRootRsaKey(password, baseDir)
+
+	// sign and save the certificate
+	rootKey, err := unlockRootKey(password, baseDir)
 	if err != nil {
 		return err
 	}
-	cert, err := certs.GenerateCert(csr, lifetime, *rootKey, baseDir)
+	signStart := time.Now()
+	cert, err := signCert(csr, profile, certs.Extensions{}, lifetime, rootKey, baseDir)
 	if err != nil {
 		return err
 	}
-	err = certs.SaveCert(cert, name, baseDir)
+	details["sign_seconds"] = strconv.FormatFloat(time.Since(signStart).Seconds(), 'f', 6, 64)
+	if parsed, err := x509.ParseCertificate(cert); err == nil {
+		details["serial"] = parsed.SerialNumber.Text(16)
+		details["not_after"] = parsed.NotAfter.UTC().Format(time.RFC3339)
+	}
+	err = saveCert(cert, name, inventory.SourceHancock, profile, metadata, baseDir)
 	if err != nil {
 		return err
 	}
+	issued = true
+	if err = promoteKey(name, baseDir); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func RenewCerts(name, password, baseDir string) error {
+// saveCert writes a newly signed certificate as the current one for name
+// and records it in the inventory, keeping the metadata of the certificate
+// it replaces with any given metadata on top
+func saveCert(certBytes []byte, name, source, profile string, metadata map[string]string, baseDir string) error {
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return err
+	}
+	entry := inventory.NewEntry(cert, name, source)
+	entry.Profile = profile
+	entry.Metadata = map[string]string{}
+	if previous, err := currentEntry(name, baseDir); err == nil {
+		for key, value := range previous.Metadata {
+			entry.Metadata[key] = value
+		}
+	}
+	for key, value := range metadata {
+		entry.Metadata[key] = value
+	}
+
+	if err = certs.SaveCert(certBytes, name, baseDir); err != nil {
+		return err
+	}
+	return inventory.Add([]inventory.Entry{entry}, baseDir)
+}
+
+// signCert signs a csr with the root key and the extensions of the ca and
+// profile, with any set in override on top, linting it first and recording
+// the certificate in the ct log when those are enabled
+func signCert(csr []byte, profile string, override certs.Extensions, lifetime int, rootKey crypto.Signer, baseDir string) ([]byte, error) {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := conf.GetExtensions(profile)
+	if err != nil {
+		return nil, err
+	}
+	extensions = extensions.Merge(override)
+	template, err := certs.NewCertTemplate(csr, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	// however the csr came in, the ca only vouches for spiffe ids in its
+	// own trust domain
+	for _, uri := range template.URIs {
+		if certs.IsSPIFFEID(uri) {
+			if _, err = certs.ParseWorkloadSPIFFEID(uri.String(), conf.SPIFFE.TrustDomain); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = extensions.Apply(template); err != nil {
+		return nil, err
+	}
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if template.NotAfter.After(rootCACert.NotAfter) {
+		fmt.Printf("warning: cutting the lifetime short to end with the root ca certificate on %s\n", rootCACert.NotAfter.Local().Format("2006-01-02"))
+		template.NotAfter = rootCACert.NotAfter
+	}
+	// lint what will be signed, after the lifetime is cut short
+	if conf.Lint.Enabled {
+		if err = lintTemplate(template, conf.Lint, baseDir); err != nil {
+			return nil, err
+		}
+	}
+	if !conf.CT.Enabled {
+		return certs.SignCert(template, rootKey, baseDir)
+	}
+
+	log, err := ct.Open(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if conf.CT.EmbedSCTs {
+		err = log.EmbedSCT(template, rootCACert, func(precert *x509.Certificate) ([]byte, error) {
+			return certs.SignCert(precert, rootKey, baseDir)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	certBytes, err := certs.SignCert(template, rootKey, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = log.AddChain([]*x509.Certificate{cert, rootCACert}); err != nil {
+		return nil, err
+	}
+	return certBytes, nil
+}
+
+// RenewCerts renews every certificate close to expiry or due to move to a new
+// root. An existing key file in leafKey only applies to the certificate called
+// name. A certificate that fails to renew does not stop the others.
+func RenewCerts(name string, leafKey LeafKey, password, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
 	// check how close the root ca cert is from expiring
 	rootCACert, err := certs.GetRootCACert(baseDir)
 	if err != nil {
@@ -327,33 +789,243 @@ func RenewCerts(name, password, baseDir string) error {
 	}
 	daysUntilExpiration := time.Until(rootCACert.NotAfter).Hours() / 24
 	fmt.Printf("root ca certificate expires in %d days\n", int(daysUntilExpiration))
+	if daysUntilExpiration < 365 {
+		fmt.Println("run hancock init --renew-root to re-issue it with the same key")
+	}
 
 	// get all directories under basedir/certificates
 	children, err := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
 	if err != nil {
 		return err
 	}
+	var renewed []string
+	var failures []error
 	for _, child := range children {
-		if child.IsDir() {
-			cert, err := certs.GetCert(child.Name(), baseDir)
+		if !child.IsDir() {
+			continue
+		}
+		cert, err := certs.GetCert(child.Name(), baseDir)
+		// skip names whose issuance failed or is waiting for approval
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", child.Name(), err))
+			continue
+		}
+		daysUntilExpiration = (time.Until(cert.NotAfter).Hours()) / 24
+		fmt.Printf("%s expires in %d days\n", child.Name(), int(daysUntilExpiration))
+		// an existing key given for this certificate re-keys it even if it is not due
+		rekey := leafKey.File != "" && child.Name() == name
+		held, err := keyHeld(child.Name(), cert, baseDir)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", child.Name(), err))
+			continue
+		}
+		if !held && !rekey {
+			continue
+		}
+		due, rolloverDue, err := renewalDue(child.Name(), cert, rootCACert, renewBefore(conf), baseDir)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", child.Name(), err))
+			continue
+		}
+		if !due && !rekey {
+			continue
+		}
+		if conf.Approval.Enabled {
+			pending, err := requests.Pending(child.Name(), baseDir)
 			if err != nil {
-				return err
-			}
-			daysUntilExpiration = (time.Until(cert.NotAfter).Hours()) / 24
-			fmt.Printf("%s expires in %d days\n", cert.Subject.CommonName, int(daysUntilExpiration))
-			if daysUntilExpiration < 30 {
-				dnsNames := ""
-				for _, name := range cert.DNSNames {
-					if name != cert.Subject.CommonName {
-						dnsNames += name + " "
-					}
-				}
-				err = NewCert(cert.PublicKey.(*rsa.PublicKey).Size()*8, int(cert.NotAfter.Sub(cert.NotBefore).Hours()+1)/24, cert.Subject.CommonName, dnsNames, password, baseDir)
-				if err != nil {
-					return err
-				}
+				failures = append(failures, fmt.Errorf("%s: %v", child.Name(), err))
+				continue
+			} else if pending != nil {
+				fmt.Printf("%s is waiting for approval of request %s\n", child.Name(), pending.ID)
+				continue
 			}
 		}
+		key := leafKey
+		if child.Name() != name {
+			key.File = ""
+		}
+		err = renewCert(child.Name(), cert, key, rolloverDue, password, baseDir)
+		// the certificate was renewed even if deploying it failed
+		var deployErr *deployError
+		if err == nil || errors.As(err, &deployErr) {
+			renewed = append(renewed, child.Name())
+		}
+		if err != nil && deployErr == nil {
+			err = fmt.Errorf("renewing %s: %v", child.Name(), err)
+		}
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(renewed) == 0 {
+		fmt.Println("no certificates were renewed")
+	} else {
+		fmt.Printf("renewed %d certificates: %s\n", len(renewed), strings.Join(renewed, ", "))
+	}
+	for _, failure := range failures {
+		fmt.Println(failure)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d certificates failed to renew or deploy", len(failures))
+	}
+	return nil
+}
+
+// renewBefore is how long before expiry certificates are renewed
+func renewBefore(conf *config.Config) time.Duration {
+	days := defaultRenewBefore
+	if conf.Renewal.Before > 0 {
+		days = conf.Renewal.Before
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// renewalDue reports whether a certificate is due for renewal, either as it
+// is close to expiry or as it was issued by a root that is being rolled over
+func renewalDue(name string, cert, rootCACert *x509.Certificate, before time.Duration, baseDir string) (due bool, rollover bool, err error) {
+	// leaves issued before a rollover are re-issued across the transition window
+	if cert.CheckSignatureFrom(rootCACert) != nil {
+		rollover, err = isRolloverDue(name, baseDir)
+		if err != nil {
+			return false, false, err
+		}
+	}
+	return rollover || time.Until(cert.NotAfter) < renewBeforeCert(cert, before), rollover, nil
+}
+
+// keyHeld reports whether hancock holds the key of a certificate and can
+// renew it. Certificates signed for kubernetes or from submitted csrs are
+// renewed by whoever holds their key.
+func keyHeld(name string, cert *x509.Certificate, baseDir string) (bool, error) {
+	entry, err := inventory.Get(inventory.Serial(cert), baseDir)
+	if err != nil {
+		return false, err
+	}
+	if entry != nil && (entry.Source == inventory.SourceK8s || entry.Source == inventory.SourceRequest) {
+		return false, nil
+	}
+	keyPath, err := paths.GetRsaKeyPath(name, baseDir)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(keyPath); os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renewBeforeCert shortens how long before expiry a certificate is renewed
+// to two thirds of its lifetime, so short-lived certificates are not renewed
+// over and over
+func renewBeforeCert(cert *x509.Certificate, before time.Duration) time.Duration {
+	if limit := cert.NotAfter.Sub(cert.NotBefore) * 2 / 3; before > limit {
+		return limit
+	}
+	return before
+}
+
+// renewCert re-issues a certificate with the same subject, names and
+// lifetime, keeping its key type. A *deployError is returned if it was
+// renewed but deploying it failed.
+func renewCert(name string, cert *x509.Certificate, leafKey LeafKey, rollover bool, password, baseDir string) error {
+	altNames, err := certs.SANsFromCert(cert)
+	if err != nil {
+		return err
+	}
+	// renewals count towards the profile the certificate was issued with
+	profile := ""
+	entry, err := inventory.Get(inventory.Serial(cert), baseDir)
+	if err != nil {
+		return err
+	} else if entry != nil {
+		profile = entry.Profile
+	}
+	lifetime := int(cert.NotAfter.Sub(cert.NotBefore).Hours()+1) / 24
+	err = NewCert(name, profile, nil, leafKey.like(cert.PublicKey), lifetime, certs.SubjectFromName(cert.Subject), altNames.Strings(), password, baseDir)
+	var deployErr *deployError
+	if errors.As(err, &deployErr) {
+		err = nil
+	}
+	err = audited(baseDir, "renew", map[string]string{
+		"name":           name,
+		"profile":        profile,
+		"old_serial":     cert.SerialNumber.Text(16),
+		"old_not_after":  cert.NotAfter.UTC().Format(time.RFC3339),
+		"days_remaining": strconv.Itoa(int(time.Until(cert.NotAfter).Hours() / 24)),
+		"rollover":       strconv.FormatBool(rollover),
+	}, err)
+	if err != nil {
+		return err
+	}
+	if deployErr != nil {
+		return deployErr
 	}
 	return nil
 }
+
+// sharedRootKey is the root key while key combine runs a command with it
+var sharedRootKey crypto.Signer
+
+// unlockRootKey loads the root key, prompting for its password if it is
+// encrypted and none was given, or returns the key reconstructed from shares
+func unlockRootKey(password, baseDir string) (crypto.Signer, error) {
+	if sharedRootKey != nil {
+		rootCACert, err := certs.GetRootCACert(baseDir)
+		if err != nil {
+			return nil, err
+		}
+		if !certs.KeyMatchesCert(sharedRootKey, rootCACert) {
+			return nil, fmt.Errorf("the key reconstructed from shares is not the root key of %s", baseDir)
+		}
+		return sharedRootKey, nil
+	}
+	password, err := readRootPassword(password, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return getRootKey(password, baseDir)
+}
+
+// readRootPassword prompts for the root key password if the key is
+// encrypted and none was given
+func readRootPassword(password, baseDir string) (string, error) {
+	isEncrypted, err := keys.GetRootKeyIsEncrypted(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if isEncrypted && password == "" {
+		fmt.Print("enter password: ")
+		bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return "", err
+		}
+		fmt.Print("\n")
+		password = string(bytePassword)
+	}
+	return password, nil
+}
+
+// getRootKey loads the root key, recording failures to unlock it in the audit log
+func getRootKey(password, baseDir string) (crypto.Signer, error) {
+	key, err := keys.GetRootKey(password, baseDir)
+	if err != nil {
+		return nil, audited(baseDir, "key.unlock", map[string]string{"key": "root"}, err)
+	}
+	return key, nil
+}
+
+// audited records the outcome of a ca operation in the audit log, returning
+// the operation's error or, if it succeeded, any error writing the log
+func audited(baseDir, operation string, details map[string]string, err error) error {
+	auditErr := audit.Record(baseDir, operation, details, err)
+	if err != nil {
+		if auditErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to write audit log: %v\n", auditErr)
+		}
+		return err
+	}
+	return auditErr
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/importer"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "adopt an existing ca from openssl, easy-rsa, cfssl or mkcert",
+	ArgsUsage: "[ca directory]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "tool the ca was created with: openssl, easy-rsa, cfssl or mkcert",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "cert",
+			Usage: "root ca certificate, if not in the usual place in the ca directory",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "root ca private key in PKCS#1, PKCS#8 or SEC1 form, optionally encrypted",
+		},
+		&cli.StringFlag{
+			Name:  "key-password",
+			Usage: "password the existing root key is encrypted with",
+		},
+		&cli.StringFlag{
+			Name:  "leaf-key-password",
+			Usage: "password the keys of issued certificates are encrypted with, defaulting to --key-password",
+		},
+		&cli.StringFlag{
+			Name:  "index",
+			Usage: "openssl style index.txt listing revoked certificates",
+		},
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "json export of cfssl's certificates table",
+		},
+		&cli.StringFlag{
+			Name:  "certs",
+			Usage: "directory to search for issued certificates, keys and csrs",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+			Usage:   "password to encrypt the imported root key with",
+			Value:   "",
+		},
+		&cli.BoolFlag{
+			Name:  "no-password",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return ImportCA(importer.Options{
+			Source:          c.String("from"),
+			Dir:             c.Args().First(),
+			CertFile:        c.String("cert"),
+			KeyFile:         c.String("key"),
+			IndexFile:       c.String("index"),
+			DBFile:          c.String("db"),
+			CertsDir:        c.String("certs"),
+			KeyPassword:     []byte(c.String("key-password")),
+			LeafKeyPassword: []byte(c.String("leaf-key-password")),
+		}, c.String("password"), c.Bool("no-password"), c.String("basedir"))
+	},
+}
+
+// ImportCA copies an existing ca's root key and certificate into the base
+// directory, places the current certificate for each name under
+// certificates/ and records every certificate found in the inventory.
+// Everything is written to a staging directory first and moved into place
+// once the import has succeeded, so a failed import leaves nothing behind.
+func ImportCA(opts importer.Options, password string, noPassword bool, baseDir string) (err error) {
+	details := map[string]string{"source": opts.Source}
+	defer func() {
+		err = audited(baseDir, "import", details, err)
+	}()
+
+	for _, path := range []string{paths.GetRootRsaKeyPath(baseDir), paths.GetCACertPath(baseDir)} {
+		if _, err = os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists, import into an empty base directory", path)
+		}
+	}
+
+	ca, err := importer.Load(opts)
+	if err == keys.ErrPasswordRequired {
+		fmt.Print("enter password for the existing root key: ")
+		opts.KeyPassword, err = term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return err
+		}
+		fmt.Print("\n")
+		ca, err = importer.Load(opts)
+	}
+	if err != nil {
+		return err
+	}
+	details["subject"] = ca.Cert.Subject.String()
+	details["root_id"] = certs.RootID(ca.Cert)
+	fmt.Printf("importing %s from %s\n", ca.Cert.Subject.CommonName, opts.Source)
+	for _, warning := range ca.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	password, err = readNewPassword(password, noPassword)
+	if err != nil {
+		return err
+	}
+
+	// the staging directory sits next to the base directory so it can be
+	// renamed into place
+	base := paths.GetBasePath(baseDir)
+	if err = os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return err
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(base), "."+filepath.Base(base)+".import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err = os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+
+	if err = paths.CreateDirectories(staging); err != nil {
+		return err
+	}
+	if err = keys.SaveRootKey(ca.Key, password, staging); err != nil {
+		return err
+	}
+	if err = certs.SaveRootCACert(ca.Cert.Raw, staging); err != nil {
+		return err
+	}
+
+	// the newest valid certificate for each name becomes its current one
+	current := map[string]*importer.Issued{}
+	for _, issued := range ca.Issued {
+		if issued.Revoked || time.Now().After(issued.Cert.NotAfter) {
+			continue
+		}
+		if c, ok := current[issued.Name]; !ok || issued.Cert.NotBefore.After(c.Cert.NotBefore) {
+			current[issued.Name] = issued
+		}
+	}
+
+	var entries []inventory.Entry
+	revoked := 0
+	for _, issued := range ca.Issued {
+		entry := inventory.NewEntry(issued.Cert, issued.Name, opts.Source)
+		if issued.Revoked {
+			revoked++
+			revokedAt := issued.RevokedAt
+			entry.Status = inventory.StatusRevoked
+			entry.RevokedAt = &revokedAt
+			entry.RevocationReason = issued.RevocationReason
+		}
+		entries = append(entries, entry)
+
+		if current[issued.Name] != issued {
+			continue
+		}
+		if err = certs.SaveCert(issued.Cert.Raw, issued.Name, staging); err != nil {
+			return err
+		}
+		if issued.Key != nil {
+			keyPath, err := paths.GetRsaKeyPath(issued.Name, staging)
+			if err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(keyPath, issued.Key, 0600); err != nil {
+				return err
+			}
+			if issued.KeyEncrypted {
+				fmt.Printf("warning: the key for %s is encrypted and was copied as is\n", issued.Name)
+			}
+		}
+		if issued.CSR != nil {
+			if err = certs.SaveCsr(issued.Name, issued.CSR, staging); err != nil {
+				return err
+			}
+		}
+	}
+	if err = inventory.Add(entries, staging); err != nil {
+		return err
+	}
+	if err = moveInto(staging, base); err != nil {
+		return err
+	}
+
+	details["certificates"] = strconv.Itoa(len(ca.Issued))
+	details["current"] = strconv.Itoa(len(current))
+	details["revoked"] = strconv.Itoa(revoked)
+	fmt.Printf("imported %d certificates, %d current and %d revoked\n", len(ca.Issued), len(current), revoked)
+	if len(ca.Issued) == 0 && opts.Source == importer.SourceMkcert {
+		fmt.Println("mkcert does not keep issued certificates, use --certs to import them")
+	}
+	return nil
+}
+
+// moveInto moves the files under staging to the same places under base,
+// renaming staging itself when base does not exist yet. Nothing is moved if
+// any of the files is already in base.
+func moveInto(staging, base string) error {
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		return os.Rename(staging, base)
+	}
+
+	var files []string
+	err := filepath.Walk(staging, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Stat(filepath.Join(base, rel)); err == nil {
+			return fmt.Errorf("%s already exists, import into an empty base directory", filepath.Join(base, rel))
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range files {
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(base, rel)), 0755); err != nil {
+			return err
+		}
+		if err = os.Rename(filepath.Join(staging, rel), filepath.Join(base, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
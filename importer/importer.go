@@ -0,0 +1,433 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/keys"
+)
+
+const (
+	SourceOpenSSL = "openssl"
+	SourceEasyRSA = "easy-rsa"
+	SourceCFSSL   = "cfssl"
+	SourceMkcert  = "mkcert"
+)
+
+// Options locates the files of an existing ca. Dir is the ca's directory
+// and any file left empty is looked for in the usual place for Source.
+type Options struct {
+	Source      string
+	Dir         string
+	CertFile    string
+	KeyFile     string
+	IndexFile   string
+	DBFile      string
+	CertsDir    string
+	KeyPassword []byte
+	// LeafKeyPassword decrypts the keys of issued certificates to check they
+	// match, defaulting to KeyPassword
+	LeafKeyPassword []byte
+}
+
+// CA is an existing certificate authority read from disk
+type CA struct {
+	Cert   *x509.Certificate
+	Key    crypto.Signer
+	Issued []*Issued
+	// Warnings describe files found that could not be imported
+	Warnings []string
+}
+
+// Issued is a certificate signed by an imported ca, along with its key and
+// csr when they were found next to it
+type Issued struct {
+	Name             string
+	Cert             *x509.Certificate
+	Key              []byte
+	KeyEncrypted     bool
+	CSR              []byte
+	Revoked          bool
+	RevokedAt        time.Time
+	RevocationReason string
+}
+
+// crl reason codes from RFC 5280, as used by cfssl's database
+var revocationReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// Load reads the root certificate and key of an existing ca and every
+// certificate it issued that can be found
+func Load(opts Options) (*CA, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	certBytes, err := ioutil.ReadFile(opts.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	rootCert, err := parseCert(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", opts.CertFile, err)
+	}
+	if !rootCert.IsCA {
+		return nil, fmt.Errorf("%s is not a ca certificate", opts.CertFile)
+	}
+	keyBytes, err := ioutil.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.ParsePrivateKey(keyBytes, opts.KeyPassword)
+	if err == keys.ErrPasswordRequired {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", opts.KeyFile, err)
+	}
+	publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(rootCert.PublicKey) {
+		return nil, fmt.Errorf("%s does not match %s", opts.KeyFile, opts.CertFile)
+	}
+
+	ca := &CA{Cert: rootCert, Key: key}
+	if opts.CertsDir != "" {
+		leafKeyPassword := opts.LeafKeyPassword
+		if len(leafKeyPassword) == 0 {
+			leafKeyPassword = opts.KeyPassword
+		}
+		if err = ca.findIssued(opts.CertsDir, leafKeyPassword); err != nil {
+			return nil, err
+		}
+	}
+	if opts.IndexFile != "" {
+		if err = ca.applyIndex(opts.IndexFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DBFile != "" {
+		if err = ca.applyCFSSLDB(opts.DBFile); err != nil {
+			return nil, err
+		}
+	}
+	return ca, nil
+}
+
+func (o *Options) setDefaults() error {
+	var cert, key, index, certsDir string
+	switch o.Source {
+	case SourceEasyRSA:
+		cert, key, index, certsDir = "ca.crt", "private/ca.key", "index.txt", "."
+	case SourceOpenSSL:
+		cert, key, index, certsDir = "cacert.pem", "private/cakey.pem", "index.txt", "."
+	case SourceCFSSL:
+		cert, key, certsDir = "ca.pem", "ca-key.pem", "."
+		if o.DBFile != "" && isSQLite(o.DBFile) {
+			return fmt.Errorf("%s is a sqlite database, export it with: sqlite3 -json %s 'select * from certificates' > certs.json", o.DBFile, o.DBFile)
+		}
+	case SourceMkcert:
+		cert, key = "rootCA.pem", "rootCA-key.pem"
+		if o.Dir == "" {
+			o.Dir = os.Getenv("CAROOT")
+		}
+		if o.Dir == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				o.Dir = filepath.Join(home, ".local/share/mkcert")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown import source %q, expected %s, %s, %s or %s", o.Source, SourceOpenSSL, SourceEasyRSA, SourceCFSSL, SourceMkcert)
+	}
+
+	if o.Dir == "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return errors.New("either a ca directory or both a certificate and key are required")
+		}
+		return nil
+	}
+	if o.CertFile == "" {
+		o.CertFile = filepath.Join(o.Dir, cert)
+	}
+	if o.KeyFile == "" {
+		o.KeyFile = filepath.Join(o.Dir, key)
+	}
+	if o.IndexFile == "" && index != "" {
+		if _, err := os.Stat(filepath.Join(o.Dir, index)); err == nil {
+			o.IndexFile = filepath.Join(o.Dir, index)
+		}
+	}
+	if o.CertsDir == "" && certsDir != "" {
+		o.CertsDir = filepath.Join(o.Dir, certsDir)
+	}
+	return nil
+}
+
+// findIssued walks dir for certificates signed by the ca, naming each after
+// its file and picking up a matching key and csr where the usual tools put them
+func (ca *CA) findIssued(dir string, keyPassword []byte) error {
+	root := ca.Cert
+	bySerial := map[string]*Issued{}
+	var order []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if ext != ".crt" && ext != ".pem" && ext != ".cer" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		cert, err := parseCert(data)
+		if err != nil || cert.Equal(root) || cert.CheckSignatureFrom(root) != nil {
+			return nil
+		}
+
+		serial := serialKey(cert.SerialNumber)
+		base := strings.TrimSuffix(filepath.Base(path), ext)
+		// files named after their serial, like easy-rsa's certs_by_serial
+		// and openssl's newcerts, say nothing about the certificate's name
+		fileSerial, isSerial := new(big.Int).SetString(base, 16)
+		named := !isSerial || fileSerial.Cmp(cert.SerialNumber) != 0
+		existing, seen := bySerial[serial]
+		if seen && (existing.Name != "" || !named) {
+			return nil
+		}
+		issued := &Issued{Cert: cert}
+		if named {
+			issued.Name = base
+			var warning string
+			issued.Key, issued.KeyEncrypted, warning = findKey(path, base, cert, keyPassword)
+			if warning != "" {
+				ca.Warnings = append(ca.Warnings, warning)
+			}
+			issued.CSR = findCSR(path, base)
+		}
+		if !seen {
+			order = append(order, serial)
+		}
+		bySerial[serial] = issued
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, serial := range order {
+		i := bySerial[serial]
+		if i.Name == "" {
+			i.Name = defaultName(i.Cert)
+		}
+		ca.Issued = append(ca.Issued, i)
+	}
+	return nil
+}
+
+// findKey looks for the key of cert next to it, returning the file as it
+// is, whether it is encrypted, and a warning about an encrypted key that
+// could not be checked against cert
+func findKey(certPath, base string, cert *x509.Certificate, password []byte) ([]byte, bool, string) {
+	dir := filepath.Dir(certPath)
+	candidates := []string{
+		filepath.Join(dir, base+"-key.pem"),
+		filepath.Join(dir, base+".key"),
+		filepath.Join(dir, "private", base+".key"),
+		filepath.Join(dir, "..", "private", base+".key"),
+	}
+	warning := ""
+	for _, candidate := range candidates {
+		data, err := ioutil.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		encrypted := keys.IsEncryptedKey(data)
+		// an encrypted key is only copied once it is known to be the right one
+		key, err := keys.ParsePrivateKey(data, password)
+		if err != nil {
+			if encrypted {
+				warning = fmt.Sprintf("%s is encrypted and cannot be checked against its certificate, give its password with --leaf-key-password to import it", candidate)
+			}
+			continue
+		}
+		if publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && publicKey.Equal(cert.PublicKey) {
+			return data, encrypted, ""
+		}
+	}
+	return nil, false, warning
+}
+
+func findCSR(certPath, base string) []byte {
+	dir := filepath.Dir(certPath)
+	for _, candidate := range []string{
+		filepath.Join(dir, base+".csr"),
+		filepath.Join(dir, "..", "reqs", base+".req"),
+	} {
+		data, err := ioutil.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		if block, _ := pem.Decode(data); block != nil && strings.HasSuffix(block.Type, "CERTIFICATE REQUEST") {
+			return block.Bytes
+		}
+	}
+	return nil
+}
+
+// applyIndex marks revoked certificates from an openssl or easy-rsa
+// index.txt, whose lines are status, expiry, revocation, serial, file and
+// subject separated by tabs
+func (ca *CA) applyIndex(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[0] != "R" {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(fields[3], 16)
+		if !ok {
+			return fmt.Errorf("%s line %d: invalid serial %q", path, line, fields[3])
+		}
+		revocation := strings.SplitN(fields[2], ",", 2)
+		revokedAt, err := time.Parse("060102150405Z", revocation[0])
+		if err != nil {
+			return fmt.Errorf("%s line %d: invalid revocation date %q", path, line, revocation[0])
+		}
+		reason := "unspecified"
+		if len(revocation) == 2 {
+			reason = revocation[1]
+		}
+		ca.revoke(serial, revokedAt, reason)
+	}
+	return scanner.Err()
+}
+
+type cfsslRecord struct {
+	SerialNumber string `json:"serial_number"`
+	Status       string `json:"status"`
+	Reason       int    `json:"reason"`
+	RevokedAt    string `json:"revoked_at"`
+	PEM          string `json:"pem"`
+}
+
+// applyCFSSLDB adds the certificates in a json export of cfssl's
+// certificates table, which holds every certificate cfssl has signed
+func (ca *CA) applyCFSSLDB(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var records []cfsslRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	known := map[string]bool{}
+	for _, issued := range ca.Issued {
+		known[serialKey(issued.Cert.SerialNumber)] = true
+	}
+	for _, record := range records {
+		cert, err := parseCert([]byte(record.PEM))
+		if err != nil {
+			return fmt.Errorf("%s: certificate %s: %v", path, record.SerialNumber, err)
+		}
+		if cert.CheckSignatureFrom(ca.Cert) != nil {
+			continue
+		}
+		if !known[serialKey(cert.SerialNumber)] {
+			known[serialKey(cert.SerialNumber)] = true
+			ca.Issued = append(ca.Issued, &Issued{Name: defaultName(cert), Cert: cert})
+		}
+		if record.Status == "revoked" {
+			reason, ok := revocationReasons[record.Reason]
+			if !ok {
+				reason = strconv.Itoa(record.Reason)
+			}
+			ca.revoke(cert.SerialNumber, parseCFSSLTime(record.RevokedAt), reason)
+		}
+	}
+	return nil
+}
+
+func (ca *CA) revoke(serial *big.Int, revokedAt time.Time, reason string) {
+	for _, issued := range ca.Issued {
+		if issued.Cert.SerialNumber.Cmp(serial) == 0 {
+			issued.Revoked = true
+			issued.RevokedAt = revokedAt
+			issued.RevocationReason = reason
+		}
+	}
+}
+
+func parseCFSSLTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func parseCert(data []byte) (*x509.Certificate, error) {
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+		data = rest
+	}
+}
+
+func defaultName(cert *x509.Certificate) string {
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+	name = strings.NewReplacer("/", "_", `\`, "_", " ", "_").Replace(name)
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = cert.SerialNumber.Text(16)
+	}
+	return name
+}
+
+func serialKey(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+func isSQLite(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	return err == nil && bytes.HasPrefix(data, []byte("SQLite format 3\x00"))
+}
@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/galenguyer/hancock/keys"
+)
+
+func TestFindKeyEncrypted(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{PublicKey: key.Public()}
+
+	encrypt := func(k *ecdsa.PrivateKey) []byte {
+		block, err := keys.MarshalPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("secret"), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(block)
+	}
+
+	for _, test := range []struct {
+		name     string
+		key      *ecdsa.PrivateKey
+		password string
+		found    bool
+		warning  bool
+	}{
+		{"matching", key, "secret", true, false},
+		{"another key", other, "secret", false, false},
+		{"wrong password", key, "wrong", false, true},
+		{"no password", key, "", false, true},
+	} {
+		dir := t.TempDir()
+		data := encrypt(test.key)
+		if err = ioutil.WriteFile(filepath.Join(dir, "leaf.key"), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		found, encrypted, warning := findKey(filepath.Join(dir, "leaf.crt"), "leaf", cert, []byte(test.password))
+		if (found != nil) != test.found {
+			t.Errorf("%s: found key %v, want %v", test.name, found != nil, test.found)
+		}
+		if found != nil && (!encrypted || string(found) != string(data)) {
+			t.Errorf("%s: key was not returned encrypted as it was", test.name)
+		}
+		if (warning != "") != test.warning {
+			t.Errorf("%s: warning %q", test.name, warning)
+		}
+	}
+}
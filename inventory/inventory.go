@@ -0,0 +1,184 @@
+package inventory
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/lockfile"
+	"github.com/galenguyer/hancock/paths"
+)
+
+const (
+	StatusValid   = "valid"
+	StatusRevoked = "revoked"
+
+	SourceHancock = "hancock"
+	// SourceRequest is a certificate signed from a submitted csr, whose key
+	// hancock does not hold
+	SourceRequest = "request"
+	// SourceK8s is a certificate signed from a kubernetes
+	// CertificateSigningRequest, whose key stays in the cluster
+	SourceK8s = "k8s"
+)
+
+// Entry is a certificate issued by, or imported into, the ca. Entries are
+// kept by serial so that replaced, expired and revoked certificates are
+// remembered after certificates/<name> moves on.
+type Entry struct {
+	Serial           string     `json:"serial"`
+	Name             string     `json:"name"`
+	Subject          string     `json:"subject"`
+	NotBefore        time.Time  `json:"not_before"`
+	NotAfter         time.Time  `json:"not_after"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	Source           string     `json:"source"`
+	// Profile is the issuance profile the certificate was issued with
+	Profile string `json:"profile,omitempty"`
+	// Metadata holds the owner, contact and any other labels, and is carried
+	// over when the certificate is renewed
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Cert is written alongside the index by Add
+	Cert *x509.Certificate `json:"-"`
+}
+
+// NewEntry describes a certificate saved under name
+func NewEntry(cert *x509.Certificate, name, source string) Entry {
+	return Entry{
+		Serial:    Serial(cert),
+		Name:      name,
+		Subject:   cert.Subject.String(),
+		NotBefore: cert.NotBefore.UTC(),
+		NotAfter:  cert.NotAfter.UTC(),
+		Status:    StatusValid,
+		Source:    source,
+		Cert:      cert,
+	}
+}
+
+// Serial formats a certificate's serial number as inventory keys and file
+// names use it
+func Serial(cert *x509.Certificate) string {
+	return strings.ToLower(cert.SerialNumber.Text(16))
+}
+
+// Load returns every entry in the order the certificates were issued
+func Load(baseDir string) ([]Entry, error) {
+	bytes, err := ioutil.ReadFile(paths.GetInventoryIndexPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	return entries, json.Unmarshal(bytes, &entries)
+}
+
+// Add records certificates in the inventory, replacing any entry with the
+// same serial, and keeps a copy of each certificate
+func Add(entries []Entry, baseDir string) error {
+	if err := os.MkdirAll(paths.GetInventoryPath(baseDir), 0755); err != nil {
+		return err
+	}
+	// no other process may change the index between reading and writing it
+	unlock, err := lockfile.Lock(paths.GetInventoryLockPath(baseDir), "inventory")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := Load(baseDir)
+	if err != nil {
+		return err
+	}
+	bySerial := map[string]int{}
+	for i, entry := range existing {
+		bySerial[entry.Serial] = i
+	}
+	for _, entry := range entries {
+		if entry.Cert != nil {
+			certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: entry.Cert.Raw})
+			if err = ioutil.WriteFile(paths.GetInventoryCertPath(entry.Serial, baseDir), certPem, 0644); err != nil {
+				return err
+			}
+		}
+		if j, ok := bySerial[entry.Serial]; ok {
+			existing[j] = entry
+			continue
+		}
+		bySerial[entry.Serial] = len(existing)
+		existing = append(existing, entry)
+	}
+	return save(existing, baseDir)
+}
+
+// Save replaces the inventory index
+func Save(entries []Entry, baseDir string) error {
+	if err := os.MkdirAll(paths.GetInventoryPath(baseDir), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockfile.Lock(paths.GetInventoryLockPath(baseDir), "inventory")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return save(entries, baseDir)
+}
+
+// save writes the index through a temporary file, so that readers never see
+// it half written
+func save(entries []Entry, baseDir string) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].NotBefore.Before(entries[j].NotBefore)
+	})
+	if err := os.MkdirAll(paths.GetInventoryPath(baseDir), 0755); err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := paths.GetInventoryIndexPath(baseDir) + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(bytes, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, paths.GetInventoryIndexPath(baseDir))
+}
+
+// Get returns the entry with the given serial, or nil if there is none
+func Get(serial, baseDir string) (*Entry, error) {
+	entries, err := Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Serial == strings.ToLower(serial) {
+			return &entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetCert returns the copy of the certificate with the given serial
+func GetCert(serial, baseDir string) (*x509.Certificate, error) {
+	if strings.ContainsAny(serial, `/\.`) {
+		return nil, errors.New("invalid serial " + serial)
+	}
+	bytes, err := ioutil.ReadFile(paths.GetInventoryCertPath(strings.ToLower(serial), baseDir))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, errors.New("no certificate found for serial " + serial)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
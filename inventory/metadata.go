@@ -0,0 +1,134 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// well known metadata keys, which have their own flags
+const (
+	MetaOwner       = "owner"
+	MetaContact     = "contact"
+	MetaEnvironment = "environment"
+	MetaService     = "service"
+	MetaTicket      = "ticket"
+)
+
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// ParseLabels parses key=value pairs into metadata
+func ParseLabels(pairs []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		if !metadataKey.MatchString(pair[:i]) {
+			return nil, fmt.Errorf("invalid label key %q", pair[:i])
+		}
+		labels[pair[:i]] = pair[i+1:]
+	}
+	return labels, nil
+}
+
+// Contacts returns the addresses in the contact metadata, which may hold
+// several separated by commas
+func (e Entry) Contacts() []string {
+	var contacts []string
+	for _, contact := range strings.Split(e.Metadata[MetaContact], ",") {
+		if contact = strings.TrimSpace(contact); contact != "" {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// ValidateContacts checks that contact metadata only holds email addresses,
+// as notify puts them in mail headers
+func ValidateContacts(metadata map[string]string) error {
+	for _, contact := range strings.Split(metadata[MetaContact], ",") {
+		if contact = strings.TrimSpace(contact); contact == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(contact); err != nil {
+			return fmt.Errorf("invalid contact %q: %v", contact, err)
+		}
+	}
+	return nil
+}
+
+// FormatMetadata lists metadata as sorted key=value pairs
+func FormatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool
+	// exists only checks whether the key is set
+	exists bool
+}
+
+// Selector matches metadata against comma separated requirements, each one
+// of key=value, key!=value, key to require it is set or !key to require it
+// is not
+type Selector []requirement
+
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		req := requirement{}
+		switch {
+		case strings.Contains(part, "!="):
+			i := strings.Index(part, "!=")
+			req.key, req.value, req.negate = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+2:]), true
+		case strings.Contains(part, "="):
+			i := strings.Index(part, "=")
+			req.key, req.value = strings.TrimSpace(part[:i]), strings.TrimSpace(strings.TrimPrefix(part[i+1:], "="))
+		case strings.HasPrefix(part, "!"):
+			req.key, req.exists, req.negate = strings.TrimSpace(part[1:]), true, true
+		default:
+			req.key, req.exists = part, true
+		}
+		if !metadataKey.MatchString(req.key) {
+			return nil, fmt.Errorf("invalid selector %q", part)
+		}
+		selector = append(selector, req)
+	}
+	if len(selector) == 0 && strings.TrimSpace(s) != "" {
+		return nil, errors.New("empty selector")
+	}
+	return selector, nil
+}
+
+// Matches reports whether metadata meets every requirement of the selector
+func (s Selector) Matches(metadata map[string]string) bool {
+	for _, req := range s {
+		value, ok := metadata[req.key]
+		if req.exists {
+			if ok == req.negate {
+				return false
+			}
+			continue
+		}
+		if (ok && value == req.value) == req.negate {
+			return false
+		}
+	}
+	return true
+}
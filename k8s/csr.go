@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KindCSR is the kind of kubernetes certificate signing requests
+const KindCSR = "CertificateSigningRequest"
+
+// conditions of a certificate signing request
+const (
+	ConditionApproved = "Approved"
+	ConditionDenied   = "Denied"
+	ConditionFailed   = "Failed"
+)
+
+// CSRs returns the certificate signing requests among docs, including those
+// in a kubectl List
+func CSRs(docs []interface{}) []*Map {
+	var csrs []*Map
+	for _, doc := range docs {
+		obj, ok := doc.(*Map)
+		if !ok {
+			continue
+		}
+		switch obj.GetString("kind") {
+		case KindCSR:
+			csrs = append(csrs, obj)
+		case "List", KindCSR + "List":
+			items, _ := obj.Get("items").([]interface{})
+			csrs = append(csrs, CSRs(items)...)
+		}
+	}
+	return csrs
+}
+
+// CSRName is the name of a certificate signing request
+func CSRName(csr *Map) string {
+	return csr.GetMap("metadata").GetString("name")
+}
+
+// CSRRequest returns the der csr of a certificate signing request
+func CSRRequest(csr *Map) ([]byte, error) {
+	encoded := csr.GetMap("spec").GetString("request")
+	if encoded == "" {
+		return nil, errors.New("spec.request is empty")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("spec.request: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("spec.request is not a pem csr")
+	}
+	return block.Bytes, nil
+}
+
+// CSRUsages lists the key usages a certificate signing request asks for
+func CSRUsages(csr *Map) []string {
+	var usages []string
+	items, _ := csr.GetMap("spec").Get("usages").([]interface{})
+	for _, item := range items {
+		if usage, ok := item.(string); ok {
+			usages = append(usages, usage)
+		}
+	}
+	return usages
+}
+
+// CSRExpiration is the lifetime a certificate signing request asks for, or
+// zero if it leaves it to the signer
+func CSRExpiration(csr *Map) time.Duration {
+	seconds, _ := csr.GetMap("spec").Get("expirationSeconds").(int64)
+	return time.Duration(seconds) * time.Second
+}
+
+// HasCondition reports whether a condition of the type is true
+func HasCondition(csr *Map, conditionType string) bool {
+	conditions, _ := csr.GetMap("status").Get("conditions").([]interface{})
+	for _, item := range conditions {
+		condition, ok := item.(*Map)
+		if ok && condition.GetString("type") == conditionType && condition.GetString("status") != "False" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCondition adds a true condition to the status, replacing one of the
+// same type
+func SetCondition(csr *Map, conditionType, reason, message string, now time.Time) {
+	condition := &Map{}
+	condition.Set("type", conditionType)
+	condition.Set("status", "True")
+	condition.Set("reason", reason)
+	condition.Set("message", message)
+	condition.Set("lastUpdateTime", now.UTC().Format(time.RFC3339))
+	condition.Set("lastTransitionTime", now.UTC().Format(time.RFC3339))
+
+	status := csr.Ensure("status")
+	conditions, _ := status.Get("conditions").([]interface{})
+	for i, item := range conditions {
+		if existing, ok := item.(*Map); ok && existing.GetString("type") == conditionType {
+			conditions[i] = condition
+			return
+		}
+	}
+	status.Set("conditions", append(conditions, condition))
+}
+
+// CSRCertificate returns the pem certificate chain already issued for a
+// certificate signing request, if any
+func CSRCertificate(csr *Map) string {
+	return csr.GetMap("status").GetString("certificate")
+}
+
+// SetCertificate records the issued pem certificate chain in the status
+func SetCertificate(csr *Map, chainPEM []byte) {
+	csr.Ensure("status").Set("certificate", base64.StdEncoding.EncodeToString(chainPEM))
+}
@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// keys of a kubernetes.io/tls secret
+const (
+	SecretTypeTLS = "kubernetes.io/tls"
+	TLSCertKey    = "tls.crt"
+	TLSKeyKey     = "tls.key"
+	CACertKey     = "ca.crt"
+)
+
+var (
+	labelNamePattern  = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+	invalidNameChars  = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+// Object starts a kubernetes object with its metadata
+func Object(apiVersion, kind, name, namespace string, labels, annotations map[string]string) *Map {
+	metadata := &Map{}
+	metadata.Set("name", name)
+	if namespace != "" {
+		metadata.Set("namespace", namespace)
+	}
+	if len(labels) > 0 {
+		metadata.Set("labels", sortedMap(labels))
+	}
+	if len(annotations) > 0 {
+		metadata.Set("annotations", sortedMap(annotations))
+	}
+	obj := &Map{}
+	obj.Set("apiVersion", apiVersion)
+	obj.Set("kind", kind)
+	obj.Set("metadata", metadata)
+	return obj
+}
+
+// TLSSecret is a kubernetes.io/tls secret holding a certificate chain, its
+// key and, if caPEM is set, the ca that issued it
+func TLSSecret(name, namespace string, labels, annotations map[string]string, certPEM, keyPEM, caPEM []byte) *Map {
+	secret := Object("v1", "Secret", name, namespace, labels, annotations)
+	secret.Set("type", SecretTypeTLS)
+	data := &Map{}
+	data.Set(TLSCertKey, base64.StdEncoding.EncodeToString(certPEM))
+	data.Set(TLSKeyKey, base64.StdEncoding.EncodeToString(keyPEM))
+	if caPEM != nil {
+		data.Set(CACertKey, base64.StdEncoding.EncodeToString(caPEM))
+	}
+	secret.Set("data", data)
+	return secret
+}
+
+// CAIssuer is a cert-manager issuer that signs with the ca in secretName, a
+// ClusterIssuer if cluster is set
+func CAIssuer(name, namespace, secretName string, cluster bool, labels map[string]string) *Map {
+	kind := "Issuer"
+	if cluster {
+		kind, namespace = "ClusterIssuer", ""
+	}
+	issuer := Object("cert-manager.io/v1", kind, name, namespace, labels, nil)
+	ca := &Map{}
+	ca.Set("secretName", secretName)
+	spec := &Map{}
+	spec.Set("ca", ca)
+	issuer.Set("spec", spec)
+	return issuer
+}
+
+// ObjectName turns a hancock name into a valid object name, lowercase with
+// anything else but letters, digits, dots and dashes replaced
+func ObjectName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, ".-")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], ".-")
+	}
+	return name
+}
+
+// Labels splits certificate metadata into what can be a label under prefix,
+// and what has to be an annotation because of its value. Keys that cannot
+// be either are left out.
+func Labels(prefix string, metadata map[string]string) (map[string]string, map[string]string) {
+	labels, annotations := map[string]string{}, map[string]string{}
+	for key, value := range metadata {
+		if labelNamePattern.MatchString(key) && labelValuePattern.MatchString(value) {
+			labels[prefix+key] = value
+		} else if labelNamePattern.MatchString(key) {
+			annotations[prefix+key] = value
+		}
+	}
+	return labels, annotations
+}
+
+func sortedMap(values map[string]string) *Map {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	m := &Map{}
+	for _, key := range keys {
+		m.Set(key, values[key])
+	}
+	return m
+}
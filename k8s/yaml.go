@@ -0,0 +1,299 @@
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Map is a yaml or json mapping that keeps the order of its keys, so objects
+// read from a file are written back the way they were
+type Map struct {
+	Items []MapItem
+}
+
+// MapItem is a key and its value: a *Map, a []interface{}, a string, an
+// int64, a float64, a bool or nil
+type MapItem struct {
+	Key   string
+	Value interface{}
+}
+
+// Get returns the value for key, or nil
+func (m *Map) Get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	for _, item := range m.Items {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// GetMap returns the mapping at the path of keys, or nil if there is none
+func (m *Map) GetMap(path ...string) *Map {
+	for _, key := range path {
+		m, _ = m.Get(key).(*Map)
+	}
+	return m
+}
+
+// GetString returns the string for key, or an empty string
+func (m *Map) GetString(key string) string {
+	s, _ := m.Get(key).(string)
+	return s
+}
+
+// Set replaces the value for key, adding it at the end if it is not there
+func (m *Map) Set(key string, value interface{}) {
+	for i, item := range m.Items {
+		if item.Key == key {
+			m.Items[i].Value = value
+			return
+		}
+	}
+	m.Items = append(m.Items, MapItem{key, value})
+}
+
+// Ensure returns the mapping for key, adding an empty one if there is none
+func (m *Map) Ensure(key string) *Map {
+	if child, ok := m.Get(key).(*Map); ok {
+		return child
+	}
+	child := &Map{}
+	m.Set(key, child)
+	return child
+}
+
+// MarshalJSON writes the mapping with its keys in order
+func (m *Map) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, item := range m.Items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// IsJSON reports whether data looks like json rather than block yaml
+func IsJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
+
+// DecodeJSON reads a stream of json documents, keeping the order of keys
+func DecodeJSON(data []byte) ([]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var docs []interface{}
+	for {
+		doc, err := decodeJSONValue(decoder)
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			m := &Map{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				m.Items = append(m.Items, MapItem{key.(string), value})
+			}
+			_, err = decoder.Token()
+			return m, err
+		}
+		seq := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, value)
+		}
+		_, err = decoder.Token()
+		return seq, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
+
+// EncodeJSON writes a document indented as kubectl does
+func EncodeJSON(doc interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// DecodeYAML reads a stream of yaml documents, leaving out empty ones
+func DecodeYAML(data []byte) ([]interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []interface{}
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		doc, err := fromNode(node.Content[0])
+		if err != nil {
+			return nil, err
+		} else if doc == nil {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// fromNode turns a yaml node into the values a Map holds
+func fromNode(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		m := &Map{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be scalars", key.Line)
+			}
+			value, err := fromNode(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m.Set(key.Value, value)
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		seq := []interface{}{}
+		for _, child := range node.Content {
+			value, err := fromNode(child)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, value)
+		}
+		return seq, nil
+	case yaml.AliasNode:
+		return fromNode(node.Alias)
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			err := node.Decode(&b)
+			return b, err
+		case "!!int":
+			var i int64
+			if err := node.Decode(&i); err == nil {
+				return i, nil
+			}
+			var f float64
+			err := node.Decode(&f)
+			return f, err
+		case "!!float":
+			var f float64
+			err := node.Decode(&f)
+			return f, err
+		}
+		return node.Value, nil
+	}
+	return nil, fmt.Errorf("line %d: unexpected yaml node", node.Line)
+}
+
+// EncodeYAML writes documents as a yaml stream
+func EncodeYAML(docs ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		node, err := toNode(doc)
+		if err != nil {
+			return nil, err
+		}
+		if err = encoder.Encode(node); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toNode turns the values a Map holds into a yaml node
+func toNode(value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case *Map:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range v.Items {
+			child, err := toNode(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item.Key}, child)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			child, err := toNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}, nil
+	}
+	node := &yaml.Node{}
+	return node, node.Encode(value)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/galenguyer/hancock/audit"
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/k8s"
+	"github.com/urfave/cli/v2"
+)
+
+// k8sNamePrefix keeps certificates signed for kubernetes apart from the ones
+// hancock holds the keys for
+const k8sNamePrefix = "k8s-"
+
+// usages of kubernetes certificate signing requests that hancock can issue,
+// by the names config.json gives them
+var k8sKeyUsages = map[string]string{
+	"digital signature": "digital_signature",
+	"key encipherment":  "key_encipherment",
+}
+
+var k8sExtKeyUsages = map[string]string{
+	"server auth": "server_auth",
+	"client auth": "client_auth",
+}
+
+var k8sCommand = &cli.Command{
+	Name:  "k8s",
+	Usage: "sign kubernetes certificate signing requests",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:      "sign",
+			Usage:     "sign the approved CertificateSigningRequest objects in yaml or json files and write the certificates into their status",
+			ArgsUsage: "<file>...",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "approve",
+					Usage: "approve requests that are not approved yet before signing them",
+				},
+				&cli.StringFlag{
+					Name:  "signer-name",
+					Usage: "only sign requests for this signer, such as example.com/hancock",
+				},
+				&cli.IntFlag{
+					Name:    "lifetime",
+					Aliases: []string{"t"},
+					Usage:   "lifetime in days, shortened to what a request asks for with expirationSeconds",
+					Value:   90,
+				},
+				&cli.StringFlag{
+					Name:  "profile",
+					Usage: "issuance profile from config.json in the base directory",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write the signed requests to, instead of updating the file in place",
+				},
+				&cli.StringFlag{
+					Name:    "password",
+					Aliases: []string{"p"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return SignK8sCSRs(c.Args().Slice(), c.Bool("approve"), c.String("signer-name"), c.Int("lifetime"), c.String("profile"), c.String("out"), c.String("password"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+// SignK8sCSRs signs the certificate signing requests in each file, writing
+// the certificate or the reason it failed into the status of each. Requests
+// that are not approved, are denied or already have a certificate are left
+// alone.
+func SignK8sCSRs(files []string, approve bool, signerName string, lifetime int, profile, out, password, baseDir string) error {
+	if len(files) == 0 {
+		return errors.New("at least one file is required")
+	}
+	if out != "" && len(files) > 1 {
+		return errors.New("--out can only be used with a single file")
+	}
+	// signing here would go around the approvers
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	if conf.Approval.Enabled {
+		return errors.New("issuance needs approval, queue the csrs with hancock requests submit instead")
+	}
+
+	// the root key is only unlocked once there is something to sign
+	var rootKey crypto.Signer
+	signed, failed := 0, 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		isJSON := k8s.IsJSON(data)
+		var docs []interface{}
+		if isJSON {
+			docs, err = k8s.DecodeJSON(data)
+		} else {
+			docs, err = k8s.DecodeYAML(data)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		csrs := k8s.CSRs(docs)
+		if len(csrs) == 0 {
+			fmt.Printf("%s: no certificate signing requests\n", file)
+			continue
+		}
+
+		changed := false
+		for _, csr := range csrs {
+			name := k8s.CSRName(csr)
+			if reason := k8sSkipReason(csr, approve, signerName); reason != "" {
+				fmt.Printf("skipped %s: %s\n", name, reason)
+				continue
+			}
+			// a wrong password should not mark every request as failed
+			if rootKey == nil {
+				if rootKey, err = unlockRootKey(password, baseDir); err != nil {
+					return err
+				}
+			}
+			changed = true
+			serial, err := signK8sCSR(csr, approve, lifetime, profile, rootKey, baseDir)
+			if err != nil {
+				failed++
+				fmt.Printf("failed %s: %v\n", name, err)
+				continue
+			}
+			signed++
+			fmt.Printf("signed %s as %s with serial %s\n", name, k8sNamePrefix+name, serial)
+		}
+		if !changed {
+			continue
+		}
+
+		if isJSON {
+			// a stream of several json objects is written back as an array
+			var doc interface{} = docs
+			if len(docs) == 1 {
+				doc = docs[0]
+			}
+			if data, err = k8s.EncodeJSON(doc); err != nil {
+				return err
+			}
+		} else {
+			if data, err = k8s.EncodeYAML(docs...); err != nil {
+				return err
+			}
+		}
+		path := file
+		if out != "" {
+			path = out
+		}
+		if err = ioutil.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", failed, signed+failed)
+	}
+	return nil
+}
+
+// k8sSkipReason says why a request should not be signed, if it should not
+func k8sSkipReason(csr *k8s.Map, approve bool, signerName string) string {
+	switch {
+	case k8s.CSRCertificate(csr) != "":
+		return "already issued"
+	case signerName != "" && csr.GetMap("spec").GetString("signerName") != signerName:
+		return "signer is " + csr.GetMap("spec").GetString("signerName")
+	case k8s.HasCondition(csr, k8s.ConditionDenied):
+		return "denied"
+	case k8s.HasCondition(csr, k8s.ConditionFailed):
+		return "failed before"
+	case !approve && !k8s.HasCondition(csr, k8s.ConditionApproved):
+		return "not approved, use --approve to approve it"
+	}
+	return ""
+}
+
+// signK8sCSR signs one request, recording the certificate, or why it could
+// not be issued, in its status
+func signK8sCSR(csr *k8s.Map, approve bool, lifetime int, profile string, rootKey crypto.Signer, baseDir string) (serial string, err error) {
+	name := k8s.CSRName(csr)
+	spec := csr.GetMap("spec")
+	details := map[string]string{"name": name, "signer": spec.GetString("signerName"), "username": spec.GetString("username")}
+	defer func() {
+		if err != nil {
+			k8s.SetCondition(csr, k8s.ConditionFailed, "SigningFailed", err.Error(), time.Now())
+		}
+		err = audited(baseDir, "k8s.sign", details, err)
+	}()
+
+	if name == "" {
+		return "", errors.New("the request has no name")
+	}
+	if k8s.ObjectName(name) != name {
+		return "", fmt.Errorf("%q is not a valid object name", name)
+	}
+	// the key is in the cluster, so the certificate must not take the place
+	// of another one
+	certName := k8sNamePrefix + name
+	details["cert_name"] = certName
+	if _, err := certs.GetCert(certName, baseDir); err == nil {
+		return "", fmt.Errorf("a certificate named %s already exists", certName)
+	}
+	// the certificate has the usages the request asks for
+	var usages certs.Extensions
+	for _, usage := range k8s.CSRUsages(csr) {
+		if name, ok := k8sKeyUsages[usage]; ok {
+			usages.KeyUsage = append(usages.KeyUsage, name)
+		} else if name, ok := k8sExtKeyUsages[usage]; ok {
+			usages.ExtKeyUsage = append(usages.ExtKeyUsage, name)
+		} else {
+			return "", fmt.Errorf("unsupported usage %q", usage)
+		}
+	}
+	der, err := k8s.CSRRequest(csr)
+	if err != nil {
+		return "", err
+	}
+	if !k8s.HasCondition(csr, k8s.ConditionApproved) && approve {
+		k8s.SetCondition(csr, k8s.ConditionApproved, "HancockApprove", "approved by "+audit.Operator()+" with hancock", time.Now())
+		details["approved_by"] = audit.Operator()
+	}
+
+	// kubernetes asks for seconds, hancock signs for whole days
+	if expiration := k8s.CSRExpiration(csr); expiration > 0 {
+		days := int((expiration + 24*time.Hour - 1) / (24 * time.Hour))
+		if days < lifetime {
+			lifetime = days
+		}
+	}
+
+	certBytes, err := signCert(der, profile, usages, lifetime, rootKey, baseDir)
+	if err != nil {
+		return "", err
+	}
+	if err = certs.SaveCsr(certName, der, baseDir); err != nil {
+		return "", err
+	}
+	metadata := map[string]string{}
+	if signerName := spec.GetString("signerName"); signerName != "" {
+		metadata["k8s-signer"] = signerName
+	}
+	if username := spec.GetString("username"); username != "" {
+		metadata["k8s-username"] = username
+	}
+	if err = saveCert(certBytes, certName, inventory.SourceK8s, profile, metadata, baseDir); err != nil {
+		return "", err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return "", err
+	}
+	chain, err := exportChain(cert, baseDir)
+	if err != nil {
+		return "", err
+	}
+	k8s.SetCertificate(csr, encodeCerts(chain[:len(chain)-1]))
+
+	serial = cert.SerialNumber.Text(16)
+	details["serial"] = serial
+	details["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+	return serial, nil
+}
@@ -0,0 +1,239 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeRSA     = "rsa"
+	TypeECDSA   = "ecdsa"
+	TypeEd25519 = "ed25519"
+
+	FormatPKCS1   = "pkcs1"
+	FormatPKCS8   = "pkcs8"
+	FormatSEC1    = "sec1"
+	FormatOpenSSH = "openssh"
+)
+
+// KeyOutput controls how a leaf private key is written to disk. An empty
+// format uses the traditional one for the key type and a zero mode means 0600.
+type KeyOutput struct {
+	Format   string      `json:"format,omitempty"`
+	Password string      `json:"-"`
+	Mode     os.FileMode `json:"mode,omitempty"`
+	Owner    string      `json:"owner,omitempty"`
+	Group    string      `json:"group,omitempty"`
+}
+
+// GenerateKey generates a leaf key of the given type, using bits for rsa
+// and curve, such as P-256, for ecdsa
+func GenerateKey(keyType string, bits int, curve string) (crypto.Signer, error) {
+	switch keyType {
+	case "", TypeRSA:
+		return GenerateRsaKey(bits)
+	case TypeECDSA:
+		c, err := parseCurve(curve)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(c, rand.Reader)
+	case TypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type %q, expected rsa, ecdsa or ed25519", keyType)
+}
+
+func parseCurve(curve string) (elliptic.Curve, error) {
+	switch strings.ToUpper(curve) {
+	case "", "P-256", "P256", "SECP256R1", "PRIME256V1":
+		return elliptic.P256(), nil
+	case "P-384", "P384", "SECP384R1":
+		return elliptic.P384(), nil
+	case "P-521", "P521", "SECP521R1":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q, expected P-256, P-384 or P-521", curve)
+}
+
+// EncodePrivateKey encodes a key as PEM in the given format, encrypting it
+// with password if one is given. PKCS#1 and SEC1 keys use the traditional
+// PEM encryption headers, PKCS#8 keys PBES2 and openssh keys bcrypt_pbkdf.
+func EncodePrivateKey(key crypto.Signer, format, password string) ([]byte, error) {
+	var block *pem.Block
+	var err error
+	switch format {
+	case "":
+		block, err = MarshalPrivateKey(key)
+	case FormatPKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("pkcs1 can only hold rsa keys, not %s", Describe(key.Public()))
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	case FormatSEC1:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("sec1 can only hold ecdsa keys, not %s", Describe(key.Public()))
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case FormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		if password != "" {
+			if der, err = encryptPKCS8(der, []byte(password)); err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: encryptedPKCS8Type, Bytes: der}
+		}
+		return pem.EncodeToMemory(block), nil
+	case FormatOpenSSH:
+		block, err = marshalOpenSSHKey(key, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(block), nil
+	default:
+		return nil, fmt.Errorf("unknown key format %q, expected pkcs1, pkcs8, sec1 or openssh", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" && password != "" {
+		return EncodePrivateKey(key, FormatPKCS8, password)
+	}
+	if password != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(password), x509.PEMCipherAES256)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// DetectKeyOutput describes how an existing key file was written, so that
+// a replacement can be written the same way
+func DetectKeyOutput(path string) (KeyOutput, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return KeyOutput{}, false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return KeyOutput{}, false, err
+	}
+	out := KeyOutput{Mode: info.Mode().Perm()}
+	block, err := findPrivateKeyBlock(data)
+	if err != nil || block == nil {
+		return KeyOutput{}, false, fmt.Errorf("no private key found in %s", path)
+	}
+	out.Format = KeyFormat(data)
+	out.Owner, out.Group = fileOwner(info)
+	return out, IsEncryptedKey(data), nil
+}
+
+// KeyFormat returns the format of a PEM private key, or an empty string if
+// data is not PEM
+func KeyFormat(data []byte) string {
+	block, err := findPrivateKeyBlock(data)
+	if err != nil || block == nil {
+		return ""
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return FormatPKCS1
+	case "EC PRIVATE KEY":
+		return FormatSEC1
+	case "PRIVATE KEY", encryptedPKCS8Type:
+		return FormatPKCS8
+	case openSSHKeyType:
+		return FormatOpenSSH
+	}
+	return ""
+}
+
+// SaveKey writes key to path as described by out
+func SaveKey(key crypto.Signer, out KeyOutput, path string) error {
+	keyBytes, err := EncodePrivateKey(key, out.Format, out.Password)
+	if err != nil {
+		return err
+	}
+	return WriteKeyFile(path, keyBytes, out)
+}
+
+// WriteKeyFile writes key bytes to path with the mode, owner and group from out
+func WriteKeyFile(path string, keyBytes []byte, out KeyOutput) error {
+	mode := out.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	// write to a temporary file so the key is never readable with the wrong
+	// mode or owner, even briefly
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, keyBytes, 0600); err != nil {
+		return err
+	}
+	uid, gid, err := lookupOwner(out.Owner, out.Group)
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Chown(tmp, uid, gid)
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// lookupOwner resolves user and group names or ids, returning -1 for
+// whichever is empty so that os.Chown leaves it alone
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return 0, 0, fmt.Errorf("unknown user %s", owner)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("unknown group %s", group)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,172 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	openSSHKeyType   = "OPENSSH PRIVATE KEY"
+	openSSHMagic     = "openssh-key-v1\x00"
+	openSSHKDFRounds = 16
+)
+
+type openSSHKey struct {
+	CipherName   string
+	KDFName      string
+	KDFOptions   string
+	NumKeys      uint32
+	PublicKey    []byte
+	PrivateBlock []byte
+}
+
+type openSSHKDFOptions struct {
+	Salt   []byte
+	Rounds uint32
+}
+
+// marshalOpenSSHKey encodes a key in the openssh-key-v1 format written by
+// ssh-keygen, encrypted with aes256-ctr and bcrypt_pbkdf if a password is given
+func marshalOpenSSHKey(key crypto.Signer, password []byte) (*pem.Block, error) {
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	check := make([]byte, 4)
+	if _, err = rand.Read(check); err != nil {
+		return nil, err
+	}
+	private := append(append([]byte{}, check...), check...)
+	private = append(private, ssh.Marshal(struct{ KeyType string }{publicKey.Type()})...)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		k.Precompute()
+		private = append(private, ssh.Marshal(struct {
+			N, E, D, Iqmp, P, Q *big.Int
+		}{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]})...)
+	case *ecdsa.PrivateKey:
+		curve := map[elliptic.Curve]string{elliptic.P256(): "nistp256", elliptic.P384(): "nistp384", elliptic.P521(): "nistp521"}[k.Curve]
+		if curve == "" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		private = append(private, ssh.Marshal(struct {
+			Curve string
+			Q     []byte
+			D     *big.Int
+		}{curve, elliptic.Marshal(k.Curve, k.X, k.Y), k.D})...)
+	case ed25519.PrivateKey:
+		private = append(private, ssh.Marshal(struct {
+			Pub, Priv []byte
+		}{k.Public().(ed25519.PublicKey), k})...)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	private = append(private, ssh.Marshal(struct{ Comment string }{""})...)
+
+	out := openSSHKey{CipherName: "none", KDFName: "none", NumKeys: 1, PublicKey: publicKey.Marshal()}
+	blockSize := 8
+	var stream cipher.Stream
+	if len(password) > 0 {
+		kdf := openSSHKDFOptions{Salt: make([]byte, 16), Rounds: openSSHKDFRounds}
+		if _, err = rand.Read(kdf.Salt); err != nil {
+			return nil, err
+		}
+		keyIV, err := bcryptPBKDF(password, kdf.Salt, int(kdf.Rounds), 32+aes.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(keyIV[:32])
+		if err != nil {
+			return nil, err
+		}
+		stream = cipher.NewCTR(block, keyIV[32:])
+		out.CipherName, out.KDFName, out.KDFOptions = "aes256-ctr", "bcrypt", string(ssh.Marshal(kdf))
+		blockSize = aes.BlockSize
+	}
+	for i := 1; len(private)%blockSize != 0; i++ {
+		private = append(private, byte(i))
+	}
+	if stream != nil {
+		stream.XORKeyStream(private, private)
+	}
+	out.PrivateBlock = private
+	return &pem.Block{Type: openSSHKeyType, Bytes: append([]byte(openSSHMagic), ssh.Marshal(out)...)}, nil
+}
+
+// bcryptPBKDF implements bcrypt_pbkdf(3) from OpenBSD, which openssh uses to
+// derive the key for encrypted private keys
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) ([]byte, error) {
+	const blockSize = 32
+	numBlocks := (keyLen + blockSize - 1) / blockSize
+	key := make([]byte, numBlocks*blockSize)
+
+	h := sha512.New()
+	h.Write(password)
+	shapass := h.Sum(nil)
+
+	shasalt := make([]byte, 0, sha512.Size)
+	cnt, tmp := make([]byte, 4), make([]byte, blockSize)
+	for block := 1; block <= numBlocks; block++ {
+		h.Reset()
+		h.Write(salt)
+		binary.BigEndian.PutUint32(cnt, uint32(block))
+		h.Write(cnt)
+		if err := bcryptHash(tmp, shapass, h.Sum(shasalt)); err != nil {
+			return nil, err
+		}
+
+		out := make([]byte, blockSize)
+		copy(out, tmp)
+		for i := 2; i <= rounds; i++ {
+			h.Reset()
+			h.Write(tmp)
+			if err := bcryptHash(tmp, shapass, h.Sum(shasalt)); err != nil {
+				return nil, err
+			}
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+		for i, v := range out {
+			key[i*numBlocks+(block-1)] = v
+		}
+	}
+	return key[:keyLen], nil
+}
+
+func bcryptHash(out, shapass, shasalt []byte) error {
+	c, err := blowfish.NewSaltedCipher(shapass, shasalt)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shasalt, c)
+		blowfish.ExpandKey(shapass, c)
+	}
+	copy(out, "OxychromaticBlowfishSwatDynamite")
+	for i := 0; i < 32; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// blowfish works on big endian words but bcrypt_pbkdf outputs little endian
+	for i := 0; i < 32; i += 4 {
+		out[i+3], out[i+2], out[i+1], out[i] = out[i], out[i+1], out[i+2], out[i+3]
+	}
+	return nil
+}
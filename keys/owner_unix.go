@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package keys
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner and group of a file
+func fileOwner(info os.FileInfo) (string, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return strconv.Itoa(int(stat.Uid)), strconv.Itoa(int(stat.Gid))
+}
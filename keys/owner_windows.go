@@ -0,0 +1,8 @@
+package keys
+
+import "os"
+
+// fileOwner is not supported on windows, where keys keep the default owner
+func fileOwner(info os.FileInfo) (string, string) {
+	return "", ""
+}
@@ -0,0 +1,160 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPasswordRequired is returned when parsing an encrypted key without a password
+var ErrPasswordRequired = errors.New("key is encrypted and no password was given")
+
+var privateKeyPEMTypes = map[string]bool{
+	"RSA PRIVATE KEY":  true,
+	"EC PRIVATE KEY":   true,
+	"PRIVATE KEY":      true,
+	encryptedPKCS8Type: true,
+	openSSHKeyType:     true,
+}
+
+// ParsePrivateKey reads a private key in PKCS#1, PKCS#8 or SEC1 form, as PEM
+// or DER. Keys encrypted with a PEM header or as encrypted PKCS#8 are
+// decrypted with password.
+func ParsePrivateKey(data, password []byte) (crypto.Signer, error) {
+	block, err := findPrivateKeyBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return parseDERPrivateKey(data)
+	}
+
+	if block.Type == openSSHKeyType {
+		return parseOpenSSHKey(pem.EncodeToMemory(block), password)
+	}
+
+	der := block.Bytes
+	switch {
+	case block.Type == encryptedPKCS8Type:
+		if len(password) == 0 {
+			return nil, ErrPasswordRequired
+		}
+		if der, err = decryptPKCS8(der, password); err != nil {
+			return nil, err
+		}
+	case x509.IsEncryptedPEMBlock(block):
+		if len(password) == 0 {
+			return nil, ErrPasswordRequired
+		}
+		if der, err = x509.DecryptPEMBlock(block, password); err != nil {
+			return nil, err
+		}
+	}
+	return parseDERPrivateKey(der)
+}
+
+// IsEncryptedKey reports whether a PEM private key needs a password to read
+func IsEncryptedKey(data []byte) bool {
+	block, err := findPrivateKeyBlock(data)
+	if err != nil || block == nil {
+		return false
+	}
+	if block.Type == openSSHKeyType {
+		_, err := ssh.ParseRawPrivateKey(pem.EncodeToMemory(block))
+		_, missing := err.(*ssh.PassphraseMissingError)
+		return missing
+	}
+	return block.Type == encryptedPKCS8Type || x509.IsEncryptedPEMBlock(block)
+}
+
+// MarshalPrivateKey encodes a key in the traditional PEM form for its type:
+// PKCS#1 for rsa, SEC1 for ecdsa and PKCS#8 for ed25519
+func MarshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// findPrivateKeyBlock returns the first private key in PEM data, skipping
+// blocks such as EC PARAMETERS, or nil if data is not PEM at all
+func findPrivateKeyBlock(data []byte) (*pem.Block, error) {
+	found := false
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		found = true
+		if privateKeyPEMTypes[block.Type] {
+			return block, nil
+		}
+		data = rest
+	}
+	if found {
+		return nil, errors.New("no private key found")
+	}
+	return nil, nil
+}
+
+func parseDERPrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unrecognized private key format")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+func parseOpenSSHKey(data, password []byte) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	if len(password) > 0 {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, password)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(data)
+	}
+	if _, missing := err.(*ssh.PassphraseMissingError); missing {
+		return nil, ErrPasswordRequired
+	} else if err != nil {
+		return nil, err
+	}
+	// the ssh package returns ed25519 keys by pointer
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		return *k, nil
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
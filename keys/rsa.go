@@ -1,11 +1,16 @@
 package keys
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io/ioutil"
+	"strconv"
 
 	"github.com/galenguyer/hancock/paths"
 )
@@ -19,11 +24,15 @@ func GenerateRsaKey(bits int) (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, bits)
 }
 
-func SaveRootRsaKey(key rsa.PrivateKey, password string, baseDir string) error {
-	keyPem := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(&key)}
+// SaveRootKey writes the root key in the traditional PEM form for its type,
+// encrypted with password if one is given
+func SaveRootKey(key crypto.Signer, password string, baseDir string) error {
+	keyPem, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
 
 	if password != "" {
-		var err error
 		keyPem, err = x509.EncryptPEMBlock(rand.Reader, keyPem.Type, keyPem.Bytes, []byte(password), x509.PEMCipherAES256)
 		if err != nil {
 			return err
@@ -31,73 +40,53 @@ func SaveRootRsaKey(key rsa.PrivateKey, password string, baseDir string) error {
 	}
 
 	bytes := pem.EncodeToMemory(keyPem)
-	err := ioutil.WriteFile(paths.GetRootRsaKeyPath(baseDir), bytes, 0600)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func SaveRsaKey(key rsa.PrivateKey, name string, baseDir string) error {
-	keyPem := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(&key)}
-	bytes := pem.EncodeToMemory(keyPem)
-	path, err := paths.GetRsaKeyPath(name, baseDir)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(path, bytes, 0600)
+	err = ioutil.WriteFile(paths.GetRootRsaKeyPath(baseDir), bytes, 0600)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetRootRsaKey(password, baseDir string) (*rsa.PrivateKey, error) {
+// GetRootKey loads the root key, which may be rsa, ecdsa or ed25519 in any
+// format ParsePrivateKey understands
+func GetRootKey(password, baseDir string) (crypto.Signer, error) {
 	bytes, err := ioutil.ReadFile(paths.GetRootRsaKeyPath(baseDir))
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(bytes)
-	if x509.IsEncryptedPEMBlock(block) {
-		der, err := x509.DecryptPEMBlock(block, []byte(password))
-		if err != nil {
-			return nil, err
-		}
-		key, err := x509.ParsePKCS1PrivateKey(der)
-		if err != nil {
-			return nil, err
-		}
-		return key, nil
-	} else {
-		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		return key, nil
-	}
+	return ParsePrivateKey(bytes, []byte(password))
 }
-func GetRsaKey(name, baseDir string) (*rsa.PrivateKey, error) {
-	rsaKeyPath, err := paths.GetRsaKeyPath(name, baseDir)
+
+// GetKey loads the private key saved for name
+func GetKey(name, password, baseDir string) (crypto.Signer, error) {
+	keyPath, err := paths.GetRsaKeyPath(name, baseDir)
 	if err != nil {
 		return nil, err
 	}
-	bytes, err := ioutil.ReadFile(rsaKeyPath)
+	bytes, err := ioutil.ReadFile(keyPath)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(bytes)
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	return ParsePrivateKey(bytes, []byte(password))
+}
+
+// Describe names a public key's algorithm and size, such as rsa-2048 or ecdsa-P-256
+func Describe(publicKey crypto.PublicKey) string {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa-" + strconv.Itoa(k.Size()*8)
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "ed25519"
 	}
-	return key, nil
+	return fmt.Sprintf("%T", publicKey)
 }
 
-func GetRootRsaKeyIsEncrypted(baseDir string) (bool, error) {
+func GetRootKeyIsEncrypted(baseDir string) (bool, error) {
 	bytes, err := ioutil.ReadFile(paths.GetRootRsaKeyPath(baseDir))
 	if err != nil {
 		return false, err
 	}
-	block, _ := pem.Decode(bytes)
-	return x509.IsEncryptedPEMBlock(block), nil
+	return IsEncryptedKey(bytes), nil
 }
@@ -0,0 +1,303 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/shamir"
+)
+
+const (
+	shareLinePrefix    = "HANCOCK-SHARE"
+	encryptedShareType = "HANCOCK ENCRYPTED SHARE"
+)
+
+// uppercase base32 without padding only uses characters from the qr code
+// alphanumeric set
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Share is one Shamir share of a root private key
+type Share struct {
+	Index     int
+	Threshold int
+	Parts     int
+	KeyID     string
+	Data      []byte
+}
+
+// SplitKey splits a private key into parts shares, any threshold of which
+// can reconstruct it
+func SplitKey(key crypto.Signer, keyID string, threshold, parts int) ([]Share, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := shamir.Split(der, threshold, parts)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]Share, parts)
+	for i := range data {
+		shares[i] = Share{Index: i + 1, Threshold: threshold, Parts: parts, KeyID: strings.ToUpper(keyID), Data: data[i]}
+	}
+	return shares, nil
+}
+
+// CombineKey reconstructs a private key from its shares
+func CombineKey(shares []Share) (crypto.Signer, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	var data [][]byte
+	for _, share := range shares {
+		if share.KeyID != shares[0].KeyID {
+			return nil, fmt.Errorf("share %d is for key %s, not %s", share.Index, share.KeyID, shares[0].KeyID)
+		}
+		data = append(data, share.Data)
+	}
+	if len(shares) < shares[0].Threshold {
+		return nil, fmt.Errorf("%d shares are needed but only %d were given", shares[0].Threshold, len(shares))
+	}
+	der, err := shamir.Combine(data)
+	if err != nil {
+		return nil, err
+	}
+	// shares made before keys other than rsa were supported hold PKCS#1
+	key, err := parseDERPrivateKey(der)
+	if err != nil {
+		return nil, errors.New("shares did not combine into a valid key")
+	}
+	return key, nil
+}
+
+// Line encodes the share as a single line that fits in a qr code's
+// alphanumeric mode, ending in a crc32 checksum
+func (s Share) Line() string {
+	line := fmt.Sprintf("%s:%d:%d:%d:%s:%s", shareLinePrefix, s.Index, s.Threshold, s.Parts, s.KeyID, shareEncoding.EncodeToString(s.Data))
+	return fmt.Sprintf("%s:%08X", line, crc32.ChecksumIEEE([]byte(line)))
+}
+
+// Paper formats the share for printing, with the data in short groups for
+// manual transcription followed by the single line form
+func (s Share) Paper() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "hancock root key share %d of %d, %d needed to reconstruct\n", s.Index, s.Parts, s.Threshold)
+	fmt.Fprintf(&buf, "key id: %s\n", s.KeyID)
+	fmt.Fprintf(&buf, "created: %s\n\n", time.Now().Format("2006-01-02"))
+
+	encoded := shareEncoding.EncodeToString(s.Data)
+	for line := 1; len(encoded) > 0; line++ {
+		n := 32
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		chunk := encoded[:n]
+		encoded = encoded[n:]
+		var groups []string
+		for len(chunk) > 0 {
+			g := 4
+			if g > len(chunk) {
+				g = len(chunk)
+			}
+			groups = append(groups, chunk[:g])
+			chunk = chunk[g:]
+		}
+		fmt.Fprintf(&buf, "%03d  %s\n", line, strings.Join(groups, " "))
+	}
+	fmt.Fprintf(&buf, "\n%s\n", s.Line())
+	return buf.Bytes()
+}
+
+// ParseShare reads a share from its paper or single line form
+func ParseShare(data []byte) (*Share, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, shareLinePrefix+":") {
+			return parseShareLine(line)
+		}
+	}
+	return nil, errors.New("no share found")
+}
+
+func parseShareLine(line string) (*Share, error) {
+	i := strings.LastIndex(line, ":")
+	checksum, err := strconv.ParseUint(line[i+1:], 16, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE([]byte(line[:i])) {
+		return nil, errors.New("share checksum does not match, check it was transcribed correctly")
+	}
+	fields := strings.Split(line[:i], ":")
+	if len(fields) != 6 {
+		return nil, errors.New("invalid share")
+	}
+	share := &Share{KeyID: fields[4]}
+	for j, v := range []*int{&share.Index, &share.Threshold, &share.Parts} {
+		if *v, err = strconv.Atoi(fields[j+1]); err != nil {
+			return nil, errors.New("invalid share")
+		}
+	}
+	if share.Data, err = shareEncoding.DecodeString(fields[5]); err != nil {
+		return nil, fmt.Errorf("invalid share data: %v", err)
+	}
+	return share, nil
+}
+
+// EncryptShare encrypts the paper form of a share to a custodian's rsa
+// public key, using RSA-OAEP to wrap an AES-256-GCM key
+func EncryptShare(share Share, recipient *rsa.PublicKey) ([]byte, error) {
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, aesKey, []byte(encryptedShareType))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// wrapped key length, wrapped key, nonce and ciphertext
+	body := make([]byte, 2, 2+len(wrappedKey)+len(nonce))
+	binary.BigEndian.PutUint16(body, uint16(len(wrappedKey)))
+	body = append(body, wrappedKey...)
+	body = append(body, nonce...)
+	body = gcm.Seal(body, nonce, share.Paper(), nil)
+
+	spki, err := x509.MarshalPKIXPublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(spki)
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedShareType,
+		Headers: map[string]string{
+			"Share":     fmt.Sprintf("%d of %d, %d needed", share.Index, share.Parts, share.Threshold),
+			"Key-Id":    share.KeyID,
+			"Recipient": "sha256:" + hex.EncodeToString(fingerprint[:]),
+		},
+		Bytes: body,
+	}), nil
+}
+
+// IsEncryptedShare reports whether data holds an encrypted share
+func IsEncryptedShare(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == encryptedShareType
+}
+
+// DecryptShare decrypts a share with the custodian's private key
+func DecryptShare(data []byte, identity *rsa.PrivateKey) (*Share, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedShareType {
+		return nil, errors.New("not an encrypted share")
+	}
+	body := block.Bytes
+	if len(body) < 2 {
+		return nil, errors.New("invalid encrypted share")
+	}
+	keyLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+keyLen {
+		return nil, errors.New("invalid encrypted share")
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, identity, body[2:2+keyLen], []byte(encryptedShareType))
+	if err != nil {
+		return nil, err
+	}
+	cipherBlock, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(cipherBlock)
+	if err != nil {
+		return nil, err
+	}
+	rest := body[2+keyLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted share")
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParseShare(plaintext)
+}
+
+// ReadRsaPublicKey reads an rsa public key from a PEM public key or certificate
+func ReadRsaPublicKey(path string) (*rsa.PublicKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found in %s", path)
+	}
+	var publicKey interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an rsa public key", path)
+	}
+	return rsaKey, nil
+}
+
+// ReadRsaPrivateKey reads an unencrypted rsa private key in any format
+// ParsePrivateKey understands
+func ReadRsaPrivateKey(path string) (*rsa.PrivateKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(bytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an rsa private key", path)
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,95 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestSplitCombineKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, "0123456789abcdef", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shares go through their paper form, as custodians keep them
+	var parsed []Share
+	for _, share := range shares {
+		p, err := ParseShare(share.Paper())
+		if err != nil {
+			t.Fatalf("share %d: %v", share.Index, err)
+		}
+		parsed = append(parsed, *p)
+	}
+	for _, subset := range [][]int{{0, 1, 2}, {0, 2, 4}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var given []Share
+		for _, i := range subset {
+			given = append(given, parsed[i])
+		}
+		combined, err := CombineKey(given)
+		if err != nil {
+			t.Fatalf("shares %v: %v", subset, err)
+		}
+		if !key.Equal(combined) {
+			t.Fatalf("shares %v: did not reconstruct the key", subset)
+		}
+	}
+
+	if _, err = CombineKey(parsed[:2]); err == nil {
+		t.Error("combining two of three shares did not fail")
+	}
+	other, err := SplitKey(key, "fedcba9876543210", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CombineKey([]Share{parsed[0], parsed[1], other[2]}); err == nil {
+		t.Error("combining shares of different keys did not fail")
+	}
+}
+
+func TestEncryptedShare(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, "0123456789abcdef", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncryptShare(shares[0], &identity.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedShare(data) {
+		t.Fatal("encrypted share is not recognised")
+	}
+	share, err := DecryptShare(data, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined, err := CombineKey([]Share{*share, shares[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(combined) {
+		t.Fatal("decrypted share did not reconstruct the key")
+	}
+
+	wrong, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecryptShare(data, wrong); err == nil {
+		t.Error("decrypting with the wrong identity did not fail")
+	}
+}
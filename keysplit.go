@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+var keyCommand = &cli.Command{
+	Name:  "key",
+	Usage: "manage the root private key",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "split",
+			Usage: "export the root key as shamir shares",
+			Flags: append(splitFlags,
+				&cli.StringFlag{
+					Name:    "password",
+					Aliases: []string{"p"},
+					Value:   "",
+				},
+			),
+			Action: func(c *cli.Context) error {
+				return SplitRootKey(c.String("split"), c.String("share-dir"), c.StringSlice("recipient"), c.Bool("remove-key"), c.String("password"), c.String("basedir"))
+			},
+		},
+		{
+			Name:      "combine",
+			Usage:     "reconstruct the root key from shamir shares and run a command with it, such as renew",
+			ArgsUsage: "[command]...",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "share",
+					Usage: "share file, given once for each share",
+				},
+				&cli.StringSliceFlag{
+					Name:  "identity",
+					Usage: "rsa private key of a custodian, to decrypt encrypted shares",
+				},
+				&cli.BoolFlag{
+					Name:  "save",
+					Usage: "write the reconstructed key to the base directory instead of running a command",
+				},
+				&cli.StringFlag{
+					Name:    "password",
+					Aliases: []string{"p"},
+					Usage:   "password to encrypt the saved key with",
+					Value:   "",
+				},
+				&cli.BoolFlag{
+					Name:  "no-password",
+					Value: false,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				// the command is run by the top level app, not the one for key
+				app := c.App
+				for _, parent := range c.Lineage() {
+					if parent.App != nil {
+						app = parent.App
+					}
+				}
+				run := func(command []string) error {
+					return app.Run(append([]string{app.Name}, command...))
+				}
+				return CombineRootKey(c.StringSlice("share"), c.StringSlice("identity"), c.Args().Slice(), run, c.Bool("save"), c.String("password"), c.Bool("no-password"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+// flags shared by init and key split
+var splitFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "split",
+		Usage: "split the root key into shares, such as 3-of-5",
+	},
+	&cli.StringFlag{
+		Name:  "share-dir",
+		Usage: "directory to write shares to",
+		Value: ".",
+	},
+	&cli.StringSliceFlag{
+		Name:  "recipient",
+		Usage: "rsa public key or certificate of a custodian to encrypt a share to, one per share in order",
+	},
+	&cli.BoolFlag{
+		Name:  "remove-key",
+		Usage: "delete the root key from the base directory once the shares are written",
+	},
+}
+
+func parseSplit(spec string) (int, int, error) {
+	parts := strings.Split(spec, "-of-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid split %q, expected N-of-M", spec)
+	}
+	threshold, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid split %q, expected N-of-M", spec)
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid split %q, expected N-of-M", spec)
+	}
+	return threshold, total, nil
+}
+
+func keyID(key crypto.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(spki)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func SplitRootKey(spec, shareDir string, recipients []string, removeKey bool, password, baseDir string) (err error) {
+	details := map[string]string{"split": spec, "encrypted": strconv.FormatBool(len(recipients) > 0)}
+	defer func() {
+		err = audited(baseDir, "key.split", details, err)
+	}()
+
+	threshold, total, err := parseSplit(spec)
+	if err != nil {
+		return err
+	}
+	if len(recipients) > 0 && len(recipients) != total {
+		return fmt.Errorf("%d recipients given for %d shares", len(recipients), total)
+	}
+	var recipientKeys []*rsa.PublicKey
+	for _, recipient := range recipients {
+		key, err := keys.ReadRsaPublicKey(recipient)
+		if err != nil {
+			return err
+		}
+		recipientKeys = append(recipientKeys, key)
+	}
+
+	key, err := unlockRootKey(password, baseDir)
+	if err != nil {
+		return err
+	}
+	id, err := keyID(key.Public())
+	if err != nil {
+		return err
+	}
+	details["key_id"] = id
+	shares, err := keys.SplitKey(key, id, threshold, total)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(shareDir, 0700); err != nil {
+		return err
+	}
+	for i, share := range shares {
+		var path string
+		var data []byte
+		if len(recipientKeys) > 0 {
+			path = filepath.Join(shareDir, fmt.Sprintf("share-%d-of-%d.pem", share.Index, share.Parts))
+			if data, err = keys.EncryptShare(share, recipientKeys[i]); err != nil {
+				return err
+			}
+			fmt.Printf("wrote share %d encrypted to %s to %s\n", share.Index, recipients[i], path)
+		} else {
+			path = filepath.Join(shareDir, fmt.Sprintf("share-%d-of-%d.txt", share.Index, share.Parts))
+			data = share.Paper()
+			fmt.Printf("wrote share %d to %s\n", share.Index, path)
+		}
+		if err = ioutil.WriteFile(path, data, 0600); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("any %d of the %d shares reconstruct root key %s\n", threshold, total, id)
+
+	if removeKey {
+		if err = os.Remove(paths.GetRootRsaKeyPath(baseDir)); err != nil {
+			return err
+		}
+		details["removed"] = "true"
+		fmt.Println("removed the root key, use hancock key combine to run commands with it")
+	}
+	return nil
+}
+
+// CombineRootKey reconstructs the root key from shares. The key is only kept
+// in memory while run runs command, unless save writes it to the base
+// directory.
+func CombineRootKey(files, identities, command []string, run func([]string) error, save bool, password string, noPassword bool, baseDir string) (err error) {
+	details := map[string]string{"shares": strconv.Itoa(len(files)), "saved": strconv.FormatBool(save)}
+	defer func() {
+		err = audited(baseDir, "key.combine", details, err)
+	}()
+
+	if save && len(command) > 0 {
+		return errors.New("either save the key or run a command with it, not both")
+	}
+	if !save && len(command) == 0 {
+		return errors.New("give a command to run with the reconstructed key, or --save to write it to the base directory")
+	}
+	if len(files) == 0 {
+		return errors.New("give each share with --share")
+	}
+	if _, err = os.Stat(paths.GetRootRsaKeyPath(baseDir)); save && err == nil {
+		return errors.New("not overwriting root rsa key")
+	}
+	var identityKeys []*rsa.PrivateKey
+	for _, identity := range identities {
+		key, err := keys.ReadRsaPrivateKey(identity)
+		if err != nil {
+			return err
+		}
+		identityKeys = append(identityKeys, key)
+	}
+
+	var shares []keys.Share
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var share *keys.Share
+		if keys.IsEncryptedShare(data) {
+			for _, identity := range identityKeys {
+				if share, err = keys.DecryptShare(data, identity); err == nil {
+					break
+				}
+			}
+			if share == nil {
+				return fmt.Errorf("%s: no identity can decrypt this share", file)
+			}
+		} else if share, err = keys.ParseShare(data); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		shares = append(shares, *share)
+	}
+	key, err := keys.CombineKey(shares)
+	if err != nil {
+		return err
+	}
+	id, err := keyID(key.Public())
+	if err != nil {
+		return err
+	}
+	details["key_id"] = id
+
+	// make sure the shares belong to this ca
+	if rootCACert, err := certs.GetRootCACert(baseDir); err == nil {
+		if !certs.KeyMatchesCert(key, rootCACert) {
+			return errors.New("reconstructed key does not match the root ca certificate")
+		}
+	}
+
+	if !save {
+		details["command"] = command[0]
+		fmt.Printf("reconstructed root key %s from %d shares, running %s with it\n", id, len(shares), command[0])
+		sharedRootKey = key
+		defer func() { sharedRootKey = nil }()
+		return run(command)
+	}
+
+	password, err = readNewPassword(password, noPassword)
+	if err != nil {
+		return err
+	}
+	if err = paths.CreateDirectories(baseDir); err != nil {
+		return err
+	}
+	if err = keys.SaveRootKey(key, password, baseDir); err != nil {
+		return err
+	}
+	fmt.Printf("restored root key %s from %d shares\n", id, len(shares))
+	return nil
+}
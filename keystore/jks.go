@@ -0,0 +1,305 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic   = 0xFEEDFEED
+	jksVersion = 2
+
+	tagPrivateKey  = 1
+	tagTrustedCert = 2
+
+	certTypeX509 = "X.509"
+)
+
+var (
+	// the keystore integrity check mixes this into the password digest
+	jksWhitener = []byte("Mighty Aphrodite")
+	// sun's own key protection algorithm, the only one jks keystores support
+	oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+)
+
+// DefaultPassword is the password java ships its cacerts truststore with
+const DefaultPassword = "changeit"
+
+// KeyStore holds the entries of a java keystore. Private key entries are kept
+// as they were read, still encrypted, so a keystore can be changed without
+// the passwords of its keys.
+type KeyStore struct {
+	Entries []Entry
+}
+
+// Entry is either a trusted certificate or an encrypted private key with its
+// certificate chain
+type Entry struct {
+	// Alias is lowercase, as java compares aliases without case
+	Alias   string
+	Created time.Time
+	// Cert is the der certificate of a trusted certificate entry
+	Cert []byte
+	// PrivateKey is the encrypted key of a private key entry, and Chain its
+	// der certificates
+	PrivateKey []byte
+	Chain      [][]byte
+}
+
+// IsJKS reports whether data starts like a jks keystore
+func IsJKS(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic
+}
+
+// SetTrustedCert adds cert under alias, replacing any entry already there
+func (ks *KeyStore) SetTrustedCert(alias string, cert *x509.Certificate) {
+	ks.Delete(alias)
+	ks.Entries = append(ks.Entries, Entry{Alias: strings.ToLower(alias), Created: time.Now(), Cert: cert.Raw})
+}
+
+// SetPrivateKey adds key and its chain, leaf first, under alias, protecting
+// the key with password and replacing any entry already there
+func (ks *KeyStore) SetPrivateKey(alias string, key crypto.Signer, chain []*x509.Certificate, password string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	protected, err := protectKey(der, password)
+	if err != nil {
+		return err
+	}
+	entry := Entry{Alias: strings.ToLower(alias), Created: time.Now(), PrivateKey: protected}
+	for _, cert := range chain {
+		entry.Chain = append(entry.Chain, cert.Raw)
+	}
+	ks.Delete(alias)
+	ks.Entries = append(ks.Entries, entry)
+	return nil
+}
+
+// protectKey encrypts a pkcs#8 key as java's KeyProtector does: xored with
+// a stream of sha-1 digests of the password, seeded by a random salt, and
+// followed by a digest of the password and key to check it with
+func protectKey(der []byte, password string) ([]byte, error) {
+	passwordBytes := utf16Bytes(password)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	protected := append([]byte{}, salt...)
+	digest := salt
+	for i := 0; i < len(der); i += sha1.Size {
+		h := sha1.New()
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(der); j++ {
+			protected = append(protected, der[i+j]^digest[j])
+		}
+	}
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write(der)
+	protected = h.Sum(protected)
+
+	return asn1.Marshal(struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// utf16Bytes encodes a password as java chars, big endian
+func utf16Bytes(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// Delete removes the entry for alias, reporting whether there was one
+func (ks *KeyStore) Delete(alias string) bool {
+	alias = strings.ToLower(alias)
+	for i, entry := range ks.Entries {
+		if entry.Alias == alias {
+			ks.Entries = append(ks.Entries[:i], ks.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeJKS reads a jks keystore, checking its integrity if password is set
+func DecodeJKS(data []byte, password string) (*KeyStore, error) {
+	if len(data) < 12+sha1.Size || !IsJKS(data) {
+		return nil, errors.New("not a jks keystore")
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if password != "" && !bytes.Equal(jksDigest(body, password), digest) {
+		return nil, errors.New("keystore password was incorrect or the keystore is corrupt")
+	}
+
+	r := bytes.NewReader(body[4:])
+	var version, count uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != 1 && version != jksVersion {
+		return nil, fmt.Errorf("unsupported jks version %d", version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	ks := &KeyStore{}
+	for i := uint32(0); i < count; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, err
+		}
+		alias, err := readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		var millis int64
+		if err = binary.Read(r, binary.BigEndian, &millis); err != nil {
+			return nil, err
+		}
+		entry := Entry{Alias: alias, Created: time.Unix(0, millis*int64(time.Millisecond))}
+		switch tag {
+		case tagPrivateKey:
+			if entry.PrivateKey, err = readBytes(r); err != nil {
+				return nil, err
+			}
+			var chainLength uint32
+			if err = binary.Read(r, binary.BigEndian, &chainLength); err != nil {
+				return nil, err
+			}
+			for j := uint32(0); j < chainLength; j++ {
+				cert, err := readCert(r, version)
+				if err != nil {
+					return nil, err
+				}
+				entry.Chain = append(entry.Chain, cert)
+			}
+		case tagTrustedCert:
+			if entry.Cert, err = readCert(r, version); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported jks entry type %d", tag)
+		}
+		ks.Entries = append(ks.Entries, entry)
+	}
+	return ks, nil
+}
+
+// EncodeJKS writes the keystore in the jks format, protected by password
+func (ks *KeyStore) EncodeJKS(password string) ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&buf, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(ks.Entries)))
+	for _, entry := range ks.Entries {
+		tag := uint32(tagTrustedCert)
+		if entry.PrivateKey != nil {
+			tag = tagPrivateKey
+		}
+		binary.Write(&buf, binary.BigEndian, tag)
+		if err := writeUTF(&buf, entry.Alias); err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.BigEndian, entry.Created.UnixNano()/int64(time.Millisecond))
+		if tag == tagPrivateKey {
+			binary.Write(&buf, binary.BigEndian, uint32(len(entry.PrivateKey)))
+			buf.Write(entry.PrivateKey)
+			binary.Write(&buf, binary.BigEndian, uint32(len(entry.Chain)))
+			for _, cert := range entry.Chain {
+				writeCert(&buf, cert)
+			}
+		} else {
+			writeCert(&buf, entry.Cert)
+		}
+	}
+	return append(buf.Bytes(), jksDigest(buf.Bytes(), password)...), nil
+}
+
+// jksDigest is the sha-1 of the password as utf-16, the whitener and the
+// keystore contents
+func jksDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(utf16Bytes(password))
+	h.Write(jksWhitener)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+func readCert(r *bytes.Reader, version uint32) ([]byte, error) {
+	if version == jksVersion {
+		certType, err := readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		if certType != certTypeX509 {
+			return nil, fmt.Errorf("unsupported certificate type %s", certType)
+		}
+	}
+	return readBytes(r)
+}
+
+func writeCert(w *bytes.Buffer, cert []byte) {
+	writeUTF(w, certTypeX509)
+	binary.Write(w, binary.BigEndian, uint32(len(cert)))
+	w.Write(cert)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// readUTF reads a string as java's DataInput.readUTF writes it, which is
+// utf-8 for the aliases in practice
+func readUTF(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func writeUTF(w *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("alias %q is too long", s)
+	}
+	binary.Write(w, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+	return nil
+}
@@ -0,0 +1,140 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestEncodeJKSKnownAnswer(t *testing.T) {
+	// an empty keystore is its header and the digest of it
+	data, err := (&KeyStore{}).EncodeJKS(DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(data), "feedfeed0000000200000000e2686e45fb43dfa4d992dd41ceb6b21c6330d792"; got != want {
+		t.Errorf("empty keystore = %s, want %s", got, want)
+	}
+
+	ks := &KeyStore{Entries: []Entry{{
+		Alias:   "hancock-test",
+		Created: time.Unix(1700000000, 0),
+		Cert:    parseTestCert(t).Raw,
+	}}}
+	if data, err = ks.EncodeJKS(DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if got, want := hex.EncodeToString(sum[:]), "ba924423e67c006ff1b4b757344d67ffc8dc955f45f789db3a41c1d6b64d39f6"; got != want {
+		t.Errorf("sha-256 of keystore with one trusted certificate = %s, want %s", got, want)
+	}
+}
+
+// recoverKey reverses protectKey as java's KeyProtector does
+func recoverKey(t *testing.T, protected []byte, password string) []byte {
+	var info struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}
+	if _, err := asn1.Unmarshal(protected, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		t.Fatalf("key is protected with %v", info.Algorithm.Algorithm)
+	}
+	data := info.EncryptedData
+	salt, encrypted, check := data[:sha1.Size], data[sha1.Size:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	passwordBytes := utf16Bytes(password)
+	der := make([]byte, 0, len(encrypted))
+	digest := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New()
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			der = append(der, encrypted[i+j]^digest[j])
+		}
+	}
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write(der)
+	if !bytes.Equal(h.Sum(nil), check) {
+		t.Fatal("key integrity check failed")
+	}
+	return der
+}
+
+func TestJKSRoundTrip(t *testing.T) {
+	root := parseTestCert(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := &KeyStore{}
+	ks.SetTrustedCert("Root", root)
+	if err = ks.SetPrivateKey("Leaf", key, []*x509.Certificate{root}, "keypass"); err != nil {
+		t.Fatal(err)
+	}
+	ks.SetTrustedCert("ROOT", root)
+	data, err := ks.EncodeJKS("storepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = DecodeJKS(data, "wrong"); err == nil {
+		t.Error("decoding with the wrong password did not fail")
+	}
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)/2] ^= 1
+	if _, err = DecodeJKS(tampered, "storepass"); err == nil {
+		t.Error("decoding a changed keystore did not fail")
+	}
+	// without a password the integrity check is skipped
+	if _, err = DecodeJKS(data, ""); err != nil {
+		t.Errorf("decoding without a password: %v", err)
+	}
+
+	decoded, err := DecodeJKS(data, "storepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(decoded.Entries))
+	}
+	for _, entry := range decoded.Entries {
+		switch entry.Alias {
+		case "root":
+			if !bytes.Equal(entry.Cert, root.Raw) {
+				t.Error("trusted certificate does not match")
+			}
+		case "leaf":
+			if len(entry.Chain) != 1 || !bytes.Equal(entry.Chain[0], root.Raw) {
+				t.Error("certificate chain does not match")
+			}
+			want, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(recoverKey(t, entry.PrivateKey, "keypass"), want) {
+				t.Error("recovered key does not match")
+			}
+		default:
+			t.Errorf("unexpected alias %s", entry.Alias)
+		}
+	}
+
+	if !decoded.Delete("LEAF") || decoded.Delete("leaf") || len(decoded.Entries) != 1 {
+		t.Error("deleting the key entry did not remove exactly it")
+	}
+}
@@ -0,0 +1,511 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/galenguyer/hancock/keys"
+)
+
+// macIterations is used for the integrity mac, matching what current
+// versions of openssl write
+const macIterations = 2048
+
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidPBEWithSHA3DES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHARC2_40  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBEWithSHARC2_128 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	// java only trusts certificates in a pkcs#12 truststore that carry its
+	// trusted key usage attribute, set to any extended key usage
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// PKCS12 holds the bags of a pkcs#12 keystore. Private keys are kept as they
+// were read, still encrypted, so a keystore can be changed without the
+// passwords of its keys.
+type PKCS12 struct {
+	bags []safeBag
+}
+
+// DecodePKCS12 reads a pkcs#12 keystore, checking its mac and decrypting
+// its certificates with password
+func DecodePKCS12(data []byte, password string) (*PKCS12, error) {
+	var p pfx
+	if rest, err := asn1.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("not a pkcs#12 keystore: %v", err)
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after pkcs#12 keystore")
+	}
+	if p.Version != 3 {
+		return nil, fmt.Errorf("unsupported pkcs#12 version %d", p.Version)
+	}
+	if !p.AuthSafe.ContentType.Equal(oidData) {
+		return nil, errors.New("pkcs#12 keystores protected by public keys are not supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(p.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, err
+	}
+	if p.MacData.Mac.Algorithm.Algorithm != nil {
+		if err := verifyMac(p.MacData, authSafe, password); err != nil {
+			return nil, err
+		}
+	}
+
+	var safes []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &safes); err != nil {
+		return nil, err
+	}
+	ks := &PKCS12{}
+	for _, safe := range safes {
+		var contents []byte
+		switch {
+		case safe.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(safe.Content.Bytes, &contents); err != nil {
+				return nil, err
+			}
+		case safe.ContentType.Equal(oidEncryptedData):
+			var encrypted encryptedData
+			if _, err := asn1.Unmarshal(safe.Content.Bytes, &encrypted); err != nil {
+				return nil, err
+			}
+			var err error
+			info := encrypted.EncryptedContentInfo
+			if contents, err = decryptSafe(info.ContentEncryptionAlgorithm, info.EncryptedContent, password); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported pkcs#12 content type %v", safe.ContentType)
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+			return nil, err
+		}
+		ks.bags = append(ks.bags, bags...)
+	}
+	return ks, nil
+}
+
+// Encode writes the keystore protected by password. Certificates are
+// encrypted with PBES2 and AES-256, keys stay as they were encrypted, and
+// the whole is checked by an HMAC-SHA256 mac.
+func (ks *PKCS12) Encode(password string) ([]byte, error) {
+	var certBags, keyBags []safeBag
+	for _, bag := range ks.bags {
+		if bag.ID.Equal(oidKeyBag) || bag.ID.Equal(oidShroudedKeyBag) {
+			keyBags = append(keyBags, bag)
+		} else {
+			certBags = append(certBags, bag)
+		}
+	}
+
+	var safes []contentInfo
+	if len(certBags) > 0 {
+		contents, err := asn1.Marshal(certBags)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, ciphertext, err := keys.EncryptPBES2(contents, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := asn1.Marshal(encryptedData{
+			EncryptedContentInfo: encryptedContentInfo{
+				ContentType:                oidData,
+				ContentEncryptionAlgorithm: algorithm,
+				EncryptedContent:           ciphertext,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, contentInfo{ContentType: oidEncryptedData, Content: explicit(encrypted)})
+	}
+	if len(keyBags) > 0 {
+		safe, err := dataContentInfo(keyBags)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, safe)
+	}
+
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := macData{
+		Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		MacSalt:    salt,
+		Iterations: macIterations,
+	}
+	mac.Mac.Digest = computeMac(sha256.New, authSafe, salt, macIterations, password)
+	octets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicit(octets)},
+		MacData:  mac,
+	})
+}
+
+// SetTrustedCert adds cert under alias, marked as trusted for java,
+// replacing any entry already there
+func (ks *PKCS12) SetTrustedCert(alias string, cert *x509.Certificate) {
+	// marshalling a fixed oid and a parsed certificate cannot fail
+	trusted, _ := asn1.Marshal(oidAnyExtendedKeyUsage)
+	bag, _ := newCertBag(cert, friendlyNameAttribute(alias), pkcs12Attribute{
+		ID:     oidJavaTrustedKeyUsage,
+		Values: []asn1.RawValue{{FullBytes: trusted}},
+	})
+	ks.Delete(alias)
+	ks.bags = append(ks.bags, bag)
+}
+
+// SetPrivateKey adds key and its chain, leaf first, under alias, encrypting
+// the key with password and replacing any entry already there
+func (ks *PKCS12) SetPrivateKey(alias string, key crypto.Signer, chain []*x509.Certificate, password string) error {
+	if len(chain) == 0 {
+		return errors.New("a private key entry needs a certificate")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	algorithm, ciphertext, err := keys.EncryptPBES2(der, []byte(password))
+	if err != nil {
+		return err
+	}
+	shrouded, err := asn1.Marshal(struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{algorithm, ciphertext})
+	if err != nil {
+		return err
+	}
+
+	// the key and its certificate are tied together by an id, which hashes
+	// the alias as well as the certificate so that the same certificate can
+	// be stored under two aliases
+	id := sha1.Sum(append([]byte(strings.ToLower(alias)), chain[0].Raw...))
+	localKeyID, err := asn1.Marshal(id[:])
+	if err != nil {
+		return err
+	}
+	attributes := []pkcs12Attribute{friendlyNameAttribute(alias), {ID: oidLocalKeyID, Values: []asn1.RawValue{{FullBytes: localKeyID}}}}
+
+	ks.Delete(alias)
+	ks.bags = append(ks.bags, safeBag{ID: oidShroudedKeyBag, Value: explicit(shrouded), Attributes: attributes})
+	leaf, err := newCertBag(chain[0], attributes...)
+	if err != nil {
+		return err
+	}
+	ks.bags = append(ks.bags, leaf)
+
+	// the rest of the chain is shared between entries, so only add what is
+	// not there already
+	for _, cert := range chain[1:] {
+		bag, err := newCertBag(cert)
+		if err != nil {
+			return err
+		}
+		if !ks.hasBag(bag) {
+			ks.bags = append(ks.bags, bag)
+		}
+	}
+	return nil
+}
+
+// hasBag reports whether a bag without attributes holding the same value is
+// in the keystore
+func (ks *PKCS12) hasBag(bag safeBag) bool {
+	for _, b := range ks.bags {
+		if len(b.Attributes) == 0 && b.ID.Equal(bag.ID) && bytes.Equal(b.Value.Bytes, bag.Value.Bytes) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the entry for alias, along with the certificate of a
+// private key, reporting whether there was one
+func (ks *PKCS12) Delete(alias string) bool {
+	var keyIDs [][]byte
+	for _, bag := range ks.bags {
+		if strings.EqualFold(bag.friendlyName(), alias) {
+			if id := bag.attribute(oidLocalKeyID); id != nil {
+				keyIDs = append(keyIDs, id)
+			}
+		}
+	}
+	found := false
+	bags := ks.bags[:0]
+	for _, bag := range ks.bags {
+		remove := strings.EqualFold(bag.friendlyName(), alias)
+		for _, id := range keyIDs {
+			remove = remove || bytes.Equal(bag.attribute(oidLocalKeyID), id)
+		}
+		if remove {
+			found = true
+			continue
+		}
+		bags = append(bags, bag)
+	}
+	ks.bags = bags
+	return found
+}
+
+func newCertBag(cert *x509.Certificate, attributes ...pkcs12Attribute) (safeBag, error) {
+	value, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidCertBag, Value: explicit(value), Attributes: attributes}, nil
+}
+
+func friendlyNameAttribute(alias string) pkcs12Attribute {
+	// asn1 has no way to marshal a BMPString, so build it by hand
+	var name []byte
+	for _, c := range utf16.Encode([]rune(alias)) {
+		name = append(name, byte(c>>8), byte(c))
+	}
+	return pkcs12Attribute{
+		ID:     oidFriendlyName,
+		Values: []asn1.RawValue{{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: name}},
+	}
+}
+
+// attribute returns the contents of the first value of an attribute
+func (b safeBag) attribute(id asn1.ObjectIdentifier) []byte {
+	for _, attribute := range b.Attributes {
+		if attribute.ID.Equal(id) && len(attribute.Values) > 0 {
+			return attribute.Values[0].Bytes
+		}
+	}
+	return nil
+}
+
+func (b safeBag) friendlyName() string {
+	name := b.attribute(oidFriendlyName)
+	chars := make([]uint16, len(name)/2)
+	for i := range chars {
+		chars[i] = uint16(name[2*i])<<8 | uint16(name[2*i+1])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// explicit wraps der in the [0] tag pkcs#12 uses for its contents
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	contents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	octets, err := asn1.Marshal(contents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}, nil
+}
+
+func verifyMac(mac macData, authSafe []byte, password string) error {
+	var newHash func() hash.Hash
+	switch algorithm := mac.Mac.Algorithm.Algorithm; {
+	case algorithm.Equal(oidSHA1):
+		newHash = sha1.New
+	case algorithm.Equal(oidSHA256):
+		newHash = sha256.New
+	default:
+		return fmt.Errorf("unsupported pkcs#12 mac algorithm %v", algorithm)
+	}
+	if !hmac.Equal(computeMac(newHash, authSafe, mac.MacSalt, mac.Iterations, password), mac.Mac.Digest) {
+		return errors.New("keystore password was incorrect or the keystore is corrupt")
+	}
+	return nil
+}
+
+func computeMac(newHash func() hash.Hash, data, salt []byte, iterations int, password string) []byte {
+	key := pkcs12KDF(newHash, bmpPassword(password), salt, iterations, 3, newHash().Size())
+	h := hmac.New(newHash, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// decryptSafe decrypts an encrypted safe, written either with PBES2, as
+// current tools do, or with the triple des scheme of older ones
+func decryptSafe(algorithm pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	switch {
+	case keys.IsPBES2(algorithm):
+		return keys.DecryptPBES2(algorithm, data, []byte(password))
+	case algorithm.Algorithm.Equal(oidPBEWithSHA3DES):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		bmp := bmpPassword(password)
+		block, err := des.NewTripleDESCipher(pkcs12KDF(sha1.New, bmp, params.Salt, params.Iterations, 1, 24))
+		if err != nil {
+			return nil, err
+		}
+		iv := pkcs12KDF(sha1.New, bmp, params.Salt, params.Iterations, 2, block.BlockSize())
+		if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+			return nil, errors.New("invalid encrypted data")
+		}
+		plaintext := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+		pad := int(plaintext[len(plaintext)-1])
+		if pad == 0 || pad > block.BlockSize() {
+			return nil, x509.IncorrectPasswordError
+		}
+		return plaintext[:len(plaintext)-pad], nil
+	case algorithm.Algorithm.Equal(oidPBEWithSHARC2_40), algorithm.Algorithm.Equal(oidPBEWithSHARC2_128):
+		return nil, errors.New("rc2 encrypted pkcs#12 keystores are not supported, convert it with openssl pkcs12 first")
+	default:
+		return nil, fmt.Errorf("unsupported pkcs#12 encryption %v", algorithm.Algorithm)
+	}
+}
+
+// bmpPassword encodes a password as the pkcs#12 key derivation expects, as
+// big endian utf-16 with a terminating null
+func bmpPassword(password string) []byte {
+	return append(utf16Bytes(password), 0, 0)
+}
+
+// pkcs12KDF derives size bytes of key material as in rfc 7292 appendix b.2,
+// where id selects a key (1), iv (2) or mac key (3)
+func pkcs12KDF(newHash func() hash.Hash, password, salt []byte, iterations int, id byte, size int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+
+	d := bytes.Repeat([]byte{id}, v)
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h.Reset()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// each block of i becomes (i + b + 1) mod 2^(8v), where b is a
+		// repeated to fill a block
+		b := new(big.Int).SetBytes(fill(a[:u])[:v])
+		b.Add(b, one)
+		for j := 0; j < len(i); j += v {
+			block := new(big.Int).SetBytes(i[j : j+v])
+			block.Add(block, b)
+			sum := block.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			copy(i[j:j+v], make([]byte, v-len(sum)))
+			copy(i[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
@@ -0,0 +1,193 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"hash"
+	"testing"
+)
+
+// a self-signed p-256 certificate made with openssl
+const testCert = `-----BEGIN CERTIFICATE-----
+MIIBhDCCASmgAwIBAgIUCyv7Png/s10UNqYjruwkNWNZVGcwCgYIKoZIzj0EAwIw
+FzEVMBMGA1UEAwwMaGFuY29jay10ZXN0MB4XDTI2MTAxOTA1MzgwN1oXDTM2MTAx
+NjA1MzgwN1owFzEVMBMGA1UEAwwMaGFuY29jay10ZXN0MFkwEwYHKoZIzj0CAQYI
+KoZIzj0DAQcDQgAEnjqRHKzaE4S0pTXTEfl0JuDpvE4qyDNqBQok+cDBOiEDV7rl
+ddLbmNAhv02fwnOdBldPZcpEeb8OLVSrI7Yrb6NTMFEwHQYDVR0OBBYEFDIGKWHo
+wKsQqMSma9UAwLRAWYRaMB8GA1UdIwQYMBaAFDIGKWHowKsQqMSma9UAwLRAWYRa
+MA8GA1UdEwEB/wQFMAMBAf8wCgYIKoZIzj0EAwIDSQAwRgIhAM5mJwwhtvww6lzm
+7Jmpm1HaihFgVl/dE+aOxIwesmoZAiEA1c52MjW5GY+Xzi6Qmh9Nwqy5mraJnl5Q
+dqbJ4dJamVw=
+-----END CERTIFICATE-----`
+
+// testCert exported by openssl pkcs12 -export -nokeys -caname trusted with
+// the password changeit, with openssl 3's defaults and with the legacy
+// triple des and sha-1 mac
+var testPKCS12Stores = map[string]string{
+	"aes-256-cbc": `MIICxwIBAzCCAn0GCSqGSIb3DQEHAaCCAm4EggJqMIICZjCCAmIGCSqGSIb3DQEHBqCCAlMwggJP
+AgEAMIICSAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjsTcNx4e1S
+YQICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEHx6o6ZiVmaUOnfqixYB+ZGAggHgUO5T
+wrNcqH56bXeE1hL8ZApy2Srf8CbWZgT6r1bIR/T0Bt9O7XbKGkBZefIkAVwUylLC+tnpOtWhB21y
+ECFOIyqEL2ebv8xZXIfK5XIRnfcxWQB1KQDDN3nodBqDyA2lIU/79+FYzO4UizacTB3grUv03aHJ
+W2sF0wnMsjxN1F7gDdEMIBgfe0qMgEKrVqL7o0ni49SmnRa4vOhI8laOLz3+u7rkpF4jbZ3uk5LA
+3k1HZTpy4jnQOkcQv0EsPQ+Qbi9RNfO0VlDcelnYR5MxozyyN7w42a5X/UMjZ/5RDwlNnvZYFJ5h
+8mpVZX65hmNCAUMryg/OGkTwYdxZV7xocJFOOBLAZNhAOfmKdNw4aqJciRQsqsxjgQURiHgo3p8P
+A4glNxMKwIv/rQ29PaCSwN1OyqoG7P4vBBCjZDA5x68iy+zTgak6PMXOzEUZfLJcFcdR/eoMJGUk
+O2ZuFVYTOFR9VXIqWX3c7ZylirnCOLsCIb7rHNcoqBUzlF5nEl0kvs4AbNqHHgtxduXFxTrMolMG
+RXEELJEwyF2tk7zwCjn1hE0gyiI4aYTAwztboTMsYq+DBjUXSvzx4sX2I6cmUF6ew6/btjLA4Zto
+yLZQJ1Hb4RJePrYIBsB8iv24ukDSMEEwMTANBglghkgBZQMEAgEFAAQg7ntnfsNcMHpnyri3Nc7O
+XU7aU9LCgWaozuOgqQep9rEECLeJi3r7P680AgIIAA==`,
+	"des-ede3-cbc": `MIICfAIBAzCCAkIGCSqGSIb3DQEHAaCCAjMEggIvMIICKzCCAicGCSqGSIb3DQEHBqCCAhgwggIU
+AgEAMIICDQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQINIWczANyrvkCAggAgIIB4NfAMCNC
+byNhmF+q9mfvyOitRoN+ft8FoA0i9WSjKnDboZbLj7snUklv43iImHsQ8uHiQhzoeH2XabFajbIZ
+D28EuY2kf4nYG/qBWjgpy36LiNFkYGjFk+WGSTjcNsW6CG1trSCvt05vdkvTWUZ4jNjzrFGkA9vL
+l8mPnGr4xXAqHtR5Pog4eLVSujf1PV9N42eosyJsDKwjPVoC60lfqi63bpgOQLoDEEXN9WpuahU7
+18fKmRgvwq1p8Lvlxk6XiBnboJSkgRucKRt+ZJtK1FqS2xEAli2wTlIwaN3dHOR4JGiZA7sWICFx
+QdwRw9sFq/+k2JcrXtXz3R1KvMCDWzJBVEPJd5bR5BNPmP8ulQe7f2/FesIGbmQLRdEr9MpP1Avd
+skMFlASWBeyAmBPDTu657nTJsaorL2WCKHkj987+0Cxz20mreEQ0LQjrKQNlh5vjK+9baDkuXzLn
+c6SHKqEyIpDOH0UOuulHb8EP1/XRYj5lXrmhKzyQAZ7eDJBMMDVpv1SJ4WiyO5Pmo5IQDgNSQBH2
+GjS17tYutmWg0VFXPBQrh9rZG8MpTVYgpjBdZ+zSgD97F29gmfALjbWFgkKh+fEPTLtoOcp6qSVp
+QtQAMnMxlgiJZxXS+UhiwhKCcTAxMCEwCQYFKw4DAhoFAAQU4SFkAEVzb3I0M7/iaF9cylqx/WUE
+CCUSzHD8n7ugAgIIAA==`,
+}
+
+func parseTestCert(t *testing.T) *x509.Certificate {
+	block, _ := pem.Decode([]byte(testCert))
+	if block == nil {
+		t.Fatal("invalid pem")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestPKCS12KDF(t *testing.T) {
+	for _, test := range []struct {
+		newHash    func() hash.Hash
+		password   []byte
+		salt       string
+		iterations int
+		id         byte
+		want       string
+	}{
+		{sha1.New, bmpPassword("sesame"), "ffffffffffffffff", 2048, 1, "7cd9fd3e2b3be7691a44e3bef0f9ea0fb9b897d4e325d9d1"},
+		{sha1.New, bmpPassword("sesame"), "ffffffffffffffff", 2048, 2, "3f5a277f9c21ff82"},
+		// i gets a leading zero byte, which big.Int drops
+		{sha1.New, []byte{0, 0}, "f37e05b518324b4b", 2048, 1, "00f759ff47d14dd03665d5943cb3c4a39a2555c02aed66e1"},
+		// more output than one hash, so i is updated between blocks
+		{sha1.New, bmpPassword("changeit"), "0102030405060708", 1, 1, "3414cb5dcc759ec8116a0c9b0c081f813126ee3ba3ce9a1c82c81510206368c1139065554a2c5220"},
+		{sha256.New, bmpPassword("changeit"), "0102030405060708090a0b0c0d0e0f10", 10000, 3, "97451a52b59e920d5c510cf2e2902652df9f1b5e71918505431ddc0f68e76cf9"},
+	} {
+		salt, err := hex.DecodeString(test.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := hex.DecodeString(test.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := pkcs12KDF(test.newHash, test.password, salt, test.iterations, test.id, len(want))
+		if !bytes.Equal(got, want) {
+			t.Errorf("pkcs12KDF(%x, %s, %d, %d) = %x, want %s", test.password, test.salt, test.iterations, test.id, got, test.want)
+		}
+	}
+}
+
+func TestDecodeOpenSSLPKCS12(t *testing.T) {
+	cert := parseTestCert(t)
+	for name, encoded := range testPKCS12Stores {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, err := DecodePKCS12(data, "changeit")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(ks.bags) != 1 || ks.bags[0].friendlyName() != "trusted" {
+			t.Errorf("%s: expected one bag named trusted", name)
+			continue
+		}
+		var bag certBag
+		if _, err = asn1.Unmarshal(ks.bags[0].Value.Bytes, &bag); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(bag.Data, cert.Raw) {
+			t.Errorf("%s: certificate does not match", name)
+		}
+		if _, err = DecodePKCS12(data, "wrong"); err == nil {
+			t.Errorf("%s: decoding with the wrong password did not fail", name)
+		}
+	}
+}
+
+func TestPKCS12RoundTrip(t *testing.T) {
+	root := parseTestCert(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafBytes, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: root.SerialNumber}, &x509.Certificate{SerialNumber: root.SerialNumber}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := &PKCS12{}
+	ks.SetTrustedCert("Root", root)
+	if err = ks.SetPrivateKey("leaf", key, []*x509.Certificate{leaf, root}, "keypass"); err != nil {
+		t.Fatal(err)
+	}
+	// replacing an entry leaves one copy of it
+	ks.SetTrustedCert("root", root)
+	data, err := ks.Encode("storepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecodePKCS12(data, "wrong"); err == nil {
+		t.Error("decoding with the wrong password did not fail")
+	}
+	decoded, err := DecodePKCS12(data, "storepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key, its certificate, the root of its chain and the trusted root
+	names := map[string]int{}
+	for _, bag := range decoded.bags {
+		names[bag.friendlyName()]++
+	}
+	if len(decoded.bags) != 4 || names["root"] != 1 || names["leaf"] != 2 || names[""] != 1 {
+		t.Fatalf("unexpected bags %v", names)
+	}
+	for _, bag := range decoded.bags {
+		if bag.friendlyName() == "root" && bag.attribute(oidJavaTrustedKeyUsage) == nil {
+			t.Error("trusted certificate is not marked as trusted for java")
+		}
+	}
+
+	if !decoded.Delete("LEAF") {
+		t.Fatal("deleting the key entry found nothing")
+	}
+	if len(decoded.bags) != 2 {
+		t.Errorf("%d bags left after deleting the key entry, want 2", len(decoded.bags))
+	}
+	if decoded.Delete("leaf") {
+		t.Error("deleting the key entry twice found it again")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// flags shared by new and renew for how leaf keys are written
+var keyOutputFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "key-password",
+		Usage: "password for an encrypted existing key, and to encrypt the written key with",
+	},
+	&cli.StringFlag{
+		Name:  "key-password-file",
+		Usage: "read the key password from a file instead",
+	},
+	&cli.BoolFlag{
+		Name:  "encrypt-key",
+		Usage: "encrypt the private key, with the key password or $HANCOCK_KEY_PASSWORD, prompting if neither is set",
+	},
+	&cli.StringFlag{
+		Name:  "key-format",
+		Usage: "format to write the private key in: pkcs1, pkcs8, sec1 or openssh",
+	},
+	&cli.StringFlag{
+		Name:  "key-mode",
+		Usage: "file mode for the private key, such as 0640",
+	},
+	&cli.StringFlag{
+		Name:  "key-owner",
+		Usage: "user to own the private key file",
+	},
+	&cli.StringFlag{
+		Name:  "key-group",
+		Usage: "group to own the private key file",
+	},
+}
+
+// LeafKey says where the private key for a certificate comes from: an
+// existing file, the key already saved for the name, or a newly generated
+// one, and how it is written. Output fields left empty keep what the key
+// already saved for the name uses.
+type LeafKey struct {
+	Type     string
+	Bits     int
+	Curve    string
+	File     string
+	Reuse    bool
+	Password string
+	// Encrypt is nil to keep encrypting the key only if the saved one was
+	Encrypt *bool
+	Output  keys.KeyOutput
+}
+
+// leafKeyFromFlags builds a LeafKey from the flags of new or renew, taking
+// anything not given on the command line from the profile
+func leafKeyFromFlags(c *cli.Context) (LeafKey, error) {
+	conf, err := config.Load(c.String("basedir"))
+	if err != nil {
+		return LeafKey{}, err
+	}
+	profile, err := conf.GetProfile(c.String("profile"))
+	if err != nil {
+		return LeafKey{}, err
+	}
+	k := LeafKey{
+		Type:  profile.Key.Type,
+		Bits:  profile.Key.Bits,
+		Curve: profile.Key.Curve,
+		File:  c.String("key"),
+		Reuse: c.Bool("reuse-key"),
+		Output: keys.KeyOutput{
+			Format: profile.Key.Format,
+			Owner:  profile.Key.Owner,
+			Group:  profile.Key.Group,
+		},
+	}
+	if profile.Key.Encrypt {
+		k.Encrypt = &profile.Key.Encrypt
+	}
+	mode := profile.Key.Mode
+
+	for flag, value := range map[string]*string{
+		"key-type":   &k.Type,
+		"curve":      &k.Curve,
+		"key-format": &k.Output.Format,
+		"key-mode":   &mode,
+		"key-owner":  &k.Output.Owner,
+		"key-group":  &k.Output.Group,
+	} {
+		if c.IsSet(flag) {
+			*value = c.String(flag)
+		}
+	}
+	if c.IsSet("bits") || k.Bits == 0 {
+		k.Bits = c.Int("bits")
+	}
+	if c.IsSet("encrypt-key") {
+		encrypt := c.Bool("encrypt-key")
+		k.Encrypt = &encrypt
+	}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return LeafKey{}, fmt.Errorf("invalid key mode %q", mode)
+		}
+		k.Output.Mode = os.FileMode(m)
+	}
+
+	// the key password comes from the flag, a file or the environment
+	k.Password = c.String("key-password")
+	if k.Password == "" && c.String("key-password-file") != "" {
+		bytes, err := ioutil.ReadFile(c.String("key-password-file"))
+		if err != nil {
+			return LeafKey{}, err
+		}
+		k.Password = strings.TrimRight(string(bytes), "\r\n")
+	}
+	if k.Password == "" {
+		k.Password = os.Getenv("HANCOCK_KEY_PASSWORD")
+	}
+	return k, nil
+}
+
+// like returns a copy of k that generates keys of the same type and size
+// as publicKey, for renewing a certificate
+func (k LeafKey) like(publicKey crypto.PublicKey) LeafKey {
+	switch p := publicKey.(type) {
+	case *rsa.PublicKey:
+		k.Type, k.Bits = keys.TypeRSA, p.Size()*8
+	case *ecdsa.PublicKey:
+		k.Type, k.Curve = keys.TypeECDSA, p.Curve.Params().Name
+	case ed25519.PublicKey:
+		k.Type = keys.TypeEd25519
+	}
+	return k
+}
+
+// load returns the key to issue a certificate for name with, staging it as
+// the pending key for name, and whether it was generated, read from a file
+// or reused. The pending key replaces the saved one with promoteKey once the
+// certificate for it is saved.
+func (k LeafKey) load(name, baseDir string) (crypto.Signer, string, error) {
+	if k.File != "" && k.Reuse {
+		return nil, "", errors.New("use either --key or --reuse-key, not both")
+	}
+	keyPath, err := paths.GetRsaKeyPath(name, baseDir)
+	if err != nil {
+		return nil, "", err
+	}
+	pendingPath, err := paths.GetPendingKeyPath(name, baseDir)
+	if err != nil {
+		return nil, "", err
+	}
+	out, encrypt := k.output(keyPath)
+
+	var key crypto.Signer
+	var keyBytes []byte
+	source := "generated"
+	switch {
+	case k.File != "":
+		key, keyBytes, err = readKey(k.File, k.Password)
+		if err != nil {
+			return nil, "", err
+		}
+		source = "file"
+	case k.Reuse:
+		key, keyBytes, err = readKey(keyPath, k.Password)
+		if os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("no existing key for %s to reuse", name)
+		} else if err != nil {
+			return nil, "", err
+		}
+		if cert, err := certs.GetCert(name, baseDir); err == nil && !certs.KeyMatchesCert(key, cert) {
+			return nil, "", fmt.Errorf("the saved key for %s does not match its certificate", name)
+		}
+		source = "reused"
+	default:
+		if key, err = keys.GenerateKey(k.Type, k.Bits, k.Curve); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// existing keys are kept exactly as they are if they are already pem in
+	// the format they are written in, and no encryption was asked for. der
+	// and openssh keys are written as pem servers can read.
+	if keyBytes != nil && k.Encrypt == nil {
+		format := keys.KeyFormat(keyBytes)
+		if format != "" && (format == out.Format || out.Format == "" && format != keys.FormatOpenSSH) {
+			return key, source, keys.WriteKeyFile(pendingPath, keyBytes, out)
+		}
+	}
+	if !formatFits(out.Format, key) {
+		out.Format = ""
+	}
+	if encrypt && out.Password == "" {
+		if out.Password, err = readNewPassword("", false); err != nil {
+			return nil, "", err
+		}
+	}
+	return key, source, keys.SaveKey(key, out, pendingPath)
+}
+
+// promoteKey moves the pending key and csr for name into place once a
+// certificate for them has been saved
+func promoteKey(name, baseDir string) error {
+	pendingKeyPath, err := paths.GetPendingKeyPath(name, baseDir)
+	if err != nil {
+		return err
+	}
+	keyPath, err := paths.GetRsaKeyPath(name, baseDir)
+	if err != nil {
+		return err
+	}
+	pendingCsrPath, err := paths.GetPendingCsrPath(name, baseDir)
+	if err != nil {
+		return err
+	}
+	csrPath, err := paths.GetCsrPath(name, baseDir)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(pendingKeyPath, keyPath); err != nil {
+		return err
+	}
+	return os.Rename(pendingCsrPath, csrPath)
+}
+
+// discardKey removes the pending key and csr for name, leaving the saved
+// key to match the current certificate
+func discardKey(name, baseDir string) {
+	if path, err := paths.GetPendingKeyPath(name, baseDir); err == nil {
+		os.Remove(path)
+	}
+	if path, err := paths.GetPendingCsrPath(name, baseDir); err == nil {
+		os.Remove(path)
+	}
+}
+
+// output works out how to write the key for the name, starting from how
+// the saved key was written and applying anything set in k, and whether
+// the key should be encrypted
+func (k LeafKey) output(keyPath string) (keys.KeyOutput, bool) {
+	out, encrypt, err := keys.DetectKeyOutput(keyPath)
+	if err != nil {
+		// there is no saved key, or it is unreadable and about to be replaced
+		out, encrypt = keys.KeyOutput{}, false
+	}
+	if k.Output.Format != "" {
+		out.Format = k.Output.Format
+	}
+	if k.Output.Mode != 0 {
+		out.Mode = k.Output.Mode
+	}
+	if k.Output.Owner != "" {
+		out.Owner = k.Output.Owner
+	}
+	if k.Output.Group != "" {
+		out.Group = k.Output.Group
+	}
+	if k.Encrypt != nil {
+		encrypt = *k.Encrypt
+	}
+	if encrypt {
+		out.Password = k.Password
+	}
+	return out, encrypt
+}
+
+// formatFits reports whether a key type can be written in format, so that
+// a key saved as sec1 can be replaced by an rsa key
+func formatFits(format string, key crypto.Signer) bool {
+	switch format {
+	case keys.FormatPKCS1:
+		_, ok := key.(*rsa.PrivateKey)
+		return ok
+	case keys.FormatSEC1:
+		_, ok := key.(*ecdsa.PrivateKey)
+		return ok
+	}
+	return true
+}
+
+// readKey reads a private key file, prompting for its password if it is
+// encrypted and none was given
+func readKey(path, password string) (crypto.Signer, []byte, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if password == "" && keys.IsEncryptedKey(keyBytes) {
+		fmt.Printf("enter password for %s: ", path)
+		bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Print("\n")
+		password = string(bytePassword)
+	}
+	key, err := keys.ParsePrivateKey(keyBytes, []byte(password))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, keyBytes, nil
+}
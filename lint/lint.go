@@ -0,0 +1,292 @@
+package lint
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// DefaultMaxLifetime is the longest a leaf certificate may be valid for in
+// days, matching what browsers accept for tls certificates
+const DefaultMaxLifetime = 398
+
+// Finding is a problem a rule found with a certificate
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Rule checks a certificate, with its issuer if that is known
+type Rule struct {
+	Name  string
+	Check func(cert, issuer *x509.Certificate, conf config.Lint) []Finding
+}
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// Rules is the built-in rule set, in the order findings are reported
+var Rules = []Rule{
+	{"ski-missing", checkSKI},
+	{"aki-missing", checkAKI},
+	{"eku-missing", checkEKU},
+	{"cn-not-in-sans", checkCNInSANs},
+	{"spiffe-svid", checkSPIFFE},
+	{"lifetime", checkLifetime},
+	{"weak-key", checkKeySize},
+	{"key-usage", checkKeyUsage},
+	{"signature-algorithm", checkSignatureAlgorithm},
+	{"validity-past-issuer", checkValidityPastIssuer},
+}
+
+// Check runs every rule not ignored in conf over cert. issuer may be nil if
+// it is not known, skipping the rules that compare against it.
+func Check(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	ignored := map[string]bool{}
+	for _, rule := range conf.Ignore {
+		ignored[rule] = true
+	}
+	var findings []Finding
+	for _, rule := range Rules {
+		if ignored[rule.Name] {
+			continue
+		}
+		for _, finding := range rule.Check(cert, issuer, conf) {
+			finding.Rule = rule.Name
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// HasErrors reports whether any finding is an error
+func HasErrors(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// selfSigned reports whether cert is its own issuer, as a root is
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func errorf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityError, Message: fmt.Sprintf(format, args...)}
+}
+
+func warningf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)}
+}
+
+func checkSKI(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	if len(cert.SubjectKeyId) > 0 {
+		return nil
+	}
+	if cert.IsCA {
+		return []Finding{errorf("ca certificate has no subject key identifier")}
+	}
+	return []Finding{warningf("certificate has no subject key identifier")}
+}
+
+func checkAKI(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	// a self-signed root may leave out its authority key identifier
+	if len(cert.AuthorityKeyId) > 0 || selfSigned(cert) {
+		return nil
+	}
+	return []Finding{errorf("certificate has no authority key identifier")}
+}
+
+func checkEKU(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	if cert.IsCA || len(cert.ExtKeyUsage) > 0 || len(cert.UnknownExtKeyUsage) > 0 {
+		return nil
+	}
+	return []Finding{warningf("certificate has no extended key usage, so it is valid for any purpose")}
+}
+
+func checkCNInSANs(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	if cert.IsCA {
+		return nil
+	}
+	cn := cert.Subject.CommonName
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 && len(cert.EmailAddresses) == 0 && len(cert.URIs) == 0 {
+		if cn == "" {
+			return []Finding{errorf("certificate has neither a common name nor subject alternative names")}
+		}
+		return []Finding{errorf("certificate has no subject alternative names, so clients will not accept its common name %s", cn)}
+	}
+	if cn == "" {
+		return nil
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return nil
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if strings.EqualFold(email, cn) {
+			return nil
+		}
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		for _, addr := range cert.IPAddresses {
+			if addr.Equal(ip) {
+				return nil
+			}
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == cn {
+			return nil
+		}
+	}
+	return []Finding{errorf("common name %s is not one of the subject alternative names", cn)}
+}
+
+// checkSPIFFE holds certificates with a spiffe id to the x.509 svid
+// standard
+func checkSPIFFE(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	spiffe := false
+	for _, u := range cert.URIs {
+		spiffe = spiffe || certs.IsSPIFFEID(u)
+	}
+	if !spiffe || cert.IsCA {
+		return nil
+	}
+	if len(cert.URIs) != 1 {
+		return []Finding{errorf("svid has %d uri names, it must have exactly one", len(cert.URIs))}
+	}
+	var findings []Finding
+	id, err := certs.ParseSPIFFEID(cert.URIs[0].String())
+	if err != nil {
+		findings = append(findings, errorf("%v", err))
+	} else if id.Path == "" {
+		findings = append(findings, errorf("svid spiffe id %s names the trust domain, not a workload", id))
+	}
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		findings = append(findings, errorf("svid does not allow digital signatures"))
+	}
+	// without a subject, the names must be critical to be looked at
+	if len(cert.Subject.Names) == 0 {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidExtensionSubjectAltName) && !ext.Critical {
+				findings = append(findings, errorf("svid has an empty subject but its subject alternative names are not critical"))
+			}
+		}
+	}
+	return findings
+}
+
+func checkLifetime(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	if cert.IsCA {
+		return nil
+	}
+	maxLifetime := conf.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultMaxLifetime
+	}
+	days := int(cert.NotAfter.Sub(cert.NotBefore).Hours()+1) / 24
+	if days > maxLifetime {
+		return []Finding{errorf("certificate is valid for %d days, more than the maximum of %d", days, maxLifetime)}
+	}
+	return nil
+}
+
+func checkKeySize(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < 2048 {
+			return []Finding{errorf("rsa key is %d bits, less than 2048", bits)}
+		}
+		if e := key.E; e < 65537 {
+			return []Finding{warningf("rsa public exponent is %d, less than 65537", e)}
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < 256 {
+			return []Finding{errorf("ecdsa key is on %s, smaller than p-256", key.Curve.Params().Name)}
+		}
+	case ed25519.PublicKey:
+	default:
+		return []Finding{warningf("unrecognized key type %T", cert.PublicKey)}
+	}
+	return nil
+}
+
+func checkKeyUsage(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	var findings []Finding
+	if cert.KeyUsage == 0 {
+		return []Finding{warningf("certificate has no key usage")}
+	}
+	switch cert.PublicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			findings = append(findings, errorf("%s keys cannot be used for key encipherment", keyType(cert)))
+		}
+		if cert.KeyUsage&x509.KeyUsageDataEncipherment != 0 {
+			findings = append(findings, errorf("%s keys cannot be used for data encipherment", keyType(cert)))
+		}
+	}
+	if cert.IsCA {
+		if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			findings = append(findings, errorf("ca certificate does not allow certificate signing"))
+		}
+	} else {
+		if cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+			findings = append(findings, errorf("leaf certificate allows certificate or crl signing"))
+		}
+		if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+			findings = append(findings, warningf("certificate does not allow digital signatures, which tls needs"))
+		}
+	}
+	return findings
+}
+
+func keyType(cert *x509.Certificate) string {
+	if _, ok := cert.PublicKey.(ed25519.PublicKey); ok {
+		return "ed25519"
+	}
+	return "ecdsa"
+}
+
+func checkSignatureAlgorithm(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return []Finding{errorf("certificate is signed with deprecated %s", cert.SignatureAlgorithm)}
+	case x509.UnknownSignatureAlgorithm:
+		return []Finding{warningf("certificate is signed with an unrecognized algorithm")}
+	}
+	return nil
+}
+
+func checkValidityPastIssuer(cert, issuer *x509.Certificate, conf config.Lint) []Finding {
+	if issuer == nil || selfSigned(cert) {
+		return nil
+	}
+	var findings []Finding
+	if cert.NotAfter.After(issuer.NotAfter) {
+		findings = append(findings, errorf("certificate is valid until %s, after its issuer expires on %s",
+			cert.NotAfter.UTC().Format("2006-01-02"), issuer.NotAfter.UTC().Format("2006-01-02")))
+	}
+	if cert.NotBefore.Before(issuer.NotBefore) {
+		findings = append(findings, warningf("certificate is valid from %s, before its issuer on %s",
+			cert.NotBefore.UTC().Format("2006-01-02"), issuer.NotBefore.UTC().Format("2006-01-02")))
+	}
+	return findings
+}
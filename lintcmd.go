@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/lint"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+var lintCommand = &cli.Command{
+	Name:      "lint",
+	Usage:     "check certificates against the built-in lint rules",
+	ArgsUsage: "[<cert file|name|serial>...]",
+	Description: "lints the given certificates, or the root and the current certificate for every\n" +
+		"name if none are given. rules are " + lintRuleNames(),
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "lint every certificate in the inventory, including replaced, expired and revoked ones",
+		},
+		&cli.IntFlag{
+			Name:  "max-lifetime",
+			Usage: "longest a leaf certificate may be valid for in days, overriding lint.max_lifetime",
+		},
+		&cli.BoolFlag{
+			Name: "json",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return LintCerts(c.Args().Slice(), c.Bool("all"), c.Int("max-lifetime"), c.Bool("json"), c.String("basedir"))
+	},
+}
+
+func lintRuleNames() string {
+	names := make([]string, len(lint.Rules))
+	for i, rule := range lint.Rules {
+		names[i] = rule.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+type lintTarget struct {
+	Name     string         `json:"name,omitempty"`
+	Serial   string         `json:"serial"`
+	Subject  string         `json:"subject"`
+	Findings []lint.Finding `json:"findings"`
+
+	cert *x509.Certificate
+	// extra holds intermediates found alongside a certificate in a file
+	extra []*x509.Certificate
+}
+
+// LintCerts runs the lint rules over certificate files, names or serials, or
+// the root and every current certificate if none are given, and fails if any
+// has an error
+func LintCerts(args []string, all bool, maxLifetime int, asJSON bool, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	if maxLifetime > 0 {
+		conf.Lint.MaxLifetime = maxLifetime
+	}
+
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return err
+	}
+	crossCerts, err := certs.GetCrossCerts(baseDir)
+	if err != nil {
+		return err
+	}
+	issuers := append(append([]*x509.Certificate{rootCACert}, retired...), crossCerts...)
+
+	var targets []*lintTarget
+	if len(args) > 0 {
+		for _, arg := range args {
+			target, err := lintTargetFor(arg, baseDir)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, target)
+		}
+	} else {
+		targets = append(targets, &lintTarget{Name: "root", cert: rootCACert})
+		var entries []inventory.Entry
+		if all {
+			if entries, err = inventory.Load(baseDir); err != nil {
+				return err
+			}
+			for i := range entries {
+				if entries[i].Cert, err = inventory.GetCert(entries[i].Serial, baseDir); err != nil {
+					return fmt.Errorf("%s: %v", entries[i].Serial, err)
+				}
+			}
+		} else {
+			children, err := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				if !child.IsDir() {
+					continue
+				}
+				entry, err := currentEntry(child.Name(), baseDir)
+				if err != nil {
+					// names waiting for approval have no certificate yet
+					continue
+				}
+				entries = append(entries, *entry)
+			}
+		}
+		for _, entry := range entries {
+			targets = append(targets, &lintTarget{Name: entry.Name, cert: entry.Cert})
+		}
+	}
+
+	failed := 0
+	for _, target := range targets {
+		target.Serial = inventory.Serial(target.cert)
+		target.Subject = target.cert.Subject.String()
+		issuer := findIssuer(target.cert, append(append([]*x509.Certificate{}, issuers...), target.extra...))
+		target.Findings = lint.Check(target.cert, issuer, conf.Lint)
+		if target.Findings == nil {
+			target.Findings = []lint.Finding{}
+		}
+		if lint.HasErrors(target.Findings) {
+			failed++
+		}
+	}
+
+	if asJSON {
+		if err = json.NewEncoder(os.Stdout).Encode(targets); err != nil {
+			return err
+		}
+	} else {
+		for _, target := range targets {
+			label := target.Name
+			if label == "" {
+				label = target.Subject
+			}
+			if len(target.Findings) == 0 {
+				fmt.Printf("%s %s ok\n", label, target.Serial)
+				continue
+			}
+			fmt.Printf("%s %s\n", label, target.Serial)
+			for _, finding := range target.Findings {
+				fmt.Printf("  %-7s %-20s %s\n", finding.Severity, finding.Rule, finding.Message)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d certificates have lint errors", failed, len(targets))
+	}
+	return nil
+}
+
+// lintTargetFor reads a certificate file, or looks up a serial in the
+// inventory and then a name
+func lintTargetFor(arg, baseDir string) (*lintTarget, error) {
+	if data, err := ioutil.ReadFile(arg); err == nil {
+		found, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+		return &lintTarget{cert: found[0], extra: found[1:]}, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	entry, err := inventory.Get(arg, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		cert, err := inventory.GetCert(entry.Serial, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		return &lintTarget{Name: entry.Name, cert: cert}, nil
+	}
+	if entry, err = currentEntry(arg, baseDir); err != nil {
+		return nil, err
+	}
+	return &lintTarget{Name: arg, cert: entry.Cert}, nil
+}
+
+// findIssuer returns the candidate that signed cert, or nil if none did
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// lintTemplate lints the certificate a template would become, printing
+// warnings and refusing to sign it if there are errors
+func lintTemplate(template *x509.Certificate, conf config.Lint, baseDir string) error {
+	preview, err := certs.PreviewCert(template, baseDir)
+	if err != nil {
+		return err
+	}
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, finding := range lint.Check(preview, rootCACert, conf) {
+		if finding.Severity == lint.SeverityError {
+			errs = append(errs, finding.Rule+": "+finding.Message)
+			continue
+		}
+		fmt.Printf("lint %s: %s: %s\n", finding.Severity, finding.Rule, finding.Message)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("refusing to sign a certificate that fails linting: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package lockfile
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// how long Lock waits for another process to finish
+	timeout = 10 * time.Second
+	// a lock older than this was left behind by a process that died
+	staleAge = time.Minute
+)
+
+// Lock creates the lock file at path, waiting for another process that
+// holds it and taking over locks left behind by ones that died. what names
+// the locked file in the error if it stays locked. The returned function
+// releases the lock.
+func Lock(path, what string) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleAge {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another process, remove %s if none is running", what, path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
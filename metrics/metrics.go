@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/audit"
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/paths"
+)
+
+// signingBuckets are the upper bounds in seconds of the signing latency
+// histogram, matching the prometheus client defaults
+var signingBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Family is a metric and its samples in the prometheus text format
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is a single value of a metric. Suffix is appended to the family
+// name, as histograms need for _bucket, _sum and _count.
+type Sample struct {
+	Suffix string
+	Labels map[string]string
+	Value  float64
+}
+
+// NewHandler serves the metrics for the ca in baseDir, followed by any from
+// extra, in the prometheus text format. Everything is read from disk on each
+// scrape, so counters cover operations from every hancock process.
+func NewHandler(baseDir string, extra func() []Family) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := Collect(baseDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if extra != nil {
+			families = append(families, extra()...)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, families)
+	})
+}
+
+// Collect reads the expiry of the root and current certificates, the age of
+// the crl, and counters and signing latencies from the audit log
+func Collect(baseDir string) ([]Family, error) {
+	now := time.Now()
+	var families []Family
+
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	families = append(families, Family{
+		Name:    "hancock_root_expiry_days",
+		Help:    "Days until the root ca certificate expires.",
+		Type:    "gauge",
+		Samples: []Sample{{Value: daysUntil(rootCACert, now)}},
+	})
+
+	expiry := Family{
+		Name: "hancock_certificate_expiry_days",
+		Help: "Days until the current certificate for each name expires.",
+		Type: "gauge",
+	}
+	children, err := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+		cert, err := certs.GetCert(child.Name(), baseDir)
+		if err != nil {
+			// a name without a certificate is waiting for approval
+			continue
+		}
+		expiry.Samples = append(expiry.Samples, Sample{
+			Labels: map[string]string{"name": child.Name(), "serial": cert.SerialNumber.Text(16)},
+			Value:  daysUntil(cert, now),
+		})
+	}
+	families = append(families, expiry)
+
+	crl, err := crlAge(baseDir, now)
+	if err != nil {
+		return nil, err
+	}
+	families = append(families, crl...)
+
+	entries, err := audit.Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return append(families, fromAudit(entries)...), nil
+}
+
+func daysUntil(cert *x509.Certificate, now time.Time) float64 {
+	return cert.NotAfter.Sub(now).Hours() / 24
+}
+
+// crlAge reports how long ago the crl was issued, if there is one
+func crlAge(baseDir string, now time.Time) ([]Family, error) {
+	data, err := ioutil.ReadFile(paths.GetCRLPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crl: %v", err)
+	}
+	families := []Family{{
+		Name:    "hancock_crl_age_seconds",
+		Help:    "Seconds since the crl was issued.",
+		Type:    "gauge",
+		Samples: []Sample{{Value: now.Sub(crl.TBSCertList.ThisUpdate).Seconds()}},
+	}}
+	if !crl.TBSCertList.NextUpdate.IsZero() {
+		families = append(families, Family{
+			Name:    "hancock_crl_next_update_seconds",
+			Help:    "Seconds until the crl must be reissued.",
+			Type:    "gauge",
+			Samples: []Sample{{Value: crl.TBSCertList.NextUpdate.Sub(now).Seconds()}},
+		})
+	}
+	return families, nil
+}
+
+// fromAudit counts successful operations by profile, failed key unlocks and
+// signing latencies recorded in the audit log
+func fromAudit(entries []audit.Entry) []Family {
+	counters := map[string]*Family{
+		"issue": {
+			Name: "hancock_certificates_issued_total",
+			Help: "Certificates issued, including renewals and approved requests, by profile.",
+		},
+		"renew": {
+			Name: "hancock_certificates_renewed_total",
+			Help: "Certificates renewed, by profile.",
+		},
+		"revoke": {
+			Name: "hancock_certificates_revoked_total",
+			Help: "Certificates revoked, by profile.",
+		},
+	}
+	counts := map[string]map[string]float64{}
+	unlockFailures := map[string]float64{}
+	buckets := make([]float64, len(signingBuckets))
+	var signingSum, signingCount float64
+
+	for _, entry := range entries {
+		operation := entry.Operation
+		if operation == "key.unlock" && entry.Result == audit.ResultFailure {
+			unlockFailures[entry.Details["key"]]++
+			continue
+		}
+		if entry.Result != audit.ResultSuccess {
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(entry.Details["sign_seconds"], 64); err == nil {
+			for i, bound := range signingBuckets {
+				if seconds <= bound {
+					buckets[i]++
+				}
+			}
+			signingSum += seconds
+			signingCount++
+		}
+		if operation == "request.approve" && entry.Details["serial"] != "" {
+			operation = "issue"
+		}
+		if _, ok := counters[operation]; !ok {
+			continue
+		}
+		if counts[operation] == nil {
+			counts[operation] = map[string]float64{}
+		}
+		counts[operation][entry.Details["profile"]]++
+	}
+
+	var families []Family
+	for _, operation := range []string{"issue", "renew", "revoke"} {
+		family := counters[operation]
+		family.Type = "counter"
+		for _, profile := range sortedKeys(counts[operation]) {
+			family.Samples = append(family.Samples, Sample{
+				Labels: map[string]string{"profile": profile},
+				Value:  counts[operation][profile],
+			})
+		}
+		families = append(families, *family)
+	}
+
+	unlock := Family{
+		Name: "hancock_key_unlock_failures_total",
+		Help: "Failed attempts to unlock a ca key, by key.",
+		Type: "counter",
+	}
+	for _, key := range sortedKeys(unlockFailures) {
+		unlock.Samples = append(unlock.Samples, Sample{Labels: map[string]string{"key": key}, Value: unlockFailures[key]})
+	}
+	families = append(families, unlock)
+
+	signing := Family{
+		Name: "hancock_signing_duration_seconds",
+		Help: "Time taken to sign certificates with the root key.",
+		Type: "histogram",
+	}
+	for i, bound := range signingBuckets {
+		signing.Samples = append(signing.Samples, Sample{
+			Suffix: "_bucket",
+			Labels: map[string]string{"le": strconv.FormatFloat(bound, 'g', -1, 64)},
+			Value:  buckets[i],
+		})
+	}
+	signing.Samples = append(signing.Samples,
+		Sample{Suffix: "_bucket", Labels: map[string]string{"le": "+Inf"}, Value: signingCount},
+		Sample{Suffix: "_sum", Value: signingSum},
+		Sample{Suffix: "_count", Value: signingCount},
+	)
+	return append(families, signing)
+}
+
+// Write renders metric families in the prometheus text format
+func Write(w io.Writer, families []Family) error {
+	for _, family := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.Name, family.Help, family.Name, family.Type); err != nil {
+			return err
+		}
+		for _, sample := range family.Samples {
+			if _, err := fmt.Fprintf(w, "%s%s%s %s\n", family.Name, sample.Suffix, formatLabels(sample.Labels), formatValue(sample.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for name, value := range labels {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
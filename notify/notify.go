@@ -0,0 +1,300 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/paths"
+)
+
+const (
+	// RootName is the name notices about the root ca certificate use
+	RootName = "root"
+
+	ChannelEmail = "email"
+)
+
+// DefaultThresholds are the days before expiry notices are sent at
+var DefaultThresholds = []int{30, 14, 7, 1}
+
+// Notice warns that a certificate has reached an expiry threshold
+type Notice struct {
+	Name      string    `json:"name"`
+	Serial    string    `json:"serial"`
+	Subject   string    `json:"subject"`
+	NotAfter  time.Time `json:"not_after"`
+	Days      int       `json:"days"`
+	Threshold int       `json:"threshold"`
+	Expired   bool      `json:"expired"`
+	Contacts  []string  `json:"contacts,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+}
+
+// Message is a one line summary of the notice
+func (n Notice) Message() string {
+	if n.Expired {
+		return fmt.Sprintf("%s expired on %s", n.Name, n.NotAfter.Local().Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s expires in %d days, on %s", n.Name, n.Days, n.NotAfter.Local().Format("2006-01-02"))
+}
+
+// State records which notices were sent on which channel, keyed by serial
+// and threshold, so each is only sent once
+type State map[string]map[string]time.Time
+
+func (s State) sent(notice Notice, channel string) bool {
+	_, ok := s[stateKey(notice)][channel]
+	return ok
+}
+
+func (s State) record(notice Notice, channel string, at time.Time) {
+	key := stateKey(notice)
+	if s[key] == nil {
+		s[key] = map[string]time.Time{}
+	}
+	s[key][channel] = at.UTC()
+}
+
+func stateKey(notice Notice) string {
+	return notice.Serial + "/" + strconv.Itoa(notice.Threshold)
+}
+
+func LoadState(baseDir string) (State, error) {
+	bytes, err := ioutil.ReadFile(paths.GetNotifyStatePath(baseDir))
+	if os.IsNotExist(err) {
+		return State{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := State{}
+	return state, json.Unmarshal(bytes, &state)
+}
+
+func (s State) Save(baseDir string) error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(paths.GetNotifyStatePath(baseDir), append(bytes, '\n'), 0644)
+}
+
+// Check returns a notice for the root and every current certificate that has
+// passed a threshold. Revoked certificates are left out.
+func Check(conf config.Notify, baseDir string, now time.Time) ([]Notice, error) {
+	thresholds := append([]int{}, conf.Thresholds...)
+	if len(thresholds) == 0 {
+		thresholds = append(thresholds, DefaultThresholds...)
+	}
+	sort.Ints(thresholds)
+
+	entries, err := inventory.Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	bySerial := map[string]inventory.Entry{}
+	for _, entry := range entries {
+		bySerial[entry.Serial] = entry
+	}
+
+	var notices []Notice
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if notice, ok := check(RootName, rootCACert, thresholds, now); ok {
+		notices = append(notices, notice)
+	}
+
+	children, err := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+		cert, err := certs.GetCert(child.Name(), baseDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", child.Name(), err)
+		}
+		entry := bySerial[inventory.Serial(cert)]
+		if entry.Status == inventory.StatusRevoked {
+			continue
+		}
+		if notice, ok := check(child.Name(), cert, thresholds, now); ok {
+			notice.Contacts = append(append([]string{}, conf.Contacts[child.Name()]...), entry.Contacts()...)
+			notice.Owner = entry.Metadata[inventory.MetaOwner]
+			notices = append(notices, notice)
+		}
+	}
+	return notices, nil
+}
+
+// check finds the lowest threshold a certificate has passed, with expired
+// certificates getting a threshold of zero
+func check(name string, cert *x509.Certificate, thresholds []int, now time.Time) (Notice, bool) {
+	remaining := cert.NotAfter.Sub(now)
+	notice := Notice{
+		Name:     name,
+		Serial:   inventory.Serial(cert),
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter.UTC(),
+		Days:     int(remaining.Hours() / 24),
+		Expired:  remaining <= 0,
+	}
+	if notice.Expired {
+		return notice, true
+	}
+	for _, threshold := range thresholds {
+		if remaining <= time.Duration(threshold)*24*time.Hour {
+			notice.Threshold = threshold
+			return notice, true
+		}
+	}
+	return notice, false
+}
+
+// Result is the outcome of sending a notice on one channel
+type Result struct {
+	Notice  Notice
+	Channel string
+	Err     error
+}
+
+// Send delivers each notice over email and every webhook, skipping channels
+// it was already sent on, and records what was sent in state
+func Send(notices []Notice, conf config.Notify, state State) []Result {
+	var results []Result
+	for _, notice := range notices {
+		if recipients := append(append([]string{}, conf.Recipients...), notice.Contacts...); conf.SMTP.Host != "" && len(recipients) > 0 {
+			if !state.sent(notice, ChannelEmail) {
+				err := sendEmail(notice, recipients, conf.SMTP)
+				results = append(results, Result{Notice: notice, Channel: ChannelEmail, Err: err})
+				if err == nil {
+					state.record(notice, ChannelEmail, time.Now())
+				}
+			}
+		}
+		for _, webhook := range conf.Webhooks {
+			channel := "webhook " + webhook.URL
+			if state.sent(notice, channel) {
+				continue
+			}
+			err := sendWebhook(notice, webhook)
+			results = append(results, Result{Notice: notice, Channel: channel, Err: err})
+			if err == nil {
+				state.record(notice, channel, time.Now())
+			}
+		}
+	}
+	return results
+}
+
+func sendEmail(notice Notice, recipients []string, server config.SMTP) error {
+	port := server.Port
+	if port == 0 {
+		port = 25
+	}
+	from := server.From
+	if from == "" {
+		from = "hancock@localhost"
+	}
+	var auth smtp.Auth
+	if server.Username != "" {
+		auth = smtp.PlainAuth("", server.Username, server.Password, server.Host)
+	}
+	// recipients and names come from metadata, so they must not be able to
+	// add headers
+	var to, addresses []string
+	for _, recipient := range recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", recipient, err)
+		}
+		to = append(to, address.String())
+		addresses = append(addresses, address.Address)
+	}
+	if strings.ContainsAny(notice.Name, "\r\n") {
+		return fmt.Errorf("certificate name %q contains a line break", notice.Name)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: [hancock] %s\r\n", notice.Message())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s.\r\n\r\n", notice.Message())
+	fmt.Fprintf(&msg, "subject: %s\r\nserial: %s\r\nnot after: %s\r\n", notice.Subject, notice.Serial, notice.NotAfter.Format(time.RFC3339))
+	if notice.Owner != "" {
+		fmt.Fprintf(&msg, "owner: %s\r\n", notice.Owner)
+	}
+	if notice.Name == RootName {
+		fmt.Fprintf(&msg, "\r\nrun hancock init --renew-root to re-issue it with the same key.\r\n")
+	} else {
+		fmt.Fprintf(&msg, "\r\nrun hancock renew to renew it.\r\n")
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", server.Host, port), auth, from, addresses, msg.Bytes())
+}
+
+func sendWebhook(notice Notice, webhook config.Webhook) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	if webhook.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(webhook.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, struct {
+			Notice
+			Message string
+		}{notice, notice.Message()}); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", webhook.URL, resp.Status)
+	}
+	return nil
+}
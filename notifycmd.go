@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/notify"
+	"github.com/urfave/cli/v2"
+)
+
+// default number of minutes between checks in notify --daemon
+const defaultNotifyInterval = 60
+
+var notifyCommand = &cli.Command{
+	Name:  "notify",
+	Usage: "email and post webhooks about certificates close to expiry",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "daemon",
+			Usage: "keep running, checking every notify.interval minutes",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the notifications that are due without sending them",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Bool("daemon") {
+			return RunNotifyDaemon(c.String("basedir"))
+		}
+		return Notify(c.Bool("dry-run"), c.String("basedir"))
+	},
+}
+
+// Notify sends every notification that is due and has not been sent yet
+func Notify(dryRun bool, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	notices, err := notify.Check(conf.Notify, baseDir, time.Now())
+	if err != nil {
+		return err
+	}
+	if dryRun || (conf.Notify.SMTP.Host == "" && len(conf.Notify.Webhooks) == 0) {
+		for _, notice := range notices {
+			fmt.Println(notice.Message())
+		}
+		if dryRun {
+			return nil
+		}
+		return errors.New("no smtp server or webhooks in config.json to send notifications with")
+	}
+
+	state, err := notify.LoadState(baseDir)
+	if err != nil {
+		return err
+	}
+	results := notify.Send(notices, conf.Notify, state)
+	failed := 0
+	for _, result := range results {
+		err := audited(baseDir, "notify", map[string]string{
+			"name":      result.Notice.Name,
+			"serial":    result.Notice.Serial,
+			"threshold": strconv.Itoa(result.Notice.Threshold),
+			"channel":   result.Channel,
+		}, result.Err)
+		if err != nil {
+			fmt.Printf("failed to notify about %s by %s: %v\n", result.Notice.Name, result.Channel, err)
+			failed++
+			continue
+		}
+		fmt.Printf("notified about %s by %s\n", result.Notice.Name, result.Channel)
+	}
+	if err = state.Save(baseDir); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications failed", failed, len(results))
+	}
+	return nil
+}
+
+// RunNotifyDaemon checks for due notifications until it is interrupted,
+// rereading config.json before every check. Failed notifications are retried
+// on the next check.
+func RunNotifyDaemon(baseDir string) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	fmt.Println("notify daemon started")
+	for {
+		if err := Notify(false, baseDir); err != nil {
+			fmt.Println(err)
+		}
+
+		interval := defaultNotifyInterval
+		if conf, err := config.Load(baseDir); err == nil && conf.Notify.Interval > 0 {
+			interval = conf.Notify.Interval
+		}
+		select {
+		case <-time.After(time.Duration(interval) * time.Minute):
+		case <-hup:
+			fmt.Println("checking notifications with the reloaded config")
+		case <-stop:
+			fmt.Println("notify daemon stopped")
+			return nil
+		}
+	}
+}
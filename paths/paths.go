@@ -29,6 +29,13 @@ func GetRsaKeyPath(name string, baseDir string) (string, error) {
 	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/" + name + "/" + name + ".pem", nil
 }
 
+// GetPendingKeyPath is where a new key for name waits until its certificate
+// is issued
+func GetPendingKeyPath(name string, baseDir string) (string, error) {
+	path, err := GetRsaKeyPath(name, baseDir)
+	return path + ".pending", err
+}
+
 func GetCACertPath(baseDir string) string {
 	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/ca.crt"
 }
@@ -52,6 +59,12 @@ func GetCsrPath(name string, baseDir string) (string, error) {
 	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/" + name + "/" + name + ".csr", nil
 }
 
+// GetPendingCsrPath is where the csr for a pending key for name is kept
+func GetPendingCsrPath(name string, baseDir string) (string, error) {
+	path, err := GetCsrPath(name, baseDir)
+	return path + ".pending", err
+}
+
 func CreateDirectories(baseDir string) error {
 	err := os.MkdirAll(strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/"), 0755)
 	if err != nil {
@@ -63,3 +76,139 @@ func CreateDirectories(baseDir string) error {
 	}
 	return os.MkdirAll(strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/")+"/certificates", 0755)
 }
+
+func GetConfigPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/config.json"
+}
+
+func GetCTPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/ct"
+}
+
+func GetCTLogKeyPath(baseDir string) string {
+	return GetCTPath(baseDir) + "/log.pem"
+}
+
+func GetCTEntriesPath(baseDir string) string {
+	return GetCTPath(baseDir) + "/entries.jsonl"
+}
+
+func GetCTLockPath(baseDir string) string {
+	return GetCTPath(baseDir) + "/entries.lock"
+}
+
+func GetCTTreeHeadPath(baseDir string) string {
+	return GetCTPath(baseDir) + "/sth.json"
+}
+
+func GetAuditPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/audit"
+}
+
+func GetAuditLogPath(baseDir string) string {
+	return GetAuditPath(baseDir) + "/audit.log"
+}
+
+func GetAuditLockPath(baseDir string) string {
+	return GetAuditPath(baseDir) + "/audit.lock"
+}
+
+func GetAuditHeadPath(baseDir string) string {
+	return GetAuditPath(baseDir) + "/head.json"
+}
+
+func GetAuditKeyPath(baseDir string) string {
+	return GetAuditPath(baseDir) + "/audit-key.pem"
+}
+
+func GetRequestsPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/requests"
+}
+
+func GetRequestPath(id string, baseDir string) string {
+	return GetRequestsPath(baseDir) + "/" + id + ".json"
+}
+
+func GetIncomingRequestsPath(baseDir string) string {
+	return GetRequestsPath(baseDir) + "/incoming"
+}
+
+func GetRootsPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/roots"
+}
+
+func GetRetiredRootPath(id string, baseDir string) string {
+	return GetRootsPath(baseDir) + "/" + id
+}
+
+func GetRolloverPath(baseDir string) string {
+	return GetRootsPath(baseDir) + "/rollover.json"
+}
+
+func GetCABundlePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/ca-bundle.crt"
+}
+
+func GetCACrossCertPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/ca-cross.crt"
+}
+
+func GetBasePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/")
+}
+
+func GetInventoryPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/inventory"
+}
+
+func GetInventoryIndexPath(baseDir string) string {
+	return GetInventoryPath(baseDir) + "/index.json"
+}
+
+func GetInventoryLockPath(baseDir string) string {
+	return GetInventoryPath(baseDir) + "/index.lock"
+}
+
+func GetInventoryCertPath(serial string, baseDir string) string {
+	return GetInventoryPath(baseDir) + "/" + serial + ".crt"
+}
+
+func GetCRLPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/ca.crl"
+}
+
+func GetNotifyStatePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/notify.json"
+}
+
+func GetPublishPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/public"
+}
+
+func GetTrustBundlePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/bundle.pem"
+}
+
+func GetExportPath(name string, suffix string, baseDir string) (string, error) {
+	err := os.MkdirAll(strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/")+"/certificates/"+name, 0755)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/" + name + "/" + name + suffix, nil
+}
+
+func GetTruststorePath(ext string, baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/truststore" + ext
+}
+
+func GetCAIssuerPath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/ca-issuer.yaml"
+}
+
+func GetSPIFFEStatePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/spiffe.json"
+}
+
+func GetSPIFFEBundlePath(baseDir string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(baseDir, "~", homeDir), "/") + "/certificates/spiffe-bundle.json"
+}
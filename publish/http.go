@@ -0,0 +1,129 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// longest a client may cache files that can change, such as the current
+// root and the crl
+const maxAge = time.Hour
+
+type handler struct {
+	dir     string
+	baseDir string
+	// stamp describes the sources as of the last publish
+	stamp string
+	mu    sync.Mutex
+}
+
+// NewHandler publishes the ca in baseDir to dir and serves it, publishing
+// it again whenever the root, cross certificates or crl change
+func NewHandler(dir, baseDir string) (http.Handler, error) {
+	h := &handler{dir: dir, baseDir: baseDir}
+	if err := h.refresh(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.refresh(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = IndexJSON
+	}
+	h.mu.Lock()
+	data, err := ioutil.ReadFile(filepath.Join(h.dir, filepath.FromSlash(name)))
+	info, statErr := os.Stat(filepath.Join(h.dir, filepath.FromSlash(name)))
+	h.mu.Unlock()
+	if err != nil || statErr != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType(name))
+	switch {
+	case strings.HasPrefix(name, RootsDir+"/"), strings.HasPrefix(name, CrossDir+"/"):
+		// named by fingerprint or serial, so their contents never change
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	case name == CRLDER || name == CRLPEM:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.crlMaxAge().Seconds())))
+	case name == RootPEM || name == RootDER:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	default:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(data))
+}
+
+// crlMaxAge caches the crl no later than its next update
+func (h *handler) crlMaxAge() time.Duration {
+	index, err := ReadIndex(h.dir)
+	if err != nil || index.CRL == nil || index.CRL.NextUpdate == nil {
+		return maxAge
+	}
+	until := time.Until(*index.CRL.NextUpdate)
+	if until < 0 {
+		return 0
+	}
+	if until < maxAge {
+		return until
+	}
+	return maxAge
+}
+
+// refresh publishes again if any source has changed since the last publish
+func (h *handler) refresh() error {
+	var stamp strings.Builder
+	for _, source := range Sources(h.baseDir) {
+		if info, err := os.Stat(source); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", source, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if stamp.String() == h.stamp {
+		return nil
+	}
+	if _, err := Publish(h.dir, h.baseDir); err != nil {
+		return err
+	}
+	h.stamp = stamp.String()
+	return nil
+}
+
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".pem":
+		return "application/x-pem-file"
+	case ".der":
+		return "application/pkix-cert"
+	case ".crl":
+		return "application/pkix-crl"
+	case ".json":
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
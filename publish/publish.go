@@ -0,0 +1,270 @@
+package publish
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/paths"
+)
+
+// stable names of the files in a published directory
+const (
+	RootPEM   = "ca.pem"
+	RootDER   = "ca.der"
+	CRLDER    = "ca.crl"
+	CRLPEM    = "ca.crl.pem"
+	BundlePEM = "ca-bundle.pem"
+	IndexJSON = "index.json"
+	RootsDir  = "roots"
+	CrossDir  = "cross"
+)
+
+// Index describes everything in a published directory
+type Index struct {
+	Generated time.Time `json:"generated"`
+	// Root is the current root, also published under roots/ so that its
+	// url outlives a rollover
+	Root    Cert   `json:"root"`
+	Retired []Cert `json:"retired,omitempty"`
+	Cross   []Cert `json:"cross,omitempty"`
+	CRL     *CRL   `json:"crl,omitempty"`
+	Bundle  File   `json:"bundle"`
+}
+
+// Cert is a published certificate and its fingerprints
+type Cert struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA1      string    `json:"sha1"`
+	SHA256    string    `json:"sha256"`
+	PEM       string    `json:"pem"`
+	DER       string    `json:"der"`
+}
+
+// CRL is the published certificate revocation list
+type CRL struct {
+	ThisUpdate time.Time  `json:"this_update"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+	Revoked    int        `json:"revoked"`
+	SHA256     string     `json:"sha256"`
+	PEM        string     `json:"pem"`
+	DER        string     `json:"der"`
+}
+
+// File is a published file that is not a single certificate
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// Publish writes the root, retired roots, cross certificates, crl and trust
+// bundle of the ca in baseDir to dir, along with an index of them, removing
+// anything published before that is no longer current
+func Publish(dir, baseDir string) (*Index, error) {
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	crossCerts, err := certs.GetCrossCerts(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{RootsDir, CrossDir} {
+		if err = os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	written := map[string]bool{}
+	write := func(name string, data []byte) error {
+		written[name] = true
+		return writeFile(filepath.Join(dir, name), data)
+	}
+	writeCert := func(cert *x509.Certificate, pemName, derName string) (Cert, error) {
+		if err := write(pemName, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})); err != nil {
+			return Cert{}, err
+		}
+		if err := write(derName, cert.Raw); err != nil {
+			return Cert{}, err
+		}
+		sum1 := sha1.Sum(cert.Raw)
+		sum256 := sha256.Sum256(cert.Raw)
+		return Cert{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			Serial:    inventory.Serial(cert),
+			NotBefore: cert.NotBefore.UTC(),
+			NotAfter:  cert.NotAfter.UTC(),
+			SHA1:      hex.EncodeToString(sum1[:]),
+			SHA256:    hex.EncodeToString(sum256[:]),
+			PEM:       pemName,
+			DER:       derName,
+		}, nil
+	}
+
+	index := &Index{Generated: time.Now().UTC()}
+	if index.Root, err = writeCert(rootCACert, RootPEM, RootDER); err != nil {
+		return nil, err
+	}
+	// roots are named by fingerprint, as renewing a root keeps its key
+	for _, cert := range append([]*x509.Certificate{rootCACert}, retired...) {
+		sum := sha256.Sum256(cert.Raw)
+		id := hex.EncodeToString(sum[:])
+		published, err := writeCert(cert, RootsDir+"/"+id+".pem", RootsDir+"/"+id+".der")
+		if err != nil {
+			return nil, err
+		}
+		if cert != rootCACert {
+			index.Retired = append(index.Retired, published)
+		}
+	}
+	for _, cert := range crossCerts {
+		serial := inventory.Serial(cert)
+		published, err := writeCert(cert, CrossDir+"/"+serial+".pem", CrossDir+"/"+serial+".der")
+		if err != nil {
+			return nil, err
+		}
+		index.Cross = append(index.Cross, published)
+	}
+
+	if index.CRL, err = publishCRL(write, baseDir); err != nil {
+		return nil, err
+	}
+
+	// the bundle is the current root and every unexpired retired root
+	var bundle []byte
+	for _, cert := range append([]*x509.Certificate{rootCACert}, retired...) {
+		if time.Now().Before(cert.NotAfter) {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+	}
+	if err = write(BundlePEM, bundle); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bundle)
+	index.Bundle = File{Path: BundlePEM, SHA256: hex.EncodeToString(sum[:])}
+
+	indexBytes, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = write(IndexJSON, append(indexBytes, '\n')); err != nil {
+		return nil, err
+	}
+	return index, removeStale(dir, written)
+}
+
+// publishCRL writes the crl in both encodings, if the ca has one
+func publishCRL(write func(string, []byte) error, baseDir string) (*CRL, error) {
+	data, err := ioutil.ReadFile(paths.GetCRLPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseDERCRL(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crl: %v", err)
+	}
+	if err = write(CRLDER, data); err != nil {
+		return nil, err
+	}
+	if err = write(CRLPEM, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: data})); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	published := &CRL{
+		ThisUpdate: crl.TBSCertList.ThisUpdate.UTC(),
+		Revoked:    len(crl.TBSCertList.RevokedCertificates),
+		SHA256:     hex.EncodeToString(sum[:]),
+		PEM:        CRLPEM,
+		DER:        CRLDER,
+	}
+	if !crl.TBSCertList.NextUpdate.IsZero() {
+		nextUpdate := crl.TBSCertList.NextUpdate.UTC()
+		published.NextUpdate = &nextUpdate
+	}
+	return published, nil
+}
+
+// writeFile replaces a published file in one step, so it is never served
+// half written
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeStale deletes published files that were not written this time, such
+// as a crl that has since been removed
+func removeStale(dir string, written map[string]bool) error {
+	for _, name := range []string{CRLDER, CRLPEM} {
+		if !written[name] {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for _, sub := range []string{RootsDir, CrossDir} {
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !written[sub+"/"+file.Name()] {
+				if err = os.Remove(filepath.Join(dir, sub, file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Sources are the files a published directory is generated from
+func Sources(baseDir string) []string {
+	return []string{
+		paths.GetCACertPath(baseDir),
+		paths.GetCACrossCertPath(baseDir),
+		paths.GetCRLPath(baseDir),
+		paths.GetRootsPath(baseDir),
+		paths.GetRolloverPath(baseDir),
+	}
+}
+
+// ReadIndex reads the index of a published directory
+func ReadIndex(dir string) (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IndexJSON))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	return index, json.Unmarshal(data, index)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/publish"
+	"github.com/urfave/cli/v2"
+)
+
+var publishCommand = &cli.Command{
+	Name:  "publish",
+	Usage: "write the root and cross certificates, crl and trust bundle to a directory to serve",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "directory to publish to, defaulting to public in the base directory",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return Publish(c.String("dir"), c.String("basedir"))
+	},
+}
+
+// Publish writes everything clients need to build and check chains to dir,
+// at urls that stay the same between runs
+func Publish(dir, baseDir string) error {
+	if dir == "" {
+		dir = paths.GetPublishPath(baseDir)
+	}
+	index, err := publish.Publish(dir, baseDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("published %s to %s\n", index.Root.Subject, dir)
+	fmt.Printf("root:    %s sha256 %s\n", index.Root.DER, index.Root.SHA256)
+	for _, cert := range index.Retired {
+		fmt.Printf("retired: %s sha256 %s\n", cert.DER, cert.SHA256)
+	}
+	for _, cert := range index.Cross {
+		fmt.Printf("cross:   %s sha256 %s\n", cert.DER, cert.SHA256)
+	}
+	if index.CRL != nil {
+		fmt.Printf("crl:     %s with %d revoked\n", index.CRL.DER, index.CRL.Revoked)
+	} else {
+		fmt.Println("crl:     none")
+	}
+	fmt.Printf("bundle:  %s\n", index.Bundle.Path)
+	return nil
+}
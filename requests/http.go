@@ -0,0 +1,145 @@
+package requests
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/galenguyer/hancock/audit"
+	"github.com/galenguyer/hancock/paths"
+)
+
+// maximum size of a submitted csr
+const maxCSRSize = 64 * 1024
+
+type handler struct {
+	baseDir     string
+	lifetime    int
+	maxLifetime int
+	submitters  map[string]string
+}
+
+// NewHandler serves the request queue mounted at /requests/. A csr in PEM
+// or DER form is submitted by POSTing it to /requests/ with the bearer
+// token of one of submitters, which maps names to the sha256 hex digest of
+// their token, optionally with name and lifetime query parameters. Its
+// status is fetched from /requests/<id>, which includes the certificate
+// once it has been issued.
+func NewHandler(baseDir string, lifetime, maxLifetime int, submitters map[string]string) http.Handler {
+	return &handler{baseDir: baseDir, lifetime: lifetime, maxLifetime: maxLifetime, submitters: submitters}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/requests/")
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		h.submit(w, r)
+	case r.Method == http.MethodGet && id != "":
+		h.status(w, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// submitter returns the name of the submitter whose bearer token r carries
+func (h *handler) submitter(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for name, expected := range h.submitters {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(expected))) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (h *handler) submit(w http.ResponseWriter, r *http.Request) {
+	requester, ok := h.submitter(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "a submitter's bearer token is required")
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	csrBytes, err := ParseCSR(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lifetime := h.lifetime
+	if value := r.URL.Query().Get("lifetime"); value != "" {
+		if lifetime, err = strconv.Atoi(value); err != nil || lifetime < 1 {
+			writeError(w, http.StatusBadRequest, "invalid lifetime")
+			return
+		}
+		if lifetime > h.maxLifetime {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("lifetime is over the maximum of %d days", h.maxLifetime))
+			return
+		}
+	}
+
+	req, err := Submit(csrBytes, r.URL.Query().Get("name"), "", nil, lifetime, SourceAPI, requester, h.baseDir)
+	details := map[string]string{"source": SourceAPI, "requester": requester, "remote_addr": r.RemoteAddr}
+	if req != nil {
+		details["request"] = req.ID
+		details["name"] = req.Name
+	}
+	if auditErr := audit.Record(h.baseDir, "request.submit", details, err); err == nil {
+		err = auditErr
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/requests/"+req.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(req)
+}
+
+func (h *handler) status(w http.ResponseWriter, id string) {
+	req, err := Get(id, h.baseDir)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	response := struct {
+		*Request
+		Certificate string `json:"certificate,omitempty"`
+	}{Request: req}
+	if req.Status == StatusIssued {
+		path, err := paths.GetCertPath(req.Name, h.baseDir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		certPem, err := ioutil.ReadFile(path)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response.Certificate = string(certPem)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
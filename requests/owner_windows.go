@@ -0,0 +1,9 @@
+package requests
+
+import "os"
+
+// fileOwner is not supported on windows, where dropped requests have no
+// known requester
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,266 @@
+package requests
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/paths"
+)
+
+const (
+	StatusPending = "pending"
+	StatusDenied  = "denied"
+	StatusIssued  = "issued"
+
+	SourceCLI  = "cli"
+	SourceAPI  = "api"
+	SourceFile = "file"
+)
+
+// Request is a certificate signing request waiting for, or having received, a decision
+type Request struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Profile  string `json:"profile,omitempty"`
+	Lifetime int    `json:"lifetime"`
+	// Metadata is recorded in the inventory once the certificate is issued
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	CSR          []byte            `json:"csr"`
+	Source       string            `json:"source"`
+	Requester    string            `json:"requester"`
+	SubmittedAt  time.Time         `json:"submitted_at"`
+	Status       string            `json:"status"`
+	Approvals    []Decision        `json:"approvals,omitempty"`
+	Denial       *Decision         `json:"denial,omitempty"`
+	IssuedSerial string            `json:"issued_serial,omitempty"`
+}
+
+// Decision records who approved or denied a request and when
+type Decision struct {
+	Operator string    `json:"operator"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Submit validates a DER csr and adds it to the pending queue, to be signed
+// with profile and recorded with metadata once approved. If name is empty
+// the csr's common name is used.
+func Submit(csrBytes []byte, name, profile string, metadata map[string]string, lifetime int, source, requester, baseDir string) (*Request, error) {
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = csr.Subject.CommonName
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid certificate name %q", name)
+	}
+
+	id := make([]byte, 8)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	req := &Request{
+		ID:          hex.EncodeToString(id),
+		Name:        name,
+		Profile:     profile,
+		Lifetime:    lifetime,
+		Metadata:    metadata,
+		CSR:         csrBytes,
+		Source:      source,
+		Requester:   requester,
+		SubmittedAt: time.Now().UTC(),
+		Status:      StatusPending,
+	}
+	return req, req.Save(baseDir)
+}
+
+// ParseCSR accepts a csr in PEM or DER form and returns the DER bytes
+func ParseCSR(data []byte) ([]byte, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("unexpected pem block %s", block.Type)
+		}
+		return block.Bytes, nil
+	}
+	if _, err := x509.ParseCertificateRequest(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Ingest queues every csr dropped into the incoming directory, removing
+// each file once it has been queued. The owner of a file is recorded as
+// the requester.
+func Ingest(lifetime int, baseDir string) ([]*Request, error) {
+	err := os.MkdirAll(paths.GetIncomingRequestsPath(baseDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(paths.GetIncomingRequestsPath(baseDir))
+	if err != nil {
+		return nil, err
+	}
+	var queued []*Request
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(paths.GetIncomingRequestsPath(baseDir), file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return queued, err
+		}
+		csrBytes, err := ParseCSR(data)
+		if err != nil {
+			return queued, fmt.Errorf("%s: %v", file.Name(), err)
+		}
+		req, err := Submit(csrBytes, "", "", nil, lifetime, SourceFile, fileOwner(file), baseDir)
+		if err != nil {
+			return queued, fmt.Errorf("%s: %v", file.Name(), err)
+		}
+		queued = append(queued, req)
+		if err = os.Remove(path); err != nil {
+			return queued, err
+		}
+	}
+	return queued, nil
+}
+
+func Get(id, baseDir string) (*Request, error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("invalid request id %q", id)
+	}
+	bytes, err := ioutil.ReadFile(paths.GetRequestPath(id, baseDir))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("request %s not found", id)
+	} else if err != nil {
+		return nil, err
+	}
+	req := &Request{}
+	return req, json.Unmarshal(bytes, req)
+}
+
+// List returns every request in the order it was submitted
+func List(baseDir string) ([]*Request, error) {
+	files, err := ioutil.ReadDir(paths.GetRequestsPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var reqs []*Request
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		req, err := Get(strings.TrimSuffix(file.Name(), ".json"), baseDir)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].SubmittedAt.Before(reqs[j].SubmittedAt)
+	})
+	return reqs, nil
+}
+
+// Pending returns the request waiting for approval for name, or nil if
+// there is none
+func Pending(name, baseDir string) (*Request, error) {
+	reqs, err := List(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range reqs {
+		if req.Name == name && req.Status == StatusPending {
+			return req, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *Request) Save(baseDir string) error {
+	err := os.MkdirAll(paths.GetRequestsPath(baseDir), 0700)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(paths.GetRequestPath(r.ID, baseDir), append(bytes, '\n'), 0600)
+}
+
+// ParsedCSR returns the parsed certificate request
+func (r *Request) ParsedCSR() (*x509.CertificateRequest, error) {
+	return x509.ParseCertificateRequest(r.CSR)
+}
+
+// Approve records an approval by operator, returning whether the request
+// now has the required number of distinct approvals
+func (r *Request) Approve(operator, reason string, approvers []string, required int) (bool, error) {
+	if err := r.checkDecision(operator, approvers); err != nil {
+		return false, err
+	}
+	if operator == r.Requester {
+		return false, fmt.Errorf("%s submitted request %s and cannot approve it", operator, r.ID)
+	}
+	for _, approval := range r.Approvals {
+		if approval.Operator == operator {
+			return false, fmt.Errorf("%s has already approved request %s", operator, r.ID)
+		}
+	}
+	r.Approvals = append(r.Approvals, Decision{Operator: operator, Time: time.Now().UTC(), Reason: reason})
+	return len(r.Approvals) >= Quorum(required), nil
+}
+
+// Quorum is the number of approvals a request needs when required are
+// configured, at least one
+func Quorum(required int) int {
+	if required < 1 {
+		return 1
+	}
+	return required
+}
+
+// Deny rejects the request
+func (r *Request) Deny(operator, reason string, approvers []string) error {
+	if err := r.checkDecision(operator, approvers); err != nil {
+		return err
+	}
+	r.Status = StatusDenied
+	r.Denial = &Decision{Operator: operator, Time: time.Now().UTC(), Reason: reason}
+	return nil
+}
+
+func (r *Request) checkDecision(operator string, approvers []string) error {
+	if r.Status != StatusPending {
+		return fmt.Errorf("request %s is already %s", r.ID, r.Status)
+	}
+	if len(approvers) == 0 {
+		return nil
+	}
+	for _, approver := range approvers {
+		if approver == operator {
+			return nil
+		}
+	}
+	return errors.New(operator + " is not an approver")
+}
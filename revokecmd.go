@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+// default number of days a crl is valid for
+const defaultCRLLifetime = 7
+
+var revokeCommand = &cli.Command{
+	Name:      "revoke",
+	Usage:     "revoke a certificate and issue a new crl",
+	ArgsUsage: "<name|serial>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "reason",
+			Usage: "one of " + revocationReasonNames(),
+			Value: "unspecified",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return RevokeCert(c.Args().First(), c.String("reason"), c.String("password"), c.String("basedir"))
+	},
+}
+
+var crlCommand = &cli.Command{
+	Name:  "crl",
+	Usage: "issue a new crl listing the revoked certificates",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		rootKey, err := unlockRootKey(c.String("password"), c.String("basedir"))
+		if err != nil {
+			return err
+		}
+		return updateCRL(rootKey, c.String("basedir"))
+	},
+}
+
+func revocationReasonNames() string {
+	names := make([]string, 0, len(certs.RevocationReasons))
+	for name := range certs.RevocationReasons {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return certs.RevocationReasons[names[i]] < certs.RevocationReasons[names[j]]
+	})
+	return strings.Join(names, ", ")
+}
+
+// RevokeCert marks the certificate with the given serial, or the current
+// certificate for a name, as revoked in the inventory and issues a new crl
+func RevokeCert(arg, reason, password, baseDir string) (err error) {
+	if arg == "" {
+		return errors.New("a name or serial is required")
+	}
+	details := map[string]string{"reason": reason}
+	defer func() {
+		err = audited(baseDir, "revoke", details, err)
+	}()
+	if _, ok := certs.RevocationReasons[reason]; !ok {
+		return fmt.Errorf("unknown revocation reason %s, use one of %s", reason, revocationReasonNames())
+	}
+
+	entry, err := inventory.Get(arg, baseDir)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		if entry, err = currentEntry(arg, baseDir); err != nil {
+			return err
+		}
+	}
+	details["name"] = entry.Name
+	details["serial"] = entry.Serial
+	details["profile"] = entry.Profile
+	if entry.Status == inventory.StatusRevoked {
+		return fmt.Errorf("%s is already revoked", entry.Serial)
+	}
+
+	// unlock the key first, so a wrong password does not leave the
+	// certificate revoked but off the crl
+	rootKey, err := unlockRootKey(password, baseDir)
+	if err != nil {
+		return err
+	}
+	revokedAt := time.Now().UTC()
+	entry.Status = inventory.StatusRevoked
+	entry.RevokedAt = &revokedAt
+	entry.RevocationReason = reason
+	entry.Cert = nil
+	if err = inventory.Add([]inventory.Entry{*entry}, baseDir); err != nil {
+		return err
+	}
+	fmt.Printf("revoked %s with serial %s\n", entry.Name, entry.Serial)
+	return updateCRL(rootKey, baseDir)
+}
+
+// updateCRL issues a new crl listing every revoked certificate that has not
+// expired yet, including those issued by a root since rolled over
+func updateCRL(rootKey crypto.Signer, baseDir string) (err error) {
+	details := map[string]string{}
+	defer func() {
+		err = audited(baseDir, "crl", details, err)
+	}()
+
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	entries, err := inventory.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	var revoked []certs.Revocation
+	for _, entry := range entries {
+		if entry.Status != inventory.StatusRevoked || time.Now().After(entry.NotAfter) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial %s", entry.Serial)
+		}
+		revocation := certs.Revocation{Serial: serial, Reason: entry.RevocationReason}
+		if entry.RevokedAt != nil {
+			revocation.Time = *entry.RevokedAt
+		}
+		revoked = append(revoked, revocation)
+	}
+
+	lifetime := defaultCRLLifetime
+	if conf.CRL.Lifetime > 0 {
+		lifetime = conf.CRL.Lifetime
+	}
+	crlBytes, err := certs.GenerateCRL(revoked, time.Duration(lifetime)*24*time.Hour, rootKey, baseDir)
+	if err != nil {
+		return err
+	}
+	if err = certs.SaveCRL(crlBytes, baseDir); err != nil {
+		return err
+	}
+	details["revoked"] = strconv.Itoa(len(revoked))
+	fmt.Printf("wrote crl listing %d revoked certificates to %s, valid for %d days\n", len(revoked), paths.GetCRLPath(baseDir), lifetime)
+	return nil
+}
+
+// crlDue reports whether the crl is more than halfway to its next update or
+// was signed by a retired root, or false if the ca has never issued one
+func crlDue(baseDir string) (bool, error) {
+	data, err := ioutil.ReadFile(paths.GetCRLPath(baseDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	crl, err := x509.ParseDERCRL(data)
+	if err != nil {
+		return false, fmt.Errorf("invalid crl: %v", err)
+	}
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return false, err
+	}
+	if rootCACert.CheckCRLSignature(crl) != nil {
+		return true, nil
+	}
+	list := crl.TBSCertList
+	halfway := list.ThisUpdate.Add(list.NextUpdate.Sub(list.ThisUpdate) / 2)
+	return time.Now().After(halfway), nil
+}
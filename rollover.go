@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+var rolloverCommand = &cli.Command{
+	Name:  "rollover",
+	Usage: "replace the root ca with a new key, cross-signing the old and new roots",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "lifetime",
+			Aliases: []string{"t"},
+			Value:   10 * 365,
+		},
+		&cli.StringFlag{
+			Name:  "key-type",
+			Usage: "type of the new root key: rsa, ecdsa or ed25519, defaulting to that of the current root",
+		},
+		&cli.IntFlag{
+			Name:    "bits",
+			Aliases: []string{"b"},
+			Usage:   "size of an rsa root key, defaulting to that of the current root or 4096",
+		},
+		&cli.StringFlag{
+			Name:  "curve",
+			Usage: "curve of an ecdsa root key: P-256, P-384 or P-521, defaulting to that of the current root",
+		},
+		&cli.StringFlag{
+			Name:    "commonname",
+			Aliases: []string{"cn"},
+			Usage:   "defaults to the current common name with its year replaced by this one, numbered if a root already has it",
+		},
+		&cli.StringFlag{
+			Name:    "country",
+			Aliases: []string{"c"},
+		},
+		&cli.StringFlag{
+			Name:    "state",
+			Aliases: []string{"st"},
+		},
+		&cli.StringFlag{
+			Name:    "locality",
+			Aliases: []string{"l"},
+		},
+		&cli.StringFlag{
+			Name:    "organization",
+			Aliases: []string{"o"},
+		},
+		&cli.StringFlag{
+			Name:    "organizationalunit",
+			Aliases: []string{"ou"},
+		},
+		&cli.IntFlag{
+			Name:  "transition",
+			Usage: "days over which renew re-issues leaves under the new root",
+			Value: 30,
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+			Usage:   "password of the current root key",
+			Value:   "",
+		},
+		&cli.StringFlag{
+			Name:  "new-password",
+			Usage: "password for the new root key",
+			Value: "",
+		},
+		&cli.BoolFlag{
+			Name:  "no-password",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return RolloverCA(
+			c.String("key-type"),
+			c.Int("bits"),
+			c.String("curve"),
+			c.Int("lifetime"),
+			c.String("commonname"),
+			c.String("country"),
+			c.String("state"),
+			c.String("locality"),
+			c.String("organization"),
+			c.String("organizationalunit"),
+			c.Int("transition"),
+			c.String("password"),
+			c.String("new-password"),
+			c.Bool("no-password"),
+			c.String("basedir"),
+		)
+	},
+}
+
+// rolloverState records when the last rollover happened so that renew can
+// spread re-issuing leaves across the transition window
+type rolloverState struct {
+	Started        time.Time `json:"started"`
+	TransitionDays int       `json:"transition_days"`
+	PreviousRoot   string    `json:"previous_root"`
+	CurrentRoot    string    `json:"current_root"`
+}
+
+// trailingYear matches the year, and the number of a second rollover within
+// it, that a previous rollover added to the common name
+var trailingYear = regexp.MustCompile(`\s+\d{4}(-\d+)?$`)
+
+// rolloverCommonName replaces the year of the current root's common name
+// with this one, numbering it if that would repeat the common name of the
+// current or a retired root
+func rolloverCommonName(oldCert *x509.Certificate, baseDir string) (string, error) {
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return "", err
+	}
+	taken := map[string]bool{oldCert.Subject.CommonName: true}
+	for _, root := range retired {
+		taken[root.Subject.CommonName] = true
+	}
+	commonname := fmt.Sprintf("%s %d", trailingYear.ReplaceAllString(oldCert.Subject.CommonName, ""), time.Now().Year())
+	for n := 2; taken[commonname]; n++ {
+		commonname = fmt.Sprintf("%s %d-%d", trailingYear.ReplaceAllString(oldCert.Subject.CommonName, ""), time.Now().Year(), n)
+	}
+	return commonname, nil
+}
+
+// rolloverKeyDefaults fills in the type, size and curve of the new root key
+// from the current root key where they are not given
+func rolloverKeyDefaults(keyType string, bits int, curve string, oldKey crypto.PublicKey) (string, int, string) {
+	switch k := oldKey.(type) {
+	case *rsa.PublicKey:
+		if keyType == "" {
+			keyType = keys.TypeRSA
+		}
+		if bits == 0 && keyType == keys.TypeRSA {
+			bits = k.Size() * 8
+		}
+	case *ecdsa.PublicKey:
+		if keyType == "" {
+			keyType = keys.TypeECDSA
+		}
+		if curve == "" && keyType == keys.TypeECDSA {
+			curve = k.Curve.Params().Name
+		}
+	case ed25519.PublicKey:
+		if keyType == "" {
+			keyType = keys.TypeEd25519
+		}
+	}
+	if bits == 0 {
+		bits = 4096
+	}
+	return keyType, bits, curve
+}
+
+func RolloverCA(keyType string, bits int, curve string, lifetime int, commonname, country, state, locality, organization, organizationalUnit string, transition int, password, newPassword string, noPassword bool, baseDir string) (err error) {
+	details := map[string]string{"lifetime": strconv.Itoa(lifetime)}
+	defer func() {
+		err = audited(baseDir, "rollover", details, err)
+	}()
+
+	oldCert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	oldKey, err := unlockRootKey(password, baseDir)
+	if err != nil {
+		return err
+	}
+	details["previous_root"] = certs.RootID(oldCert)
+
+	// the new root keeps the old subject unless told otherwise
+	if commonname == "" {
+		if commonname, err = rolloverCommonName(oldCert, baseDir); err != nil {
+			return err
+		}
+	}
+	inherit := func(value string, old []string) string {
+		if value == "" && len(old) > 0 {
+			return old[0]
+		}
+		return value
+	}
+	country = inherit(country, oldCert.Subject.Country)
+	state = inherit(state, oldCert.Subject.Province)
+	locality = inherit(locality, oldCert.Subject.Locality)
+	organization = inherit(organization, oldCert.Subject.Organization)
+	organizationalUnit = inherit(organizationalUnit, oldCert.Subject.OrganizationalUnit)
+
+	keyType, bits, curve = rolloverKeyDefaults(keyType, bits, curve, oldCert.PublicKey)
+	fmt.Printf("generating new root %s key\n", keyType)
+	newKey, err := keys.GenerateKey(keyType, bits, curve)
+	if err != nil {
+		return err
+	}
+	details["key"] = keys.Describe(newKey.Public())
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	fmt.Println("generating new ca certificate")
+	newCertBytes, err := certs.GenerateRootCACert(newKey, lifetime, commonname, country, state, locality, organization, organizationalUnit, conf.Extensions)
+	if err != nil {
+		return err
+	}
+	newCert, err := x509.ParseCertificate(newCertBytes)
+	if err != nil {
+		return err
+	}
+	details["current_root"] = certs.RootID(newCert)
+	details["common_name"] = commonname
+
+	fmt.Println("cross-signing the old and new roots")
+	oldToNew, err := certs.CrossSignRootCACert(newCert, oldCert, oldKey)
+	if err != nil {
+		return err
+	}
+	newToOld, err := certs.CrossSignRootCACert(oldCert, newCert, newKey)
+	if err != nil {
+		return err
+	}
+
+	newPassword, err = readNewPassword(newPassword, noPassword)
+	if err != nil {
+		return err
+	}
+
+	// move the old root aside before replacing it
+	if err = certs.RetireRootCA(oldCert, newToOld, baseDir); err != nil {
+		return err
+	}
+	if err = keys.SaveRootKey(newKey, newPassword, baseDir); err != nil {
+		return err
+	}
+	if err = certs.SaveRootCACert(newCertBytes, baseDir); err != nil {
+		return err
+	}
+	crossPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: oldToNew})
+	if err = ioutil.WriteFile(paths.GetCACrossCertPath(baseDir), crossPem, 0644); err != nil {
+		return err
+	}
+	if err = certs.SaveCABundle(baseDir); err != nil {
+		return err
+	}
+	// the crl has to be signed by the root relying parties now check it with
+	if _, err = os.Stat(paths.GetCRLPath(baseDir)); err == nil {
+		if err = updateCRL(newKey, baseDir); err != nil {
+			return err
+		}
+	}
+
+	stateBytes, err := json.MarshalIndent(rolloverState{
+		Started:        time.Now().UTC(),
+		TransitionDays: transition,
+		PreviousRoot:   certs.RootID(oldCert),
+		CurrentRoot:    certs.RootID(newCert),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(paths.GetRolloverPath(baseDir), append(stateBytes, '\n'), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("rolled over to %s, the previous root is kept in %s\n", commonname, paths.GetRetiredRootPath(certs.RootID(oldCert), baseDir))
+	fmt.Printf("trust bundle with both roots written to %s\n", paths.GetCABundlePath(baseDir))
+	fmt.Printf("renew will re-issue existing leaves over the next %d days\n", transition)
+	return nil
+}
+
+// isRolloverDue reports whether a leaf issued by a previous root has reached
+// its slot in the transition window, which is chosen by hashing its name so
+// that re-issuance is spread evenly
+func isRolloverDue(name, baseDir string) (bool, error) {
+	bytes, err := ioutil.ReadFile(paths.GetRolloverPath(baseDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	state := rolloverState{}
+	if err = json.Unmarshal(bytes, &state); err != nil {
+		return false, err
+	}
+	if state.TransitionDays <= 0 {
+		return true, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	offset := time.Duration(h.Sum32()%uint32(state.TransitionDays)) * 24 * time.Hour
+	return !time.Now().Before(state.Started.Add(offset)), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/ct"
+	"github.com/galenguyer/hancock/metrics"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/publish"
+	"github.com/galenguyer/hancock/requests"
+	"github.com/urfave/cli/v2"
+)
+
+var serveCommand = &cli.Command{
+	Name:  "serve",
+	Usage: "serve the ca over http",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: "localhost:8080",
+		},
+		&cli.BoolFlag{
+			Name:  "publish",
+			Usage: "publish the root and cross certificates, crl and trust bundle, and serve them at /",
+		},
+		&cli.StringFlag{
+			Name:  "publish-dir",
+			Usage: "directory to publish to, defaulting to public in the base directory",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return Serve(c.String("listen"), c.Bool("publish"), c.String("publish-dir"), c.String("basedir"))
+	},
+}
+
+func Serve(listen string, publishCA bool, publishDir, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.NewHandler(baseDir, nil))
+	fmt.Printf("serving metrics at http://%s/metrics\n", listen)
+	if conf.CT.Enabled {
+		rootCACert, err := certs.GetRootCACert(baseDir)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/ct/v1/", ct.NewHandler(baseDir, []*x509.Certificate{rootCACert}))
+		fmt.Printf("serving ct log at http://%s/ct/v1/\n", listen)
+	}
+	if conf.Approval.Enabled {
+		mux.Handle("/requests/", requests.NewHandler(baseDir, requestLifetime(conf), maxRequestLifetime(conf), conf.Approval.Submitters))
+		fmt.Printf("accepting certificate requests at http://%s/requests/\n", listen)
+	}
+	if publishCA {
+		if publishDir == "" {
+			publishDir = paths.GetPublishPath(baseDir)
+		}
+		// published again on request whenever the root or crl change
+		handler, err := publish.NewHandler(publishDir, baseDir)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/", handler)
+		fmt.Printf("serving %s at http://%s/\n", publishDir, listen)
+	}
+	return http.ListenAndServe(listen, mux)
+}
@@ -0,0 +1,112 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8)
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// exp and log tables for GF(2^8) with the AES polynomial and generator 3
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		// multiply by the generator 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	expTable[255] = expTable[0]
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Split divides secret into parts shares, any threshold of which can
+// reconstruct it. The last byte of each share is its x coordinate.
+func Split(secret []byte, threshold, parts int) ([][]byte, error) {
+	if threshold < 2 || parts < threshold || parts > 255 {
+		return nil, errors.New("shares must be 2 <= threshold <= parts <= 255")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold-1)
+	for b, s := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			x := byte(i + 1)
+			// evaluate the polynomial with horner's method
+			y := byte(0)
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = mul(y^coefficients[c], x)
+			}
+			shares[i][b] = y ^ s
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs a secret from at least threshold shares
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	length := len(shares[0])
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if len(share) != length || length < 2 {
+			return nil, errors.New("shares have different lengths")
+		}
+		x := share[length-1]
+		if x == 0 || seen[x] {
+			return nil, errors.New("shares have duplicate or invalid indexes")
+		}
+		seen[x] = true
+	}
+
+	secret := make([]byte, length-1)
+	for b := range secret {
+		// lagrange interpolation at x = 0
+		value := byte(0)
+		for i, si := range shares {
+			xi := si[length-1]
+			basis := byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				xj := sj[length-1]
+				basis = mul(basis, div(xj, xj^xi))
+			}
+			value ^= mul(si[b], basis)
+		}
+		secret[b] = value
+	}
+	return secret, nil
+}
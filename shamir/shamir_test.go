@@ -0,0 +1,116 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// subsets returns every subset of shares with size members
+func subsets(shares [][]byte, size int) [][][]byte {
+	var result [][][]byte
+	for mask := 0; mask < 1<<len(shares); mask++ {
+		var subset [][]byte
+		for i := range shares {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, shares[i])
+			}
+		}
+		if len(subset) == size {
+			result = append(result, subset)
+		}
+	}
+	return result
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	// 0x53 and 0xca are inverses in the aes field, FIPS 197 section 4.2
+	if got := mul(0x53, 0xca); got != 0x01 {
+		t.Errorf("mul(0x53, 0xca) = %#x, want 0x01", got)
+	}
+	if got := mul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("mul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+	if got := div(0x01, 0x53); got != 0xca {
+		t.Errorf("div(0x01, 0x53) = %#x, want 0xca", got)
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := div(mul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("div(mul(%#x, %#x), %#x) = %#x", a, b, b, got)
+			}
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ threshold, parts int }{{2, 2}, {2, 3}, {3, 5}, {4, 6}, {6, 6}} {
+		shares, err := Split(secret, test.threshold, test.parts)
+		if err != nil {
+			t.Fatalf("%d-of-%d: %v", test.threshold, test.parts, err)
+		}
+		if len(shares) != test.parts {
+			t.Fatalf("%d-of-%d: got %d shares", test.threshold, test.parts, len(shares))
+		}
+		for size := test.threshold; size <= test.parts; size++ {
+			for _, subset := range subsets(shares, size) {
+				got, err := Combine(subset)
+				if err != nil {
+					t.Fatalf("%d-of-%d with %d shares: %v", test.threshold, test.parts, size, err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Fatalf("%d-of-%d with %d shares: did not reconstruct the secret", test.threshold, test.parts, size)
+				}
+			}
+		}
+		// one share too few interpolates a different polynomial
+		if test.threshold > 2 {
+			for _, subset := range subsets(shares, test.threshold-1) {
+				got, err := Combine(subset)
+				if err != nil {
+					t.Fatalf("%d-of-%d with %d shares: %v", test.threshold, test.parts, test.threshold-1, err)
+				}
+				if bytes.Equal(got, secret) {
+					t.Fatalf("%d-of-%d with %d shares: reconstructed the secret", test.threshold, test.parts, test.threshold-1)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	for _, test := range []struct {
+		secret           []byte
+		threshold, parts int
+	}{
+		{[]byte("secret"), 1, 3},
+		{[]byte("secret"), 4, 3},
+		{[]byte("secret"), 2, 256},
+		{nil, 2, 3},
+	} {
+		if _, err := Split(test.secret, test.threshold, test.parts); err == nil {
+			t.Errorf("Split(%q, %d, %d) did not fail", test.secret, test.threshold, test.parts)
+		}
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	shares, err := Split([]byte("secret"), 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, input := range map[string][][]byte{
+		"one share":         shares[:1],
+		"duplicate share":   {shares[0], shares[0]},
+		"different lengths": {shares[0], shares[1][1:]},
+		"zero index":        {shares[0], append([]byte("secret"), 0)},
+	} {
+		if _, err := Combine(input); err == nil {
+			t.Errorf("%s: Combine did not fail", name)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/config"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+var spiffeCommand = &cli.Command{
+	Name:  "spiffe",
+	Usage: "serve the ca as a spiffe trust domain",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "bundle",
+			Usage: "write the spiffe trust bundle of the trust domain as a jwk set",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write the bundle to, - for stdout, defaults to certificates/spiffe-bundle.json in the base directory",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return SPIFFEBundle(c.String("out"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+// spiffeBundle is a spiffe trust bundle in its jwk set form
+type spiffeBundle struct {
+	Keys        []spiffeJWK `json:"keys"`
+	Sequence    int64       `json:"spiffe_sequence"`
+	RefreshHint int         `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// spiffeState remembers the roots in the last bundle, so that its sequence
+// only grows when they change
+type spiffeState struct {
+	Sequence int64    `json:"sequence"`
+	Roots    []string `json:"roots"`
+}
+
+// spiffeJWK is a root ca as an x509-svid authority of a trust bundle
+type spiffeJWK struct {
+	Use string   `json:"use"`
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c"`
+}
+
+// SPIFFEBundle writes the trust bundle of the configured trust domain, the
+// root ca and the retired roots that have not expired yet
+func SPIFFEBundle(out, baseDir string) error {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	if conf.SPIFFE.TrustDomain == "" {
+		return errors.New("no spiffe trust domain is configured, set spiffe.trust_domain in config.json")
+	}
+	roots, err := trustedRoots(false, baseDir)
+	if err != nil {
+		return err
+	}
+	sequence, err := spiffeSequence(roots, baseDir)
+	if err != nil {
+		return err
+	}
+	bundle := spiffeBundle{
+		Sequence:    sequence,
+		RefreshHint: conf.SPIFFE.RefreshHint,
+	}
+	for _, root := range roots {
+		key, err := jwkFromCert(root)
+		if err != nil {
+			return err
+		}
+		bundle.Keys = append(bundle.Keys, key)
+	}
+	data, err := json.MarshalIndent(bundle, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if out == "" {
+		out = paths.GetSPIFFEBundlePath(baseDir)
+	}
+	if err = ioutil.WriteFile(out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote spiffe bundle for %s with %d keys to %s\n", conf.SPIFFE.TrustDomain, len(bundle.Keys), out)
+	return nil
+}
+
+// spiffeSequence returns the sequence of the bundle holding roots, moving it
+// on whenever a root is added, renewed or drops out
+func spiffeSequence(roots []*x509.Certificate, baseDir string) (int64, error) {
+	var fingerprints []string
+	for _, root := range roots {
+		sum := sha256.Sum256(root.Raw)
+		fingerprints = append(fingerprints, hex.EncodeToString(sum[:]))
+	}
+	var state spiffeState
+	data, err := ioutil.ReadFile(paths.GetSPIFFEStatePath(baseDir))
+	if os.IsNotExist(err) {
+		// bundles were numbered by when the current root was issued before
+		// the sequence was kept, so carry on from there
+		state.Sequence = roots[0].NotBefore.Unix() - 1
+	} else if err != nil {
+		return 0, err
+	} else if err = json.Unmarshal(data, &state); err != nil {
+		return 0, err
+	}
+	if data != nil && strings.Join(state.Roots, ",") == strings.Join(fingerprints, ",") {
+		return state.Sequence, nil
+	}
+	state.Sequence++
+	state.Roots = fingerprints
+	if data, err = json.MarshalIndent(state, "", "  "); err != nil {
+		return 0, err
+	}
+	return state.Sequence, ioutil.WriteFile(paths.GetSPIFFEStatePath(baseDir), append(data, '\n'), 0644)
+}
+
+// jwkFromCert describes the public key of a root ca as a jwk
+func jwkFromCert(cert *x509.Certificate) (spiffeJWK, error) {
+	key := spiffeJWK{
+		Use: "x509-svid",
+		Kid: fmt.Sprintf("%x", cert.SubjectKeyId),
+		X5c: []string{base64.StdEncoding.EncodeToString(cert.Raw)},
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		key.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return spiffeJWK{}, fmt.Errorf("unsupported root key type %T", cert.PublicKey)
+	}
+	return key, nil
+}
+
+// SPIFFESubject turns a subject and subject alternative names into those of
+// an x.509 svid for id: the spiffe id is the only uri name and the common
+// name is left out. It also returns the name to save the certificate under
+// if none was given.
+func SPIFFESubject(id string, subject certs.Subject, sans []string, baseDir string) (string, certs.Subject, []string, error) {
+	conf, err := config.Load(baseDir)
+	if err != nil {
+		return "", subject, nil, err
+	}
+	u, err := certs.ParseWorkloadSPIFFEID(id, conf.SPIFFE.TrustDomain)
+	if err != nil {
+		return "", subject, nil, err
+	}
+	altNames, err := certs.ParseSANs(sans)
+	if err != nil {
+		return "", subject, nil, err
+	}
+	if len(altNames.URIs) > 0 {
+		return "", subject, nil, errors.New("an svid can only have its spiffe id as a uri name, leave URI: out of --san")
+	}
+	subject.CommonName = ""
+	name := u.Host + strings.ReplaceAll(u.Path, "/", "_")
+	return name, subject, append([]string{"URI:" + u.String()}, sans...), nil
+}
+
+// checkSPIFFEName refuses to replace a certificate saved as name for
+// another workload, as different spiffe ids can be given the same name
+func checkSPIFFEName(name, san, baseDir string) error {
+	cert, err := certs.GetCert(name, baseDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	id := strings.TrimPrefix(san, "URI:")
+	for _, uri := range cert.URIs {
+		if certs.IsSPIFFEID(uri) && uri.String() == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("a certificate named %s already exists for another workload, give %s a different --name", name, id)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+// flags shared by new and tag for the metadata kept with a certificate
+var metadataFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "owner",
+		Usage: "team that owns the certificate",
+	},
+	&cli.StringFlag{
+		Name:  "contact",
+		Usage: "email addresses to notify about the certificate, separated by commas",
+	},
+	&cli.StringFlag{
+		Name: "environment",
+	},
+	&cli.StringFlag{
+		Name: "service",
+	},
+	&cli.StringFlag{
+		Name: "ticket",
+	},
+	&cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "any other metadata as key=value",
+	},
+}
+
+var tagCommand = &cli.Command{
+	Name:      "tag",
+	Usage:     "set or remove metadata on the current certificate for a name",
+	ArgsUsage: "<name>",
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "remove",
+			Usage: "metadata key to remove",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	}, metadataFlags...),
+	Action: func(c *cli.Context) error {
+		metadata, err := metadataFromFlags(c)
+		if err != nil {
+			return err
+		}
+		return TagCert(c.Args().First(), metadata, c.StringSlice("remove"), c.String("basedir"))
+	},
+}
+
+var listCommand = &cli.Command{
+	Name:  "list",
+	Usage: "list certificates and their metadata",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "selector",
+			Aliases: []string{"l"},
+			Usage:   "only list certificates whose metadata matches, such as environment=prod,owner!=web,ticket",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "include replaced, expired and revoked certificates",
+		},
+		&cli.BoolFlag{
+			Name: "json",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return ListCerts(c.String("selector"), c.Bool("all"), c.Bool("json"), c.String("basedir"))
+	},
+}
+
+var showCommand = &cli.Command{
+	Name:      "show",
+	Usage:     "show a certificate, its metadata and the certificates it replaced",
+	ArgsUsage: "<name|serial>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return ShowCert(c.Args().First(), c.String("basedir"))
+	},
+}
+
+// metadataFromFlags collects the well known metadata flags and labels that
+// were given
+func metadataFromFlags(c *cli.Context) (map[string]string, error) {
+	metadata, err := inventory.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{inventory.MetaOwner, inventory.MetaContact, inventory.MetaEnvironment, inventory.MetaService, inventory.MetaTicket} {
+		if c.IsSet(key) {
+			metadata[key] = c.String(key)
+		}
+	}
+	if err = inventory.ValidateContacts(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// currentEntry returns the inventory entry for the current certificate of
+// name, describing it afresh if it predates the inventory
+func currentEntry(name, baseDir string) (*inventory.Entry, error) {
+	// check first, as looking up the certificate path creates its directory
+	if _, err := os.Stat(paths.GetCertificatesPath(baseDir) + name); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no certificate for %s", name)
+	}
+	cert, err := certs.GetCert(name, baseDir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no certificate for %s", name)
+	} else if err != nil {
+		return nil, err
+	}
+	entry, err := inventory.Get(inventory.Serial(cert), baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		fresh := inventory.NewEntry(cert, name, inventory.SourceHancock)
+		return &fresh, nil
+	}
+	entry.Cert = cert
+	return entry, nil
+}
+
+// TagCert sets and removes metadata on the current certificate for name
+func TagCert(name string, set map[string]string, remove []string, baseDir string) (err error) {
+	if name == "" {
+		return errors.New("certificate name is required")
+	}
+	if len(set) == 0 && len(remove) == 0 {
+		return errors.New("nothing to tag, give metadata flags or --remove")
+	}
+	details := map[string]string{"name": name}
+	defer func() {
+		err = audited(baseDir, "tag", details, err)
+	}()
+
+	entry, err := currentEntry(name, baseDir)
+	if err != nil {
+		return err
+	}
+	details["serial"] = entry.Serial
+	if entry.Metadata == nil {
+		entry.Metadata = map[string]string{}
+	}
+	for key, value := range set {
+		entry.Metadata[key] = value
+	}
+	for _, key := range remove {
+		delete(entry.Metadata, key)
+	}
+	details["metadata"] = inventory.FormatMetadata(entry.Metadata)
+	if err = inventory.Add([]inventory.Entry{*entry}, baseDir); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", name, details["metadata"])
+	return nil
+}
+
+// ListCerts prints the current certificate for every name, or every
+// certificate in the inventory if all is set, whose metadata matches selector
+func ListCerts(selector string, all, asJSON bool, baseDir string) error {
+	sel, err := inventory.ParseSelector(selector)
+	if err != nil {
+		return err
+	}
+
+	var entries []inventory.Entry
+	if all {
+		if entries, err = inventory.Load(baseDir); err != nil {
+			return err
+		}
+	} else {
+		children, err := ioutil.ReadDir(paths.GetCertificatesPath(baseDir))
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if !child.IsDir() {
+				continue
+			}
+			entry, err := currentEntry(child.Name(), baseDir)
+			if err != nil {
+				// names waiting for approval have no certificate yet
+				continue
+			}
+			entries = append(entries, *entry)
+		}
+	}
+
+	var matched []inventory.Entry
+	for _, entry := range entries {
+		if sel.Matches(entry.Metadata) {
+			matched = append(matched, entry)
+		}
+	}
+	if asJSON {
+		if matched == nil {
+			matched = []inventory.Entry{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(matched)
+	}
+	for _, entry := range matched {
+		fmt.Printf("%s %s %s expires %s %s\n", entry.Name, entry.Serial, entryStatus(entry),
+			entry.NotAfter.Local().Format("2006-01-02"), inventory.FormatMetadata(entry.Metadata))
+	}
+	return nil
+}
+
+// entryStatus reports expired certificates as such, which the inventory
+// does not track
+func entryStatus(entry inventory.Entry) string {
+	if entry.Status == inventory.StatusValid && time.Now().After(entry.NotAfter) {
+		return "expired"
+	}
+	return entry.Status
+}
+
+// ShowCert prints the details and metadata of a certificate, given by name
+// or serial, along with the other certificates issued for its name
+func ShowCert(nameOrSerial, baseDir string) error {
+	if nameOrSerial == "" {
+		return errors.New("certificate name or serial is required")
+	}
+	entry, err := inventory.Get(nameOrSerial, baseDir)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		if entry.Cert, err = inventory.GetCert(entry.Serial, baseDir); err != nil {
+			return err
+		}
+	} else if entry, err = currentEntry(nameOrSerial, baseDir); err != nil {
+		return err
+	}
+	cert := entry.Cert
+	altNames, err := certs.SANsFromCert(cert)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("name:        %s\n", entry.Name)
+	fmt.Printf("serial:      %s\n", entry.Serial)
+	fmt.Printf("status:      %s\n", entryStatus(*entry))
+	if entry.RevokedAt != nil {
+		fmt.Printf("revoked:     %s %s\n", entry.RevokedAt.Local().Format(time.RFC3339), entry.RevocationReason)
+	}
+	fmt.Printf("subject:     %s\n", cert.Subject.String())
+	fmt.Printf("sans:        %s\n", strings.Join(altNames.Strings(), " "))
+	fmt.Printf("key:         %s\n", keys.Describe(cert.PublicKey))
+	fmt.Printf("not before:  %s\n", cert.NotBefore.Local().Format(time.RFC3339))
+	fmt.Printf("not after:   %s\n", cert.NotAfter.Local().Format(time.RFC3339))
+	if entry.Profile != "" {
+		fmt.Printf("profile:     %s\n", entry.Profile)
+	}
+	fmt.Printf("source:      %s\n", entry.Source)
+	metaKeys := make([]string, 0, len(entry.Metadata))
+	for key := range entry.Metadata {
+		metaKeys = append(metaKeys, key)
+	}
+	sort.Strings(metaKeys)
+	for _, key := range metaKeys {
+		fmt.Printf("%-12s %s\n", key+":", entry.Metadata[key])
+	}
+
+	entries, err := inventory.Load(baseDir)
+	if err != nil {
+		return err
+	}
+	for _, other := range entries {
+		if other.Name == entry.Name && other.Serial != entry.Serial {
+			fmt.Printf("also issued: %s %s expires %s\n", other.Serial, entryStatus(other), other.NotAfter.Local().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/ct"
+	"github.com/urfave/cli/v2"
+)
+
+var ctCommand = &cli.Command{
+	Name:  "ct",
+	Usage: "inspect the local certificate transparency log",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "sth",
+			Usage: "print a signed tree head for the current log",
+			Action: func(c *cli.Context) error {
+				return CTTreeHead(c.String("basedir"))
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "check the log against the last saved signed tree head",
+			Action: func(c *cli.Context) error {
+				return CTVerify(c.String("basedir"))
+			},
+		},
+		{
+			Name:  "prove",
+			Usage: "print an inclusion proof for an issued certificate",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"n"},
+					Required: true,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return CTProve(c.String("name"), c.String("basedir"))
+			},
+		},
+		{
+			Name:  "consistency",
+			Usage: "print a consistency proof between two tree sizes",
+			Flags: []cli.Flag{
+				&cli.Uint64Flag{
+					Name:     "first",
+					Required: true,
+				},
+				&cli.Uint64Flag{
+					Name:  "second",
+					Usage: "defaults to the current tree size",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return CTConsistency(c.Uint64("first"), c.Uint64("second"), c.String("basedir"))
+			},
+		},
+	},
+}
+
+func CTTreeHead(baseDir string) error {
+	log, err := ct.Open(baseDir)
+	if err != nil {
+		return err
+	}
+	sth, err := log.SignedTreeHead()
+	if err != nil {
+		return err
+	}
+	return printJSON(sth)
+}
+
+func CTVerify(baseDir string) error {
+	log, err := ct.Open(baseDir)
+	if err != nil {
+		return err
+	}
+	sth, err := ct.GetSavedTreeHead(baseDir)
+	if os.IsNotExist(err) {
+		fmt.Println("ct log is empty")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err = log.VerifyTreeHead(sth); err != nil {
+		return err
+	}
+	if log.Size() != sth.TreeSize {
+		return fmt.Errorf("ct log has %d entries but the last signed tree head covers %d", log.Size(), sth.TreeSize)
+	}
+	fmt.Printf("ct log verified, %d entries\n", sth.TreeSize)
+	return nil
+}
+
+func CTProve(name, baseDir string) error {
+	cert, err := certs.GetCert(name, baseDir)
+	if err != nil {
+		return err
+	}
+	log, err := ct.Open(baseDir)
+	if err != nil {
+		return err
+	}
+	index, err := log.FindCertificate(cert)
+	if err != nil {
+		return err
+	}
+	proof, err := log.InclusionProof(index, log.Size())
+	if err != nil {
+		return err
+	}
+	sth, err := log.SignedTreeHead()
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"leaf_index": index,
+		"audit_path": proof,
+		"sth":        sth,
+	})
+}
+
+func CTConsistency(first, second uint64, baseDir string) error {
+	log, err := ct.Open(baseDir)
+	if err != nil {
+		return err
+	}
+	if second == 0 {
+		second = log.Size()
+	}
+	proof, err := log.ConsistencyProof(first, second)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string][][]byte{"consistency": proof})
+}
+
+func printJSON(v interface{}) error {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
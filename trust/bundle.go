@@ -0,0 +1,57 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+)
+
+// system bundles of every trusted root, by distribution
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ca-certificates/extracted/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// Bundle merges roots with the roots the system trusts, leaving out
+// duplicates. It returns the bundle, the system bundle it read, if any was
+// found, and how many certificates it holds.
+func Bundle(roots []*x509.Certificate, opts Options) ([]byte, string, int, error) {
+	var out bytes.Buffer
+	seen := map[string]bool{}
+	add := func(der []byte) {
+		if seen[string(der)] {
+			return
+		}
+		seen[string(der)] = true
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	for _, root := range roots {
+		add(root.Raw)
+	}
+
+	source := ""
+	for _, candidate := range systemBundles {
+		data, err := ioutil.ReadFile(opts.path(candidate))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, "", 0, err
+		}
+		source = opts.path(candidate)
+		for {
+			var block *pem.Block
+			if block, data = pem.Decode(data); block == nil {
+				break
+			}
+			if block.Type == "CERTIFICATE" {
+				add(block.Bytes)
+			}
+		}
+		break
+	}
+	return out.Bytes(), source, len(seen), nil
+}
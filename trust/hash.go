@@ -0,0 +1,119 @@
+package trust
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// asn.1 string tags that openssl canonicalizes before hashing a name
+const (
+	tagUTF8String      = 12
+	tagPrintableString = 19
+	tagT61String       = 20
+	tagIA5String       = 22
+	tagVisibleString   = 26
+	tagUniversalString = 28
+	tagBMPString       = 30
+)
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// SubjectHash is openssl's X509_NAME_hash of the certificate's subject, which
+// names certificates in a hashed directory: the sha-1 of the subject's
+// canonical encoding, with its first four bytes read as a little endian
+// number
+func SubjectHash(cert *x509.Certificate) (string, error) {
+	var rdns []asn1.RawValue
+	if rest, err := asn1.Unmarshal(cert.RawSubject, &rdns); err != nil {
+		return "", err
+	} else if len(rest) > 0 {
+		return "", errors.New("trailing data after subject")
+	}
+
+	// the canonical encoding is each relative distinguished name in turn,
+	// without the sequence around them
+	var canonical []byte
+	for _, rdn := range rdns {
+		var attributes []attributeTypeAndValue
+		if _, err := asn1.UnmarshalWithParams(rdn.FullBytes, &attributes, "set"); err != nil {
+			return "", err
+		}
+		for i, attribute := range attributes {
+			if value, ok := decodeString(attribute.Value); ok {
+				attributes[i].Value = asn1.RawValue{Tag: tagUTF8String, Bytes: []byte(canonicalString(value))}
+			}
+		}
+		encoded, err := asn1.MarshalWithParams(attributes, "set")
+		if err != nil {
+			return "", err
+		}
+		canonical = append(canonical, encoded...)
+	}
+	sum := sha1.Sum(canonical)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum[:4])), nil
+}
+
+// decodeString returns the text of a directory string value
+func decodeString(value asn1.RawValue) (string, bool) {
+	if value.Class != asn1.ClassUniversal {
+		return "", false
+	}
+	switch value.Tag {
+	case tagUTF8String, tagPrintableString, tagT61String, tagIA5String, tagVisibleString:
+		return string(value.Bytes), true
+	case tagBMPString:
+		units := make([]uint16, len(value.Bytes)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(value.Bytes[2*i:])
+		}
+		return string(utf16.Decode(units)), true
+	case tagUniversalString:
+		runes := make([]rune, len(value.Bytes)/4)
+		for i := range runes {
+			runes[i] = rune(binary.BigEndian.Uint32(value.Bytes[4*i:]))
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// canonicalString trims and collapses whitespace and lowercases ascii
+// letters, as openssl does before hashing
+func canonicalString(s string) string {
+	isSpace := func(b byte) bool {
+		return b == ' ' || b == '\t' || b == '\n' || b == '\v' || b == '\f' || b == '\r'
+	}
+	b := []byte(s)
+	for len(b) > 0 && isSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+	var out strings.Builder
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i]&0x80 != 0:
+			out.WriteByte(b[i])
+		case isSpace(b[i]):
+			out.WriteByte(' ')
+			for i+1 < len(b) && isSpace(b[i+1]) {
+				i++
+			}
+		case b[i] >= 'A' && b[i] <= 'Z':
+			out.WriteByte(b[i] + 'a' - 'A')
+		default:
+			out.WriteByte(b[i])
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,392 @@
+package trust
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/galenguyer/hancock/keystore"
+)
+
+// systemStore is where a distribution takes extra trust anchors from, and
+// the command that rebuilds its bundles from them
+type systemStore struct {
+	distro string
+	dir    string
+	ext    string
+	update []string
+}
+
+var systemStores = []systemStore{
+	{"debian", "/usr/local/share/ca-certificates", ".crt", []string{"update-ca-certificates"}},
+	{"rhel", "/etc/pki/ca-trust/source/anchors", ".pem", []string{"update-ca-trust", "extract"}},
+	{"arch", "/etc/ca-certificates/trust-source/anchors", ".crt", []string{"update-ca-trust"}},
+}
+
+// java cacerts locations, after JAVA_HOME
+var javaKeystores = []string{
+	"/etc/ssl/certs/java/cacerts",
+	"/etc/pki/java/cacerts",
+	"/usr/lib/jvm/default/lib/security/cacerts",
+}
+
+// directories of java keystores that are generated from the system store and
+// would lose any root added to them directly
+var generatedJavaKeystores = []string{
+	"/etc/pki/ca-trust/extracted/",
+}
+
+// maximum number of symlinks followed to find a keystore
+const maxLinks = 40
+
+func encodePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// writeFile replaces a file in one step, keeping the mode of the file it
+// replaces
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeFile removes a file, reporting whether it was there
+func removeFile(path string) (bool, error) {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func applySystem(roots []*x509.Certificate, opts Options, install bool) ([]Change, error) {
+	var store *systemStore
+	for i := range systemStores {
+		if exists(opts.path(systemStores[i].dir)) {
+			store = &systemStores[i]
+			break
+		}
+	}
+	if store == nil {
+		return nil, errors.New("no supported system trust store found, looked for debian, rhel and arch anchor directories")
+	}
+
+	var changes []Change
+	for _, root := range roots {
+		path := filepath.Join(opts.path(store.dir), Alias(root)+store.ext)
+		if install {
+			if err := writeFile(path, encodePEM(root), 0644); err != nil {
+				return changes, err
+			}
+			changes = append(changes, Change{Store: StoreSystem, Action: "wrote " + store.distro + " anchor", Path: path})
+		} else {
+			removed, err := removeFile(path)
+			if err != nil {
+				return changes, err
+			}
+			if removed {
+				changes = append(changes, Change{Store: StoreSystem, Action: "removed " + store.distro + " anchor", Path: path})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	change, err := opts.run(StoreSystem, store.update...)
+	return append(changes, change), err
+}
+
+func applyOpenSSL(roots []*x509.Certificate, opts Options, install bool) ([]Change, error) {
+	dir := opts.OpenSSLDir
+	if dir == "" {
+		dir = "/etc/ssl/certs"
+	}
+	dir = opts.path(dir)
+	if install {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, root := range roots {
+		name := Alias(root) + ".pem"
+		hash, err := SubjectHash(root)
+		if err != nil {
+			return changes, err
+		}
+		// links are named by hash, numbered from zero for each certificate
+		// with the same subject
+		link := ""
+		taken := map[string]bool{}
+		for _, file := range files {
+			if !strings.HasPrefix(file.Name(), hash+".") {
+				continue
+			}
+			if target, err := os.Readlink(filepath.Join(dir, file.Name())); err == nil && target == name {
+				link = file.Name()
+			}
+			taken[file.Name()] = true
+		}
+
+		if !install {
+			if link != "" {
+				if err = os.Remove(filepath.Join(dir, link)); err != nil {
+					return changes, err
+				}
+				changes = append(changes, Change{Store: StoreOpenSSL, Action: "removed link", Path: filepath.Join(dir, link)})
+			}
+			removed, err := removeFile(filepath.Join(dir, name))
+			if err != nil {
+				return changes, err
+			}
+			if removed {
+				changes = append(changes, Change{Store: StoreOpenSSL, Action: "removed", Path: filepath.Join(dir, name)})
+			}
+			continue
+		}
+
+		if err = writeFile(filepath.Join(dir, name), encodePEM(root), 0644); err != nil {
+			return changes, err
+		}
+		changes = append(changes, Change{Store: StoreOpenSSL, Action: "wrote", Path: filepath.Join(dir, name)})
+		if link == "" {
+			for n := 0; ; n++ {
+				if link = hash + "." + strconv.Itoa(n); !taken[link] {
+					break
+				}
+			}
+			if err = os.Symlink(name, filepath.Join(dir, link)); err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, Change{Store: StoreOpenSSL, Action: "linked", Path: filepath.Join(dir, link)})
+	}
+	return changes, nil
+}
+
+func applyJava(roots []*x509.Certificate, opts Options, install bool) ([]Change, error) {
+	path := opts.JavaKeystore
+	if path == "" {
+		candidates := javaKeystores
+		if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+			candidates = append([]string{filepath.Join(javaHome, "lib/security/cacerts")}, candidates...)
+		}
+		for _, candidate := range candidates {
+			if exists(opts.path(candidate)) {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return nil, errors.New("no java cacerts found, set JAVA_HOME or give --java-keystore")
+		}
+	}
+	// replacing a symlink would cut it loose from what it points to, so
+	// change the file at the end of it
+	path, err := resolveLinks(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range generatedJavaKeystores {
+		if strings.HasPrefix(path, dir) {
+			return nil, fmt.Errorf("%s is generated from the system store, use --store %s instead", path, StoreSystem)
+		}
+	}
+	path = opts.path(path)
+	password := opts.JavaPassword
+	if password == "" {
+		password = keystore.DefaultPassword
+	}
+
+	// installing into a keystore that does not exist yet creates it as jks,
+	// otherwise the keystore is written back in the format it was read in
+	var ks javaKeystore = jksKeystore{&keystore.KeyStore{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !install {
+		return nil, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if ks, err = decodeJavaKeystore(data, password); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	var changes []Change
+	for _, root := range roots {
+		if install {
+			ks.SetTrustedCert(Alias(root), root)
+			changes = append(changes, Change{Store: StoreJava, Action: "added " + Alias(root) + " to", Path: path})
+		} else if ks.Delete(Alias(root)) {
+			changes = append(changes, Change{Store: StoreJava, Action: "removed " + Alias(root) + " from", Path: path})
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	data, err = ks.encode(password)
+	if err != nil {
+		return nil, err
+	}
+	return changes, writeFile(path, data, 0644)
+}
+
+// javaKeystore is a jks or pkcs#12 keystore
+type javaKeystore interface {
+	SetTrustedCert(alias string, cert *x509.Certificate)
+	Delete(alias string) bool
+	encode(password string) ([]byte, error)
+}
+
+type jksKeystore struct{ *keystore.KeyStore }
+
+func (ks jksKeystore) encode(password string) ([]byte, error) {
+	return ks.EncodeJKS(password)
+}
+
+type pkcs12Keystore struct{ *keystore.PKCS12 }
+
+func (ks pkcs12Keystore) encode(password string) ([]byte, error) {
+	return ks.Encode(password)
+}
+
+// decodeJavaKeystore reads a jks keystore, or the pkcs#12 keystores java 9
+// and later default to
+func decodeJavaKeystore(data []byte, password string) (javaKeystore, error) {
+	if keystore.IsJKS(data) {
+		ks, err := keystore.DecodeJKS(data, password)
+		if err != nil {
+			return nil, err
+		}
+		return jksKeystore{ks}, nil
+	}
+	ks, err := keystore.DecodePKCS12(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12Keystore{ks}, nil
+}
+
+// resolveLinks follows symlinks from path, returning where they end outside
+// the root directory
+func resolveLinks(path string, opts Options) (string, error) {
+	for i := 0; i < maxLinks; i++ {
+		info, err := os.Lstat(opts.path(path))
+		if os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(opts.path(path))
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+	return "", fmt.Errorf("too many levels of symlinks at %s", path)
+}
+
+func applyNSS(roots []*x509.Certificate, opts Options, install bool) ([]Change, error) {
+	dbs := opts.NSSDBs
+	if len(dbs) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dbs = findNSSDBs(opts.path(home))
+	} else {
+		for i, db := range dbs {
+			dbs[i] = opts.path(db)
+		}
+	}
+	if len(dbs) == 0 {
+		return []Change{{Store: StoreNSS, Action: "found no chrome or firefox databases"}}, nil
+	}
+	certutil, err := exec.LookPath("certutil")
+	if err != nil {
+		return nil, errors.New("certutil not found, install libnss3-tools or nss-tools")
+	}
+
+	var changes []Change
+	for _, root := range roots {
+		tmp, err := ioutil.TempFile("", "hancock-*.pem")
+		if err != nil {
+			return changes, err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err = tmp.Write(encodePEM(root)); err != nil {
+			return changes, err
+		}
+		tmp.Close()
+
+		for _, db := range dbs {
+			args := []string{"-d", "sql:" + db, "-D", "-n", Alias(root)}
+			action := "removed " + Alias(root) + " from"
+			if install {
+				// trusted to issue server certificates
+				args = []string{"-d", "sql:" + db, "-A", "-t", "C,,", "-n", Alias(root), "-i", tmp.Name()}
+				action = "added " + Alias(root) + " to"
+			}
+			output, err := exec.Command(certutil, args...).CombinedOutput()
+			if err != nil {
+				// removing a root that was never added is not a failure
+				if !install && strings.Contains(string(output), "could not find certificate") {
+					continue
+				}
+				return changes, fmt.Errorf("certutil %s: %v: %s", db, err, strings.TrimSpace(string(output)))
+			}
+			changes = append(changes, Change{Store: StoreNSS, Action: action, Path: db})
+		}
+	}
+	return changes, nil
+}
+
+// findNSSDBs finds the shared chrome database and every firefox profile
+// database under home
+func findNSSDBs(home string) []string {
+	var dbs []string
+	if exists(filepath.Join(home, ".pki/nssdb/cert9.db")) {
+		dbs = append(dbs, filepath.Join(home, ".pki/nssdb"))
+	}
+	for _, pattern := range []string{".mozilla/firefox/*/cert9.db", "snap/firefox/common/.mozilla/firefox/*/cert9.db"} {
+		matches, _ := filepath.Glob(filepath.Join(home, pattern))
+		for _, match := range matches {
+			dbs = append(dbs, filepath.Dir(match))
+		}
+	}
+	return dbs
+}
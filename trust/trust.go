@@ -0,0 +1,127 @@
+package trust
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/galenguyer/hancock/certs"
+)
+
+// names of the trust stores roots can be installed into
+const (
+	StoreSystem  = "system"
+	StoreOpenSSL = "openssl"
+	StoreJava    = "java"
+	StoreNSS     = "nss"
+)
+
+// Stores lists every store in the order they are installed into
+var Stores = []string{StoreSystem, StoreOpenSSL, StoreJava, StoreNSS}
+
+// Options locate the trust stores. Every path is taken relative to RootDir.
+type Options struct {
+	// RootDir is where the filesystem is rooted, so stores can be tested in
+	// a sandbox. Commands that update the system's own stores only run when
+	// it is empty or /.
+	RootDir string
+	// OpenSSLDir is a hashed certificate directory, defaulting to
+	// /etc/ssl/certs
+	OpenSSLDir string
+	// JavaKeystore defaults to the first cacerts found in JAVA_HOME or the
+	// usual distribution locations
+	JavaKeystore string
+	JavaPassword string
+	// NSSDBs default to the chrome and firefox databases in the home directory
+	NSSDBs []string
+}
+
+// Change describes something done to a trust store
+type Change struct {
+	Store  string
+	Action string
+	Path   string
+}
+
+func (c Change) String() string {
+	if c.Path == "" {
+		return fmt.Sprintf("%s: %s", c.Store, c.Action)
+	}
+	return fmt.Sprintf("%s: %s %s", c.Store, c.Action, c.Path)
+}
+
+// Install adds roots to each of the named stores
+func Install(stores []string, roots []*x509.Certificate, opts Options) ([]Change, error) {
+	return apply(stores, roots, opts, true)
+}
+
+// Uninstall removes roots from each of the named stores
+func Uninstall(stores []string, roots []*x509.Certificate, opts Options) ([]Change, error) {
+	return apply(stores, roots, opts, false)
+}
+
+func apply(stores []string, roots []*x509.Certificate, opts Options, install bool) ([]Change, error) {
+	var changes []Change
+	for _, store := range stores {
+		var storeChanges []Change
+		var err error
+		switch store {
+		case StoreSystem:
+			storeChanges, err = applySystem(roots, opts, install)
+		case StoreOpenSSL:
+			storeChanges, err = applyOpenSSL(roots, opts, install)
+		case StoreJava:
+			storeChanges, err = applyJava(roots, opts, install)
+		case StoreNSS:
+			storeChanges, err = applyNSS(roots, opts, install)
+		default:
+			err = fmt.Errorf("unknown trust store %s", store)
+		}
+		changes = append(changes, storeChanges...)
+		if err != nil {
+			return changes, fmt.Errorf("%s: %v", store, err)
+		}
+	}
+	return changes, nil
+}
+
+// Alias names a root in every store, by the hash of its key so that
+// reinstalling the same root replaces it
+func Alias(root *x509.Certificate) string {
+	return "hancock-" + certs.RootID(root)
+}
+
+// path places a path under the root directory
+func (o Options) path(path string) string {
+	if o.RootDir == "" {
+		return path
+	}
+	return filepath.Join(o.RootDir, path)
+}
+
+// sandboxed reports whether the root directory is not the real one
+func (o Options) sandboxed() bool {
+	return o.RootDir != "" && filepath.Clean(o.RootDir) != "/"
+}
+
+// run runs a command that updates the system's own stores, unless sandboxed
+func (o Options) run(store string, command ...string) (Change, error) {
+	change := Change{Store: store, Action: "ran " + strings.Join(command, " ")}
+	if o.sandboxed() {
+		change.Action = "skipped " + strings.Join(command, " ") + " in --root-dir"
+		return change, nil
+	}
+	output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return change, fmt.Errorf("%s: %v: %s", command[0], err, strings.TrimSpace(string(output)))
+	}
+	return change, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
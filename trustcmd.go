@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/galenguyer/hancock/trust"
+	"github.com/urfave/cli/v2"
+)
+
+// flags shared by trust install and uninstall to pick and locate stores
+var trustStoreFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "store",
+		Usage: "store to change: " + strings.Join(trust.Stores, ", "),
+		Value: cli.NewStringSlice(trust.StoreSystem),
+	},
+	&cli.StringFlag{
+		Name:  "openssl-dir",
+		Usage: "hashed certificate directory for the openssl store",
+		Value: "/etc/ssl/certs",
+	},
+	&cli.StringFlag{
+		Name:  "java-keystore",
+		Usage: "jks or pkcs#12 truststore for the java store, defaulting to the cacerts of JAVA_HOME or the distribution",
+	},
+	&cli.StringFlag{
+		Name:  "java-password",
+		Value: "changeit",
+	},
+	&cli.StringSliceFlag{
+		Name:  "nss-db",
+		Usage: "nss database directory for the nss store, defaulting to those of chrome and firefox",
+	},
+}
+
+var trustCommand = &cli.Command{
+	Name:  "trust",
+	Usage: "install the root ca into trust stores and build trust bundles",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "root-dir",
+			Usage: "treat this directory as / when finding stores, without running update commands",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:  "install",
+			Usage: "trust the root ca, and any unexpired retired roots",
+			Flags: trustStoreFlags,
+			Action: func(c *cli.Context) error {
+				return TrustInstall(c.StringSlice("store"), trustOptions(c), c.String("basedir"))
+			},
+		},
+		{
+			Name:  "uninstall",
+			Usage: "stop trusting the root ca and every retired root",
+			Flags: trustStoreFlags,
+			Action: func(c *cli.Context) error {
+				return TrustUninstall(c.StringSlice("store"), trustOptions(c), c.String("basedir"))
+			},
+		},
+		{
+			Name:  "bundle",
+			Usage: "write the root ca and the roots the system trusts to a single bundle",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write, defaulting to certificates/bundle.pem in the base directory",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return TrustBundle(c.String("out"), trust.Options{RootDir: c.String("root-dir")}, c.String("basedir"))
+			},
+		},
+	},
+}
+
+func trustOptions(c *cli.Context) trust.Options {
+	return trust.Options{
+		RootDir:      c.String("root-dir"),
+		OpenSSLDir:   c.String("openssl-dir"),
+		JavaKeystore: c.String("java-keystore"),
+		JavaPassword: c.String("java-password"),
+		NSSDBs:       c.StringSlice("nss-db"),
+	}
+}
+
+// trustedRoots returns the current root followed by the retired roots,
+// leaving out expired ones unless all is set
+func trustedRoots(all bool, baseDir string) ([]*x509.Certificate, error) {
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	roots := []*x509.Certificate{rootCACert}
+	for _, cert := range retired {
+		if all || time.Now().Before(cert.NotAfter) {
+			roots = append(roots, cert)
+		}
+	}
+	return roots, nil
+}
+
+// TrustInstall adds the root ca to each store
+func TrustInstall(stores []string, opts trust.Options, baseDir string) (err error) {
+	details := map[string]string{"stores": strings.Join(stores, " "), "root_dir": opts.RootDir}
+	defer func() {
+		err = audited(baseDir, "trust.install", details, err)
+	}()
+
+	roots, err := trustedRoots(false, baseDir)
+	if err != nil {
+		return err
+	}
+	changes, err := trust.Install(stores, roots, opts)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return err
+}
+
+// TrustUninstall removes the root ca and every root it replaced from each
+// store
+func TrustUninstall(stores []string, opts trust.Options, baseDir string) (err error) {
+	details := map[string]string{"stores": strings.Join(stores, " "), "root_dir": opts.RootDir}
+	defer func() {
+		err = audited(baseDir, "trust.uninstall", details, err)
+	}()
+
+	roots, err := trustedRoots(true, baseDir)
+	if err != nil {
+		return err
+	}
+	changes, err := trust.Uninstall(stores, roots, opts)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if err == nil && len(changes) == 0 {
+		fmt.Println("the root ca was not installed in any of the stores")
+	}
+	return err
+}
+
+// TrustBundle writes the root ca and the system's trusted roots to out
+func TrustBundle(out string, opts trust.Options, baseDir string) error {
+	if out == "" {
+		out = paths.GetTrustBundlePath(baseDir)
+	}
+	roots, err := trustedRoots(false, baseDir)
+	if err != nil {
+		return err
+	}
+	bundle, source, count, err := trust.Bundle(roots, opts)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		fmt.Println("found no system bundle, only including the root ca")
+	}
+	if err = ioutil.WriteFile(out, bundle, 0644); err != nil {
+		return err
+	}
+	if source != "" {
+		fmt.Printf("wrote %d certificates from the root ca and %s to %s\n", count, source, out)
+	} else {
+		fmt.Printf("wrote %d certificates to %s\n", count, out)
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/galenguyer/hancock/certs"
+	"github.com/galenguyer/hancock/inventory"
+	"github.com/galenguyer/hancock/keys"
+	"github.com/galenguyer/hancock/paths"
+	"github.com/urfave/cli/v2"
+)
+
+// exit codes of verify, the first failing check in this order decides it
+const (
+	verifyExitChain   = 2
+	verifyExitRevoked = 3
+	verifyExitUsage   = 4
+	verifyExitKey     = 5
+)
+
+var verifyPurposes = map[string]x509.ExtKeyUsage{
+	"any":          x509.ExtKeyUsageAny,
+	"server":       x509.ExtKeyUsageServerAuth,
+	"client":       x509.ExtKeyUsageClientAuth,
+	"code-signing": x509.ExtKeyUsageCodeSigning,
+	"email":        x509.ExtKeyUsageEmailProtection,
+	"timestamping": x509.ExtKeyUsageTimeStamping,
+}
+
+var verifyCommand = &cli.Command{
+	Name:      "verify",
+	Usage:     "check a certificate's chain, usage, revocation and key",
+	ArgsUsage: "<cert file|name>",
+	Description: "exits with 2 if the chain does not verify, 3 if the certificate is revoked,\n" +
+		"4 if it is not valid for the purpose or host, and 5 if the key or csr do not match",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "purpose",
+			Usage: "extended key usage to require: any, server, client, code-signing, email or timestamping",
+			Value: "any",
+		},
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "hostname or ip address the certificate must be valid for",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "private key to match against the certificate, defaulting to the key saved for a name",
+		},
+		&cli.StringFlag{
+			Name:  "key-password",
+			Usage: "password for an encrypted key",
+		},
+		&cli.StringFlag{
+			Name:  "csr",
+			Usage: "csr to match against the certificate, defaulting to the csr saved for a name",
+		},
+		&cli.BoolFlag{
+			Name: "json",
+		},
+		&cli.StringFlag{
+			Name:  "basedir",
+			Value: "~/.ca",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return VerifyCert(c.Args().First(), c.String("purpose"), c.String("host"), c.String("key"), c.String("key-password"), c.String("csr"), c.Bool("json"), c.String("basedir"))
+	},
+}
+
+type verifyCheck struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+
+	exitCode int
+}
+
+type verifyReport struct {
+	Name     string        `json:"name,omitempty"`
+	Serial   string        `json:"serial"`
+	Subject  string        `json:"subject"`
+	NotAfter time.Time     `json:"not_after"`
+	Chain    []string      `json:"chain,omitempty"`
+	Checks   []verifyCheck `json:"checks"`
+	Valid    bool          `json:"valid"`
+}
+
+func (r *verifyReport) add(check string, ok bool, exitCode int, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, verifyCheck{Check: check, OK: ok, Detail: fmt.Sprintf(format, args...), exitCode: exitCode})
+}
+
+// VerifyCert checks a certificate file, or the current certificate for a
+// name, against the ca: that it chains to a current or retired root, is
+// valid for a purpose and host, is not revoked, and matches its key and csr
+func VerifyCert(target, purpose, host, keyPath, keyPassword, csrPath string, asJSON bool, baseDir string) error {
+	if target == "" {
+		return errors.New("certificate file or name is required")
+	}
+	usage, ok := verifyPurposes[purpose]
+	if !ok {
+		return fmt.Errorf("unknown purpose %s", purpose)
+	}
+
+	// a file may hold intermediates after the certificate
+	var cert *x509.Certificate
+	var extra []*x509.Certificate
+	name := ""
+	if data, err := ioutil.ReadFile(target); err == nil {
+		found, err := parseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", target, err)
+		}
+		cert, extra = found[0], found[1:]
+	} else if os.IsNotExist(err) {
+		entry, err := currentEntry(target, baseDir)
+		if err != nil {
+			return err
+		}
+		name, cert = target, entry.Cert
+		if keyPath == "" {
+			if path, err := paths.GetRsaKeyPath(name, baseDir); err == nil && fileExists(path) {
+				keyPath = path
+			}
+		}
+		if csrPath == "" {
+			if path, err := paths.GetCsrPath(name, baseDir); err == nil && fileExists(path) {
+				csrPath = path
+			}
+		}
+	} else {
+		return err
+	}
+
+	report := &verifyReport{
+		Name:     name,
+		Serial:   inventory.Serial(cert),
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter.UTC(),
+	}
+
+	// the chain is built to any root the ca has had, so certificates issued
+	// before a rollover still verify
+	rootCACert, err := certs.GetRootCACert(baseDir)
+	if err != nil {
+		return err
+	}
+	retired, err := certs.GetRetiredRootCACerts(baseDir)
+	if err != nil {
+		return err
+	}
+	crossCerts, err := certs.GetCrossCerts(baseDir)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	for _, root := range append([]*x509.Certificate{rootCACert}, retired...) {
+		roots.AddCert(root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range append(crossCerts, extra...) {
+		intermediates.AddCert(intermediate)
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		report.add("chain", false, verifyExitChain, "%v", err)
+	} else {
+		for _, c := range chains[0] {
+			report.Chain = append(report.Chain, c.Subject.String())
+		}
+		report.add("chain", true, 0, "chains to %s, expires %s", chains[0][len(chains[0])-1].Subject.String(), cert.NotAfter.Local().Format("2006-01-02"))
+
+		if usage != x509.ExtKeyUsageAny {
+			_, err = cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}})
+			if err != nil {
+				report.add("purpose", false, verifyExitUsage, "not valid for %s: %v", purpose, err)
+			} else {
+				report.add("purpose", true, 0, "valid for %s", purpose)
+			}
+		}
+	}
+
+	if host != "" {
+		if err = cert.VerifyHostname(host); err != nil {
+			report.add("host", false, verifyExitUsage, "%v", err)
+		} else {
+			report.add("host", true, 0, "valid for %s", host)
+		}
+	}
+
+	revoked, detail, err := checkRevocation(cert, rootCACert, baseDir)
+	if err != nil {
+		return err
+	}
+	report.add("revocation", !revoked, verifyExitRevoked, "%s", detail)
+
+	if keyPath != "" {
+		key, _, err := readKey(keyPath, keyPassword)
+		if err != nil {
+			report.add("key", false, verifyExitKey, "%v", err)
+		} else if !certs.KeyMatchesCert(key, cert) {
+			report.add("key", false, verifyExitKey, "%s is not the key for this certificate", keyPath)
+		} else {
+			report.add("key", true, 0, "%s matches", keyPath)
+		}
+	}
+	if csrPath != "" {
+		ok, detail := checkCSR(csrPath, cert)
+		report.add("csr", ok, verifyExitKey, "%s", detail)
+	}
+
+	exitCode := 0
+	for _, order := range []int{verifyExitChain, verifyExitRevoked, verifyExitUsage, verifyExitKey} {
+		for _, check := range report.Checks {
+			if !check.OK && check.exitCode == order && exitCode == 0 {
+				exitCode = order
+			}
+		}
+	}
+	report.Valid = exitCode == 0
+
+	if asJSON {
+		if err = json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%s %s\n", report.Serial, report.Subject)
+		for _, check := range report.Checks {
+			result := "ok"
+			if !check.OK {
+				result = "FAIL"
+			}
+			fmt.Printf("%-4s %-10s %s\n", result, check.Check, check.Detail)
+		}
+	}
+	if exitCode != 0 {
+		return cli.Exit("", exitCode)
+	}
+	return nil
+}
+
+// checkRevocation looks the certificate up in the inventory and in the crl,
+// if the ca has published one
+func checkRevocation(cert, rootCACert *x509.Certificate, baseDir string) (bool, string, error) {
+	entry, err := inventory.Get(inventory.Serial(cert), baseDir)
+	if err != nil {
+		return false, "", err
+	}
+	if entry != nil && entry.Status == inventory.StatusRevoked {
+		detail := "revoked"
+		if entry.RevokedAt != nil {
+			detail += " at " + entry.RevokedAt.Local().Format(time.RFC3339)
+		}
+		if entry.RevocationReason != "" {
+			detail += ", " + entry.RevocationReason
+		}
+		return true, detail, nil
+	}
+
+	data, err := ioutil.ReadFile(paths.GetCRLPath(baseDir))
+	if os.IsNotExist(err) {
+		if entry == nil {
+			return false, "not issued by this ca, no crl to check", nil
+		}
+		return false, "not revoked", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid crl: %v", err)
+	}
+	if err = rootCACert.CheckCRLSignature(crl); err != nil {
+		return false, "", fmt.Errorf("crl is not signed by the root ca: %v", err)
+	}
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true, "revoked at " + revoked.RevocationTime.Local().Format(time.RFC3339) + " according to the crl", nil
+		}
+	}
+	detail := "not revoked"
+	if crl.HasExpired(time.Now()) {
+		detail += ", but the crl is out of date"
+	}
+	return false, detail, nil
+}
+
+// checkCSR confirms a csr is validly signed and for the certificate's key
+func checkCSR(path string, cert *x509.Certificate) (bool, string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err.Error()
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return false, fmt.Sprintf("%s: %v", path, err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return false, fmt.Sprintf("%s has an invalid signature: %v", path, err)
+	}
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false, err.Error()
+	}
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !bytes.Equal(certKey, csrKey) {
+		return false, fmt.Sprintf("%s is for a different key", path)
+	}
+	return true, fmt.Sprintf("%s matches, for %s", path, keys.Describe(csr.PublicKey))
+}
+
+// parseCertificates reads every certificate in pem data, or a single der one
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var found []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, cert)
+	}
+	if len(found) == 0 {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, errors.New("no certificate found")
+		}
+		found = append(found, cert)
+	}
+	return found, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}